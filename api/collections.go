@@ -175,14 +175,95 @@ func (core *Core) DeleteRecord(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).SendString("Record deleted")
 }
 
+type VerifyFieldRequest struct {
+	Field string `json:"field" validate:"required"`
+	Value string `json:"value" validate:"required"`
+}
+
+type VerifyFieldResponse struct {
+	Valid bool `json:"valid"`
+}
+
+// VerifyField godoc
+// @Summary Verify the value of a hashed field
+// @Description Compares a value with the stored hash of a field in hashed mode
+// @Tags records
+// @Accept json
+// @Produce json
+// @Success 200 {object} VerifyFieldResponse
+// @Router /collections/{name}/records/{id}/verify [post]
+// @Param name path string true "Collection Name"
+// @Param id path string true "Record Id"
+// @Param request body VerifyFieldRequest true "Field and value to verify"
+func (core *Core) VerifyField(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+	recordId := c.Params("id")
+
+	verifyRequest := new(VerifyFieldRequest)
+	if err := core.ParseJsonBody(c.Body(), &verifyRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	valid, err := core.vault.VerifyField(c.Context(), principal, collectionName, recordId, verifyRequest.Field, verifyRequest.Value)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(VerifyFieldResponse{valid})
+}
+
+// ShredSubject godoc
+// @Summary Shred a subject
+// @Description Destroys the key of a subject record, making it and the records referencing it permanently unreadable, and deletes them
+// @Tags records
+// @Accept */*
+// @Produce json
+// @Success 200 {object} _vault.DestructionCertificate
+// @Router /collections/{name}/records/{id}/shred [post]
+// @Param name path string true "Collection Name"
+// @Param id path string true "Subject Record Id"
+func (core *Core) ShredSubject(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+	recordId := c.Params("id")
+
+	certificate, err := core.vault.ShredSubject(c.Context(), principal, collectionName, recordId)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(certificate)
+}
+
+// VerifyCollection godoc
+// @Summary Verify the integrity of a collection
+// @Description Checks the MAC of every record of a collection and reports the records that were modified outside of the vault
+// @Tags collections
+// @Accept */*
+// @Produce json
+// @Success 200 {object} _vault.CollectionVerification
+// @Router /collections/{name}/verify [post]
+// @Param name path string true "Collection Name"
+func (core *Core) VerifyCollection(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+
+	verification, err := core.vault.VerifyCollection(c.Context(), principal, collectionName)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(verification)
+}
+
+// parseFieldsQuery parses the formats of a record to return, e.g. name.plain. The format
+// follows the last dot, so paths into json fields can be requested, e.g. history[*].postcode.masked.
 func parseFieldsQuery(fieldsQuery string) map[string]string {
 	fieldFormats := map[string]string{}
 	for _, field := range strings.Split(fieldsQuery, ",") {
-		splitFieldFormat := strings.Split(field, ".")
-		if len(splitFieldFormat) < 2 {
+		formatIndex := strings.LastIndex(field, ".")
+		if formatIndex < 0 {
 			continue
 		}
-		fieldFormats[splitFieldFormat[0]] = splitFieldFormat[1]
+		fieldFormats[field[:formatIndex]] = field[formatIndex+1:]
 	}
 
 	return fieldFormats
@@ -232,7 +313,7 @@ func (core *Core) GetRecord(c *fiber.Ctx) error {
 	principal := GetSessionPrincipal(c)
 	collectionName := c.Params("name")
 	recordId := c.Params("id")
-	// /records/users/<id>?formats=fname.plain,lname.masked
+	// /records/users/<id>?formats=fname.plain,lname.masked,history[*].postcode.masked
 	fieldsQuery := c.Query("formats")
 
 	if fieldsQuery == "" {
@@ -323,3 +404,40 @@ func (core *Core) SearchRecords(c *fiber.Ctx) error {
 
 	return c.Status(http.StatusOK).JSON(records)
 }
+
+type FPEDecryptRequest struct {
+	RecordId string `json:"record_id" validate:"required"`
+	Field    string `json:"field" validate:"required"`
+	Format   string `json:"format" validate:"required"`
+	Value    string `json:"value" validate:"required"`
+}
+
+type FPEDecryptResponse struct {
+	Value string `json:"value"`
+}
+
+// DecryptFPE godoc
+// @Summary Decrypt a format-preserving encrypted value
+// @Description Reverses the fpe and fpe_last4 formats of a field of a record
+// @Tags records
+// @Accept json
+// @Produce json
+// @Success 200 {object} FPEDecryptResponse
+// @Router /collections/{name}/fpe/decrypt [post]
+// @Param name path string true "Collection Name"
+// @Param request body FPEDecryptRequest true "Encrypted value"
+func (core *Core) DecryptFPE(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+
+	decryptRequest := new(FPEDecryptRequest)
+	if err := core.ParseJsonBody(c.Body(), &decryptRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	value, err := core.vault.DecryptFPE(c.Context(), principal, collectionName, decryptRequest.RecordId, decryptRequest.Field, decryptRequest.Format, decryptRequest.Value)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(FPEDecryptResponse{value})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"testing"
@@ -105,6 +106,76 @@ func TestCollections(t *testing.T) {
 		checkResponse(t, response, http.StatusOK, nil)
 	})
 
+	t.Run("can create and get a record with null fields", func(t *testing.T) {
+		authHeaders := map[string]string{
+			"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+		}
+		contactsCollection := &_vault.Collection{
+			Name: "contacts",
+			Fields: map[string]_vault.Field{
+				"name":  {Type: "name"},
+				"email": {Type: "email", Nullable: true},
+			},
+		}
+		request := newRequest(t, http.MethodPost, "/collections", authHeaders, contactsCollection)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusCreated, nil)
+
+		record := map[string]interface{}{
+			"name":  "John Crawford",
+			"email": nil,
+		}
+		request = newRequest(t, http.MethodPost, "/collections/contacts/records", authHeaders, record)
+		response = performRequest(t, app, request)
+		var returnedRecordId string
+		checkResponse(t, response, http.StatusCreated, &returnedRecordId)
+
+		request = newRequest(t, http.MethodGet, fmt.Sprintf("/collections/contacts/records/%s?formats=name.masked,email.masked", returnedRecordId), authHeaders, nil)
+		response = performRequest(t, app, request)
+		var returnedRecord map[string]interface{}
+		checkResponse(t, response, http.StatusOK, &returnedRecord)
+		if value, ok := returnedRecord["email"]; !ok || value != nil {
+			t.Errorf("Expected a null email, got %v", returnedRecord)
+		}
+	})
+
+	t.Run("can create and get paths of a json record", func(t *testing.T) {
+		authHeaders := map[string]string{
+			"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+		}
+		membersCollection := &_vault.Collection{
+			Name: "members",
+			Fields: map[string]_vault.Field{
+				"history": {Type: "json", Paths: map[string]_vault.JSONPath{
+					"[*].postcode": {Type: "string"},
+				}},
+			},
+		}
+		request := newRequest(t, http.MethodPost, "/collections", authHeaders, membersCollection)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusCreated, nil)
+
+		record := map[string]interface{}{
+			"history": []map[string]string{
+				{"city": "London", "postcode": "SW1A"},
+				{"city": "Leeds", "postcode": "LS1"},
+			},
+		}
+		request = newRequest(t, http.MethodPost, "/collections/members/records", authHeaders, record)
+		response = performRequest(t, app, request)
+		var returnedRecordId string
+		checkResponse(t, response, http.StatusCreated, &returnedRecordId)
+
+		request = newRequest(t, http.MethodGet, fmt.Sprintf("/collections/members/records/%s?formats=history[*].postcode.masked", returnedRecordId), authHeaders, nil)
+		response = performRequest(t, app, request)
+		var returnedRecord map[string]interface{}
+		checkResponse(t, response, http.StatusOK, &returnedRecord)
+		postcodes, ok := returnedRecord["history[*].postcode"].([]interface{})
+		if !ok || len(postcodes) != 2 || postcodes[0] != "*" {
+			t.Errorf("Expected masked postcodes, got %v", returnedRecord)
+		}
+	})
+
 	t.Run("can update a record", func(t *testing.T) {
 		// Create a record to update
 		record := map[string]interface{}{
@@ -191,6 +262,80 @@ func TestCollections(t *testing.T) {
 		checkResponse(t, response, http.StatusNotFound, nil)
 	})
 
+	t.Run("can shred a subject", func(t *testing.T) {
+		record := map[string]interface{}{
+			"name":         "123345",
+			"phone_number": "+447890123456",
+			"dob":          "1970-01-01",
+		}
+		authHeaders := map[string]string{
+			"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+		}
+
+		request := newRequest(t, http.MethodPost, "/collections/customers/records", authHeaders, record)
+		response := performRequest(t, app, request)
+		var returnedRecordId string
+		checkResponse(t, response, http.StatusCreated, &returnedRecordId)
+
+		request = newRequest(t, http.MethodPost, fmt.Sprintf("/collections/customers/records/%s/shred", returnedRecordId), authHeaders, nil)
+		response = performRequest(t, app, request)
+		var certificate _vault.DestructionCertificate
+		checkResponse(t, response, http.StatusOK, &certificate)
+		if certificate.SubjectId != returnedRecordId || certificate.Signature == "" {
+			t.Errorf("Unexpected destruction certificate %+v", certificate)
+		}
+
+		request = newRequest(t, http.MethodGet, fmt.Sprintf("/collections/customers/records/%s?formats=name.plain", returnedRecordId), authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusNotFound, nil)
+
+		request = newRequest(t, http.MethodPost, fmt.Sprintf("/collections/customers/records/%s/shred", returnedRecordId), authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusGone, nil)
+	})
+
+	t.Run("can verify a hashed field", func(t *testing.T) {
+		authHeaders := map[string]string{
+			"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+		}
+		accountsCollection := &_vault.Collection{
+			Name: "accounts",
+			Fields: map[string]_vault.Field{
+				"security_answer": {Type: "string", Mode: _vault.FieldModeHashed},
+			},
+		}
+		request := newRequest(t, http.MethodPost, "/collections", authHeaders, accountsCollection)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusCreated, nil)
+
+		request = newRequest(t, http.MethodPost, "/collections/accounts/records", authHeaders, map[string]interface{}{"security_answer": "Rex"})
+		response = performRequest(t, app, request)
+		var returnedRecordId string
+		checkResponse(t, response, http.StatusCreated, &returnedRecordId)
+
+		request = newRequest(t, http.MethodPost, fmt.Sprintf("/collections/accounts/records/%s/verify", returnedRecordId), authHeaders, VerifyFieldRequest{Field: "security_answer", Value: "Rex"})
+		response = performRequest(t, app, request)
+		var verifyResponse VerifyFieldResponse
+		checkResponse(t, response, http.StatusOK, &verifyResponse)
+		if !verifyResponse.Valid {
+			t.Error("Expected the security answer to be valid")
+		}
+	})
+
+	t.Run("can verify a collection", func(t *testing.T) {
+		authHeaders := map[string]string{
+			"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+		}
+
+		request := newRequest(t, http.MethodPost, "/collections/customers/verify", authHeaders, nil)
+		response := performRequest(t, app, request)
+		var verification _vault.CollectionVerification
+		checkResponse(t, response, http.StatusOK, &verification)
+		if verification.Verified == 0 || len(verification.Tampered) != 0 {
+			t.Errorf("Unexpected collection verification %+v", verification)
+		}
+	})
+
 	t.Run("cant create a bad record", func(t *testing.T) {
 		badRecord := map[string]interface{}{
 			"xxx":          "123345",
@@ -206,6 +351,40 @@ func TestCollections(t *testing.T) {
 		checkResponse(t, response, http.StatusBadRequest, nil)
 	})
 
+	t.Run("constraint failures are listed per field", func(t *testing.T) {
+		authHeaders := map[string]string{
+			"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+		}
+		maxAge := 150
+		patientsCollection := &_vault.Collection{
+			Name: "patients",
+			Fields: map[string]_vault.Field{
+				"status": {Type: "string", Constraints: &_vault.FieldConstraints{Enum: []string{"admitted", "discharged"}}},
+				"age":    {Type: "integer", Constraints: &_vault.FieldConstraints{Max: &maxAge}},
+			},
+		}
+		request := newRequest(t, http.MethodPost, "/collections", authHeaders, patientsCollection)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusCreated, nil)
+
+		record := map[string]interface{}{
+			"status": "unknown",
+			"age":    "200",
+		}
+		request = newRequest(t, http.MethodPost, "/collections/patients/records", authHeaders, record)
+		response = performRequest(t, app, request)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+		}
+		var errorResponse ErrorResponse
+		if err := json.NewDecoder(response.Body).Decode(&errorResponse); err != nil {
+			t.Fatalf("Error parsing response body json: %v", err)
+		}
+		if len(errorResponse.Errors) != 2 {
+			t.Errorf("Expected a failure for both fields, got %v", errorResponse.Errors)
+		}
+	})
+
 	t.Run("unauthenticated user cant crud a collection", func(t *testing.T) { // TODO: Can probably make this a table test?
 		records := []map[string]interface{}{
 			{
@@ -5,6 +5,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/providers/confmap"
@@ -15,17 +17,29 @@ import (
 
 // CoreConfig is used to parameterize a core
 type CoreConfig struct {
-	DATABASE_URL      string
-	ENCRYPTION_SECRET string
-	SIGNING_KEY       string
-	ADMIN_USERNAME    string
-	ADMIN_PASSWORD    string
-	API_HOST          string
-	API_PORT          int
-	LOG_LEVEL         string
-	LOG_FORMAT        string
-	LOG_SINK          string
-	DEV_MODE          bool
+	DATABASE_URL          string
+	KEY_PROVIDER          string
+	PREVIOUS_KEY_PROVIDER string
+	ENCRYPTION_SECRET     string
+	KEYSTORE_PATH         string
+	KEYSTORE_PASSPHRASE   string
+	KMS_URL               string
+	KMS_KEY_ID            string
+	KMS_TOKEN             string
+	SIGNING_KEY           string
+	ARGON2_TIME           int
+	ARGON2_MEMORY         int
+	ARGON2_THREADS        int
+	ADMIN_USERNAME        string
+	ADMIN_PASSWORD        string
+	API_HOST              string
+	API_PORT              int
+	LOG_LEVEL             string
+	LOG_FORMAT            string
+	LOG_SINK              string
+	DEV_MODE              bool
+	FILES_PATH            string
+	SUBJECT_KEYS_PATH     string
 }
 
 // Core is used as the central manager of Vault activity. It is the primary point of
@@ -53,19 +67,35 @@ func ReadConfigs() (*CoreConfig, error) {
 		databaseURLKey      = prefix + "DATABASE_URL"
 		encryptionKeyKey    = prefix + "ENCRYPTION_KEY"
 		encryptionSecretKey = prefix + "ENCRYPTION_SECRET"
+		keyProviderKey      = prefix + "KEY_PROVIDER"
+		previousProviderKey = prefix + "PREVIOUS_KEY_PROVIDER"
+		keystorePathKey     = prefix + "KEYSTORE_PATH"
+		keystorePassKey     = prefix + "KEYSTORE_PASSPHRASE"
+		kmsUrlKey           = prefix + "KMS_URL"
+		kmsKeyIdKey         = prefix + "KMS_KEY_ID"
+		kmsTokenKey         = prefix + "KMS_TOKEN"
 		signingKeyKey       = prefix + "SIGNING_KEY"
+		argon2TimeKey       = prefix + "ARGON2_TIME"
+		argon2MemoryKey     = prefix + "ARGON2_MEMORY"
+		argon2ThreadsKey    = prefix + "ARGON2_THREADS"
 		adminUsernameKey    = prefix + "ADMIN_USERNAME"
 		adminPasswordKey    = prefix + "ADMIN_PASSWORD"
+		filesPathKey        = prefix + "FILES_PATH"
+		subjectKeysPathKey  = prefix + "SUBJECT_KEYS_PATH"
 	)
 
 	// Set default values
 	err := k.Load(confmap.Provider(map[string]interface{}{
-		apiHostKey:   "0.0.0.0",
-		apiPortKey:   3000,
-		logLevelKey:  "info",
-		logSinkKey:   "stdout",
-		logFormatKey: "json",
-		devModeKey:   false,
+		apiHostKey:       "0.0.0.0",
+		apiPortKey:       3000,
+		logLevelKey:      "info",
+		logSinkKey:       "stdout",
+		logFormatKey:     "json",
+		devModeKey:       false,
+		keyProviderKey:   "secret",
+		argon2TimeKey:    _vault.DefaultArgon2Time,
+		argon2MemoryKey:  _vault.DefaultArgon2Memory,
+		argon2ThreadsKey: _vault.DefaultArgon2Threads,
 	}, "_"), nil)
 
 	if err != nil {
@@ -80,8 +110,18 @@ func ReadConfigs() (*CoreConfig, error) {
 	}
 
 	conf.DATABASE_URL = k.String(databaseURLKey)
+	conf.KEY_PROVIDER = k.String(keyProviderKey)
+	conf.PREVIOUS_KEY_PROVIDER = k.String(previousProviderKey)
 	conf.ENCRYPTION_SECRET = k.String(encryptionSecretKey)
+	conf.KEYSTORE_PATH = k.String(keystorePathKey)
+	conf.KEYSTORE_PASSPHRASE = k.String(keystorePassKey)
+	conf.KMS_URL = k.String(kmsUrlKey)
+	conf.KMS_KEY_ID = k.String(kmsKeyIdKey)
+	conf.KMS_TOKEN = k.String(kmsTokenKey)
 	conf.SIGNING_KEY = k.String(signingKeyKey)
+	conf.ARGON2_TIME = k.Int(argon2TimeKey)
+	conf.ARGON2_MEMORY = k.Int(argon2MemoryKey)
+	conf.ARGON2_THREADS = k.Int(argon2ThreadsKey)
 	conf.ADMIN_USERNAME = k.String(adminUsernameKey)
 	conf.ADMIN_PASSWORD = k.String(adminPasswordKey)
 	conf.API_HOST = k.String(apiHostKey)
@@ -90,15 +130,61 @@ func ReadConfigs() (*CoreConfig, error) {
 	conf.LOG_FORMAT = k.String(logFormatKey)
 	conf.LOG_SINK = k.String(logSinkKey)
 	conf.DEV_MODE = k.Bool(devModeKey)
+	conf.FILES_PATH = k.String(filesPathKey)
+	conf.SUBJECT_KEYS_PATH = k.String(subjectKeysPathKey)
 
 	return conf, nil
 }
 
 func ValidateCoreConfig(cc *CoreConfig) error {
-	// Todo: Validate the configuration
+	// Todo: Validate the rest of the configuration
+	if err := validateKeyProvider(cc, cc.KEY_PROVIDER); err != nil {
+		return err
+	}
+	if cc.PREVIOUS_KEY_PROVIDER != "" {
+		if cc.KEY_PROVIDER != "shamir" || cc.PREVIOUS_KEY_PROVIDER == "shamir" {
+			return errors.New("THORN_PREVIOUS_KEY_PROVIDER can only be set to move keys to the shamir key provider")
+		}
+		if err := validateKeyProvider(cc, cc.PREVIOUS_KEY_PROVIDER); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func validateKeyProvider(cc *CoreConfig, name string) error {
+	switch name {
+	case "secret":
+		if cc.ENCRYPTION_SECRET == "" {
+			return errors.New("THORN_ENCRYPTION_SECRET is required by the secret key provider")
+		}
+	case "keystore":
+		if cc.KEYSTORE_PATH == "" || cc.KEYSTORE_PASSPHRASE == "" {
+			return errors.New("THORN_KEYSTORE_PATH and THORN_KEYSTORE_PASSPHRASE are required by the keystore key provider")
+		}
+	case "kms":
+		if cc.KMS_URL == "" || cc.KMS_KEY_ID == "" {
+			return errors.New("THORN_KMS_URL and THORN_KMS_KEY_ID are required by the kms key provider")
+		}
+	case "shamir":
+	default:
+		return fmt.Errorf("unknown key provider %s", name)
+	}
+	return nil
+}
+
+// newKeyProvider creates the named key provider, configured with the settings of the core.
+func newKeyProvider(conf *CoreConfig, name string) (_vault.KeyProvider, error) {
+	switch name {
+	case "keystore":
+		return _vault.NewKeystoreKeyProvider(conf.KEYSTORE_PATH, conf.KEYSTORE_PASSPHRASE)
+	case "kms":
+		return _vault.NewKMSKeyProvider(conf.KMS_URL, conf.KMS_KEY_ID, conf.KMS_TOKEN)
+	default:
+		return _vault.NewSecretKeyProvider(conf.ENCRYPTION_SECRET)
+	}
+}
+
 func CreateCore(conf *CoreConfig) (*Core, error) {
 	c := &Core{}
 	if err := ValidateCoreConfig(conf); err != nil {
@@ -117,22 +203,67 @@ func CreateCore(conf *CoreConfig) (*Core, error) {
 		panic(err)
 	}
 
-	priv, err := _vault.NewAESPrivatiser(conf.ENCRYPTION_SECRET)
+	var priv _vault.Privatiser
+	var signer _vault.Signer
+	if conf.KEY_PROVIDER == "shamir" {
+		// The vault starts sealed, its keys are loaded once enough key shares are submitted.
+		seal := _vault.NewSeal(db, c.unseal)
+		priv, signer = seal, seal
+	} else {
+		provider, err := newKeyProvider(conf, conf.KEY_PROVIDER)
+		if err != nil {
+			panic(err)
+		}
+		priv, signer, err = newVaultKeys(context.Background(), db, conf, provider)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	hasher, err := _vault.NewArgon2Hasher(uint32(conf.ARGON2_TIME), uint32(conf.ARGON2_MEMORY), uint8(conf.ARGON2_THREADS))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	signer, err := _vault.NewHMACSigner([]byte(conf.SIGNING_KEY))
+
+	// Principals authenticate on every request, their verified credentials are remembered for
+	// a few minutes rather than hashed each time.
+	logins, err := _vault.NewLoginCache(5 * time.Minute)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	// The contents of file fields are kept encrypted in a directory of the local filesystem
+	var blobs _vault.BlobStore
+	if conf.FILES_PATH != "" {
+		if blobs, err = _vault.NewLocalBlobStore(conf.FILES_PATH); err != nil {
+			return nil, err
+		}
+	} else {
+		apiLogger.Info("THORN_FILES_PATH is not set, files can't be uploaded to file fields")
+	}
+
+	// Subject keys are kept out of the database so that its backups can't decrypt shredded subjects
+	var subjectKeys _vault.SubjectKeyStore
+	if conf.SUBJECT_KEYS_PATH != "" {
+		if subjectKeys, err = _vault.NewLocalSubjectKeyStore(conf.SUBJECT_KEYS_PATH); err != nil {
+			return nil, err
+		}
+	} else {
+		apiLogger.Warn("THORN_SUBJECT_KEYS_PATH is not set, subject keys are stored in the database and remain in its backups once shredded")
 	}
 
 	vaultLogger, err := _logger.NewLogger("VAULT", conf.LOG_SINK, conf.LOG_FORMAT, conf.LOG_LEVEL, conf.DEV_MODE)
 	vault := _vault.Vault{
-		Db:        db,
-		Priv:      priv,
-		Logger:    vaultLogger,
-		Signer:    signer,
-		Validator: _vault.NewValidator(),
+		Db:          db,
+		Priv:        priv,
+		Logger:      vaultLogger,
+		Signer:      signer,
+		Validator:   _vault.NewValidator(),
+		Hasher:      hasher,
+		Logins:      logins,
+		Keyrings:    _vault.NewKeyringCache(time.Minute),
+		SubjectKeys: subjectKeys,
+		Blobs:       blobs,
 	}
 
 	c.vault = vault
@@ -140,6 +271,61 @@ func CreateCore(conf *CoreConfig) (*Core, error) {
 	return c, err
 }
 
+// newVaultKeys creates the privatiser and signer of the vault from the key provider.
+func newVaultKeys(ctx context.Context, db _vault.VaultDB, conf *CoreConfig, provider _vault.KeyProvider) (_vault.Privatiser, _vault.Signer, error) {
+	// Values written before the keyring was introduced were encrypted with the encryption
+	// secret directly.
+	var fallback _vault.Privatiser
+	if secretPriv, err := _vault.NewAESGCMPrivatiser(conf.ENCRYPTION_SECRET); err == nil {
+		fallback = secretPriv
+	}
+	priv, err := _vault.NewKeyring(ctx, db, "data", provider, fallback)
+	if err != nil {
+		return nil, nil, err
+	}
+	// An explicit signing key takes precedence so existing blind indexes stay valid.
+	var signer *_vault.HMACSigner
+	if conf.SIGNING_KEY != "" {
+		signer, err = _vault.NewHMACSigner([]byte(conf.SIGNING_KEY))
+	} else {
+		signer, err = _vault.NewProviderSigner(ctx, db, provider)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, signer, nil
+}
+
+// unseal is called by the seal with the reconstructed master key.
+func (core *Core) unseal(ctx context.Context, provider _vault.KeyProvider) (_vault.Privatiser, _vault.Signer, error) {
+	if core.conf.PREVIOUS_KEY_PROVIDER != "" {
+		// Keys written before the vault was sealed with key shares are moved to the seal
+		previous, err := newKeyProvider(core.conf, core.conf.PREVIOUS_KEY_PROVIDER)
+		if err != nil {
+			return nil, nil, err
+		}
+		rewrapped, err := _vault.RewrapKeyrings(ctx, core.vault.Db, previous, provider)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rewrapped > 0 {
+			core.logger.Info(fmt.Sprintf("Rewrapped %d keyring keys with the seal", rewrapped))
+		}
+	}
+	priv, signer, err := newVaultKeys(ctx, core.vault.Db, core.conf, provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Bootstrapping and the migrations needing the vault's keys run once they are loaded.
+	unsealed := core.vault
+	unsealed.Priv, unsealed.Signer = priv, signer
+	if err := core.bootstrap(ctx, unsealed); err != nil {
+		return nil, nil, err
+	}
+	core.backfill(ctx, unsealed)
+	return priv, signer, nil
+}
+
 func (core *Core) Init() error {
 	// TODO: This should be better controlled, probably a one time setup
 	// TODO: update the admin password with environment variables
@@ -151,13 +337,51 @@ func (core *Core) Init() error {
 			panic(err)
 		}
 	}
-	// TODO: Move this to a bootstrap function
+	if core.vault.IsSealed() {
+		core.logger.Info("Vault is sealed, bootstrapping once unsealed")
+		return nil
+	}
+	if err := core.bootstrap(ctx, core.vault); err != nil {
+		panic(err)
+	}
+	core.backfill(ctx, core.vault)
+
+	return nil
+}
+
+// backfill migrates the keys and records written before the vault's current storage: subject
+// keys kept in the database, unbound transit keys, private keys wrapped by data keys and records
+// without blind indexes. Whatever it fails to migrate can
+// still be read, so the vault is started regardless.
+func (core *Core) backfill(ctx context.Context, vault _vault.Vault) {
+	if moved, err := vault.MoveSubjectKeys(ctx); err != nil {
+		core.logger.Error(fmt.Sprintf("Error moving subject keys: %s", err.Error()))
+	} else if moved > 0 {
+		core.logger.Info(fmt.Sprintf("Moved %d subject keys out of the database", moved))
+	}
+	if rewrapped, err := vault.BindKeyringKeys(ctx); err != nil {
+		core.logger.Error(fmt.Sprintf("Error binding keyring keys: %s", err.Error()))
+	} else if rewrapped > 0 {
+		core.logger.Info(fmt.Sprintf("Bound %d keys of transit keys and the private keyring", rewrapped))
+	}
+	if rewrapped, err := vault.RewrapPrivateKeys(ctx); err != nil {
+		core.logger.Error(fmt.Sprintf("Error rewrapping private keys: %s", err.Error()))
+	} else if rewrapped > 0 {
+		core.logger.Info(fmt.Sprintf("Rewrapped the private keys of %d write-only collections", rewrapped))
+	}
+	if err := vault.BackfillBlindIndexes(ctx); err != nil {
+		core.logger.Error(fmt.Sprintf("Error backfilling blind indexes: %s", err.Error()))
+	}
+}
+
+// bootstrap creates the root policy and the admin principal if they don't exist yet.
+func (core *Core) bootstrap(ctx context.Context, vault _vault.Vault) error {
 	rootPolicyId := _vault.GenerateId("pol")
-	err := core.vault.Db.CreatePolicy(ctx, &_vault.Policy{
+	err := vault.Db.CreatePolicy(ctx, &_vault.Policy{
 		Id:        rootPolicyId,
 		Name:      "root",
 		Effect:    _vault.EffectAllow,
-		Actions:   []_vault.PolicyAction{_vault.PolicyActionWrite, _vault.PolicyActionRead},
+		Actions:   []_vault.PolicyAction{_vault.PolicyActionWrite, _vault.PolicyActionRead, _vault.PolicyActionDecrypt},
 		Resources: []string{"*"},
 	})
 	if err != nil {
@@ -166,7 +390,7 @@ func (core *Core) Init() error {
 		if errors.As(err, &co) {
 			core.logger.Debug("Root policy already exists, continuing")
 		} else {
-			panic(err)
+			return err
 		}
 	}
 	adminPrincipal := _vault.Principal{
@@ -174,20 +398,52 @@ func (core *Core) Init() error {
 		Password:    core.conf.ADMIN_PASSWORD,
 		Description: "admin",
 		Policies:    []string{rootPolicyId}}
-	err = core.vault.CreatePrincipal(ctx, adminPrincipal, &adminPrincipal) // The admin bootstraps himself
+	err = vault.CreatePrincipal(ctx, adminPrincipal, &adminPrincipal) // The admin bootstraps himself
 
 	var co *_vault.ConflictError
 	if err != nil {
 		if errors.As(err, &co) {
 			core.logger.Debug("Admin principal already exists, continuing")
+			return core.migrateRootPolicy(ctx, vault)
 		} else {
-			panic(err)
+			return err
 		}
 	}
 
 	return nil
 }
 
+// migrateRootPolicy allows the admin to decrypt write-only collections, which the root policy
+// bootstrapped before they were introduced doesn't. The root policy is the one the admin was
+// bootstrapped with, other policies are left to their owners.
+func (core *Core) migrateRootPolicy(ctx context.Context, vault _vault.Vault) error {
+	admin, err := vault.Db.GetPrincipal(ctx, core.conf.ADMIN_USERNAME)
+	if err != nil {
+		return err
+	}
+	if len(admin.Policies) != 1 {
+		return nil
+	}
+	rootPolicyId := admin.Policies[0]
+	policy, err := vault.Db.GetPolicy(ctx, rootPolicyId)
+	if err != nil {
+		return err
+	}
+	if policy.Effect != _vault.EffectAllow || len(policy.Resources) != 1 || policy.Resources[0] != "*" {
+		return nil
+	}
+	for _, action := range policy.Actions {
+		if action == _vault.PolicyActionDecrypt {
+			return nil
+		}
+	}
+	if err := vault.Db.AddPolicyAction(ctx, rootPolicyId, _vault.PolicyActionDecrypt); err != nil {
+		return err
+	}
+	core.logger.Info(fmt.Sprintf("Allowed the root policy %s to decrypt write-only collections", rootPolicyId))
+	return nil
+}
+
 func (core *Core) ParseJsonBody(data []byte, payload interface{}) error {
 	decoder := json.NewDecoder(bytes.NewReader(data))
 	decoder.DisallowUnknownFields()
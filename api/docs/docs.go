@@ -132,6 +132,47 @@ const docTemplate = `{
                 }
             }
         },
+        "/collections/{name}/fpe/decrypt": {
+            "post": {
+                "description": "Reverses the fpe and fpe_last4 formats of a field of a record",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "Decrypt a format-preserving encrypted value",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Encrypted value",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.FPEDecryptRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.FPEDecryptResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/collections/{name}/records": {
             "get": {
                 "description": "Returns all Records",
@@ -361,6 +402,266 @@ const docTemplate = `{
                 }
             }
         },
+        "/collections/{name}/records/{id}/files/{field}": {
+            "get": {
+                "description": "Streams the file of a file field of a record",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "Download a file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Record Id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Field Name",
+                        "name": "field",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Streams a file into a file field of a record, replacing the file it had. The file is the part of the multipart form named file.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "Upload a file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Record Id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Field Name",
+                        "name": "field",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "File",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.FileInfo"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deletes the file of a file field of a record, setting the field to null",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "files"
+                ],
+                "summary": "Delete a file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Record Id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Field Name",
+                        "name": "field",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "File deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/collections/{name}/records/{id}/shred": {
+            "post": {
+                "description": "Destroys the key of a subject record, making it and the records referencing it permanently unreadable, and deletes them",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "Shred a subject",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Subject Record Id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.DestructionCertificate"
+                        }
+                    }
+                }
+            }
+        },
+        "/collections/{name}/records/{id}/verify": {
+            "post": {
+                "description": "Compares a value with the stored hash of a field in hashed mode",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "Verify the value of a hashed field",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Record Id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Field and value to verify",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.VerifyFieldRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.VerifyFieldResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/collections/{name}/verify": {
+            "post": {
+                "description": "Checks the MAC of every record of a collection and reports the records that were modified outside of the vault",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "collections"
+                ],
+                "summary": "Verify the integrity of a collection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.CollectionVerification"
+                        }
+                    }
+                }
+            }
+        },
         "/policies": {
             "get": {
                 "description": "Returns all Policies",
@@ -549,9 +850,225 @@ const docTemplate = `{
                 }
             }
         },
-        "/tokens": {
-            "post": {
-                "description": "Creates a Token",
+        "/ptypes": {
+            "get": {
+                "description": "Returns the types collection fields can have and the formats their values can be returned in",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ptypes"
+                ],
+                "summary": "Get the field types",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/vault.PTypeInfo"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/init": {
+            "post": {
+                "description": "Generates the master key and returns it split into key shares, threshold of which are needed to unseal. The key shares are only ever returned once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Initialise the seal",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.InitResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/keyring": {
+            "get": {
+                "description": "Returns the active key version and all key versions of the data keyring",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Get the keyring status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.KeyringStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/keyring/rekey": {
+            "post": {
+                "description": "Starts a background job re-encrypting every collection with the active data key",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Re-encrypt all records with the active key",
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/vault.RekeyJob"
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/keyring/rekey/{jobId}": {
+            "get": {
+                "description": "Returns the progress of a re-encryption job, per collection",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Get a re-encryption job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job Id",
+                        "name": "jobId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.RekeyJob"
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/keyring/rotate": {
+            "post": {
+                "description": "Creates a new data key used for all new writes, older keys remain available for decryption",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Rotate the data key",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/vault.KeyringStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/seal": {
+            "post": {
+                "description": "Wipes the master key from memory, the vault has to be unsealed again before it can be used",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Seal the vault",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/sys/seal-status": {
+            "get": {
+                "description": "Returns whether the vault is initialised and sealed, and the progress of unsealing",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Get the seal status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.SealStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/sys/unseal": {
+            "post": {
+                "description": "Submits a key share, the vault is unsealed once threshold key shares have been submitted",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sys"
+                ],
+                "summary": "Submit a key share",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/vault.SealStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/tokens": {
+            "post": {
+                "description": "Creates a Token",
                 "consumes": [
                     "*/*"
                 ],
@@ -561,20 +1078,168 @@ const docTemplate = `{
                 "tags": [
                     "tokens"
                 ],
-                "summary": "Create a Token",
+                "summary": "Create a Token",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/tokens/{tokenId}": {
+            "get": {
+                "description": "Returns a Token given an id",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Get a Token by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token Id",
+                        "name": "tokenId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/decrypt/{name}": {
+            "post": {
+                "description": "Decrypts a ciphertext written with any version of a transit key",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Decrypt with a transit key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transit Key Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Ciphertext to decrypt",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitCiphertextRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitPlaintextResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/encrypt/{name}": {
+            "post": {
+                "description": "Encrypts a plaintext, which should be base64 encoded for binary data, with the active version of a transit key",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Encrypt with a transit key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transit Key Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Plaintext to encrypt",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitEncryptRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitCiphertextResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/keys": {
+            "post": {
+                "description": "Creates a named key to encrypt and sign data that isn't stored in a collection",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Create a transit key",
+                "parameters": [
+                    {
+                        "description": "Transit key",
+                        "name": "key",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/vault.TransitKey"
+                        }
+                    }
+                ],
                 "responses": {
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/vault.KeyringStatus"
                         }
                     }
                 }
             }
         },
-        "/tokens/{tokenId}": {
+        "/transit/keys/{name}": {
             "get": {
-                "description": "Returns a Token given an id",
+                "description": "Returns the active and available versions of a transit key",
                 "consumes": [
                     "*/*"
                 ],
@@ -582,14 +1247,14 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tokens"
+                    "transit"
                 ],
-                "summary": "Get a Token by id",
+                "summary": "Get a transit key",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Token Id",
-                        "name": "tokenId",
+                        "description": "Transit Key Name",
+                        "name": "name",
                         "in": "path",
                         "required": true
                     }
@@ -598,7 +1263,162 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/vault.KeyringStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/keys/{name}/rotate": {
+            "post": {
+                "description": "Creates a new version of a transit key, used for all new ciphertexts and signatures",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Rotate a transit key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transit Key Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/vault.KeyringStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/rewrap/{name}": {
+            "post": {
+                "description": "Re-encrypts a ciphertext with the active version of a transit key without revealing the plaintext",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Rewrap with a transit key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transit Key Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Ciphertext to rewrap",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitCiphertextRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitCiphertextResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/sign/{name}": {
+            "post": {
+                "description": "Signs an input with the active version of a transit key",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Sign with a transit key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transit Key Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Input to sign",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitSignRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitSignResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transit/verify/{name}": {
+            "post": {
+                "description": "Checks a signature made with any version of a transit key",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transit"
+                ],
+                "summary": "Verify with a transit key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transit Key Name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Input and signature to verify",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitVerifyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitVerifyResponse"
                         }
                     }
                 }
@@ -606,6 +1426,54 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "main.FPEDecryptRequest": {
+            "type": "object",
+            "required": [
+                "field",
+                "format",
+                "record_id",
+                "value"
+            ],
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "record_id": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.FPEDecryptResponse": {
+            "type": "object",
+            "properties": {
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.InitResponse": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "shares": {
+                    "type": "integer"
+                },
+                "threshold": {
+                    "type": "integer"
+                }
+            }
+        },
         "main.PrincipalResponse": {
             "type": "object",
             "required": [
@@ -621,19 +1489,122 @@ const docTemplate = `{
                 "id": {
                     "type": "string"
                 },
-                "policies": {
-                    "type": "array",
-                    "items": {
-                        "type": "string"
-                    }
-                },
-                "updated_at": {
+                "policies": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string",
+                    "maxLength": 32,
+                    "minLength": 3
+                }
+            }
+        },
+        "main.TransitCiphertextRequest": {
+            "type": "object",
+            "required": [
+                "ciphertext"
+            ],
+            "properties": {
+                "ciphertext": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitCiphertextResponse": {
+            "type": "object",
+            "properties": {
+                "ciphertext": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitEncryptRequest": {
+            "type": "object",
+            "required": [
+                "plaintext"
+            ],
+            "properties": {
+                "plaintext": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitPlaintextResponse": {
+            "type": "object",
+            "properties": {
+                "plaintext": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitSignRequest": {
+            "type": "object",
+            "required": [
+                "input"
+            ],
+            "properties": {
+                "input": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitSignResponse": {
+            "type": "object",
+            "properties": {
+                "signature": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitVerifyRequest": {
+            "type": "object",
+            "required": [
+                "input",
+                "signature"
+            ],
+            "properties": {
+                "input": {
+                    "type": "string"
+                },
+                "signature": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransitVerifyResponse": {
+            "type": "object",
+            "properties": {
+                "valid": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.VerifyFieldRequest": {
+            "type": "object",
+            "required": [
+                "field",
+                "value"
+            ],
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "value": {
                     "type": "string"
-                },
-                "username": {
-                    "type": "string",
-                    "maxLength": 32,
-                    "minLength": 3
+                }
+            }
+        },
+        "main.VerifyFieldResponse": {
+            "type": "object",
+            "properties": {
+                "valid": {
+                    "type": "boolean"
                 }
             }
         },
@@ -669,8 +1640,81 @@ const docTemplate = `{
                     "maxLength": 32,
                     "minLength": 3
                 },
+                "public_key": {
+                    "type": "string"
+                },
                 "updated_at": {
                     "type": "string"
+                },
+                "write_only": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "vault.CollectionVerification": {
+            "type": "object",
+            "properties": {
+                "collection": {
+                    "type": "string"
+                },
+                "tampered": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "unsigned": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "verified": {
+                    "type": "integer"
+                }
+            }
+        },
+        "vault.DateTruncation": {
+            "type": "string",
+            "enum": [
+                "year",
+                "decade"
+            ],
+            "x-enum-comments": {
+                "DateTruncationDecade": "e.g. 1990s",
+                "DateTruncationYear": "e.g. 1990"
+            },
+            "x-enum-varnames": [
+                "DateTruncationYear",
+                "DateTruncationDecade"
+            ]
+        },
+        "vault.DestructionCertificate": {
+            "type": "object",
+            "properties": {
+                "collection": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key_created_at": {
+                    "type": "string"
+                },
+                "key_destroyed": {
+                    "type": "boolean"
+                },
+                "shredded_at": {
+                    "type": "string"
+                },
+                "shredded_by": {
+                    "type": "string"
+                },
+                "signature": {
+                    "type": "string"
+                },
+                "subject_id": {
+                    "type": "string"
                 }
             }
         },
@@ -680,14 +1724,272 @@ const docTemplate = `{
                 "type"
             ],
             "properties": {
+                "constraints": {
+                    "$ref": "#/definitions/vault.FieldConstraints"
+                },
+                "content_types": {
+                    "description": "Media types file fields accept, e.g. application/pdf or image/*, any if unset",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "default": {
+                    "description": "Value of the field in records that don't set it",
+                    "type": "string"
+                },
+                "generalizations": {
+                    "description": "Formats of date and integer fields that reduce values to a range, by format name",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/vault.Generalization"
+                    }
+                },
                 "is_indexed": {
                     "type": "boolean"
                 },
+                "mask": {
+                    "description": "Template of the masked format of regex fields",
+                    "type": "string"
+                },
+                "max_size": {
+                    "description": "Size limit of file fields in bytes, DefaultMaxFileSize if unset",
+                    "type": "integer"
+                },
+                "mode": {
+                    "enum": [
+                        "randomized",
+                        "deterministic",
+                        "hashed"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/vault.FieldMode"
+                        }
+                    ]
+                },
+                "nullable": {
+                    "description": "The field can be set to null",
+                    "type": "boolean"
+                },
+                "paths": {
+                    "description": "Sensitive paths of json fields",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/vault.JSONPath"
+                    }
+                },
+                "pattern": {
+                    "description": "Values of regex fields must match it",
+                    "type": "string"
+                },
+                "required": {
+                    "description": "Records must set the field unless it has a default, true if unset",
+                    "type": "boolean"
+                },
+                "type": {
+                    "description": "One of the registered ptypes, listed by GET /ptypes",
+                    "type": "string"
+                }
+            }
+        },
+        "vault.FieldConstraints": {
+            "type": "object",
+            "properties": {
+                "enum": {
+                    "description": "Values must be one of these",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "max": {
+                    "description": "Integer fields only",
+                    "type": "integer"
+                },
+                "max_date": {
+                    "description": "Date fields only, formatted as YYYY-MM-DD",
+                    "type": "string"
+                },
+                "max_length": {
+                    "type": "integer"
+                },
+                "min": {
+                    "description": "Integer fields only",
+                    "type": "integer"
+                },
+                "min_date": {
+                    "description": "Date fields only, formatted as YYYY-MM-DD",
+                    "type": "string"
+                },
+                "min_length": {
+                    "type": "integer"
+                }
+            }
+        },
+        "vault.FieldMode": {
+            "type": "string",
+            "enum": [
+                "randomized",
+                "deterministic",
+                "hashed"
+            ],
+            "x-enum-varnames": [
+                "FieldModeRandomized",
+                "FieldModeDeterministic",
+                "FieldModeHashed"
+            ]
+        },
+        "vault.FileInfo": {
+            "type": "object",
+            "properties": {
+                "content_type": {
+                    "description": "Sniffed from the contents",
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "uploaded_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "vault.Generalization": {
+            "type": "object",
+            "properties": {
+                "bands": {
+                    "description": "Ascending boundaries, e.g. [18, 65] gives \u003c18, 18-64 and 65+",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "noise": {
+                    "description": "Values are moved by up to this much first, in days for dates, by the same amount on every read of a record",
+                    "type": "integer"
+                },
+                "truncate": {
+                    "description": "Dates only",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/vault.DateTruncation"
+                        }
+                    ]
+                },
+                "width": {
+                    "description": "Buckets of this width, e.g. 10 gives 30-39",
+                    "type": "integer"
+                }
+            }
+        },
+        "vault.JSONPath": {
+            "type": "object",
+            "required": [
+                "type"
+            ],
+            "properties": {
+                "mask": {
+                    "description": "Template of the masked format of regex paths",
+                    "type": "string"
+                },
+                "pattern": {
+                    "description": "Values of regex paths must match it",
+                    "type": "string"
+                },
                 "type": {
+                    "description": "Any registered ptype but json",
+                    "type": "string"
+                }
+            }
+        },
+        "vault.KeyringStatus": {
+            "type": "object",
+            "properties": {
+                "active_version": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "versions": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "vault.PTypeInfo": {
+            "type": "object",
+            "properties": {
+                "description": {
                     "type": "string"
+                },
+                "formats": {
+                    "description": "Format name -\u003e description",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "$ref": "#/definitions/vault.PTypeName"
                 }
             }
         },
+        "vault.PTypeName": {
+            "type": "string",
+            "enum": [
+                "phone_number",
+                "name",
+                "string",
+                "email",
+                "cc_number",
+                "cc_cvv",
+                "cc_expiry",
+                "regex",
+                "iban",
+                "bic",
+                "aba_routing",
+                "sort_code",
+                "account_number",
+                "ssn",
+                "nino",
+                "passport_number",
+                "address",
+                "integer",
+                "date",
+                "json",
+                "file"
+            ],
+            "x-enum-varnames": [
+                "PhoneNumberType",
+                "NameType",
+                "StringType",
+                "EmailType",
+                "CreditCardNumberType",
+                "CreditCardCVVType",
+                "CreditCardExpiryType",
+                "RegexType",
+                "IBANType",
+                "BICType",
+                "ABARoutingType",
+                "SortCodeType",
+                "AccountNumberType",
+                "SSNType",
+                "NINOType",
+                "PassportNumberType",
+                "AddressType",
+                "IntegerType",
+                "DateType",
+                "JSONType",
+                "FileType"
+            ]
+        },
         "vault.Policy": {
             "type": "object",
             "required": [
@@ -740,11 +2042,16 @@ const docTemplate = `{
             "type": "string",
             "enum": [
                 "read",
-                "write"
+                "write",
+                "decrypt"
             ],
+            "x-enum-comments": {
+                "PolicyActionDecrypt": "Reading the records of write-only collections"
+            },
             "x-enum-varnames": [
                 "PolicyActionRead",
-                "PolicyActionWrite"
+                "PolicyActionWrite",
+                "PolicyActionDecrypt"
             ]
         },
         "vault.PolicyEffect": {
@@ -763,6 +2070,96 @@ const docTemplate = `{
             "additionalProperties": {
                 "type": "string"
             }
+        },
+        "vault.RekeyCollectionProgress": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "processed": {
+                    "type": "integer"
+                },
+                "rekeyed": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "vault.RekeyJob": {
+            "type": "object",
+            "properties": {
+                "collections": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/vault.RekeyCollectionProgress"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key_version": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/vault.RekeyJobStatus"
+                }
+            }
+        },
+        "vault.RekeyJobStatus": {
+            "type": "string",
+            "enum": [
+                "running",
+                "completed",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "RekeyJobRunning",
+                "RekeyJobCompleted",
+                "RekeyJobFailed"
+            ]
+        },
+        "vault.SealStatus": {
+            "type": "object",
+            "properties": {
+                "initialized": {
+                    "type": "boolean"
+                },
+                "progress": {
+                    "type": "integer"
+                },
+                "sealed": {
+                    "type": "boolean"
+                },
+                "shares": {
+                    "type": "integer"
+                },
+                "threshold": {
+                    "type": "integer"
+                }
+            }
+        },
+        "vault.TransitKey": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
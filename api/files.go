@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PutFile godoc
+// @Summary Upload a file
+// @Description Streams a file into a file field of a record, replacing the file it had. The file is the part of the multipart form named file.
+// @Tags files
+// @Accept multipart/form-data
+// @Produce json
+// @Success 200 {object} vault.FileInfo
+// @Router /collections/{name}/records/{id}/files/{field} [put]
+// @Param name path string true "Collection Name"
+// @Param id path string true "Record Id"
+// @Param field path string true "Field Name"
+// @Param file formData file true "File"
+func (core *Core) PutFile(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+	recordId := c.Params("id")
+	fieldName := c.Params("field")
+
+	mediaType, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if err != nil || mediaType != fiber.MIMEMultipartForm || params["boundary"] == "" {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(ErrorResponse{"Unsupported Media Type. Files are uploaded as multipart/form-data", nil})
+	}
+
+	// Bodies larger than the body limit are streamed, smaller ones are already read
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	} else {
+		// Uploads rejected part way leave the rest of the body unread on the connection
+		c.Context().SetConnectionClose()
+	}
+	form := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{"the file form part is required"}})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		info, err := core.vault.PutFile(c.Context(), principal, collectionName, recordId, fieldName, part.FileName(), part)
+		if err != nil {
+			return err
+		}
+		return c.Status(http.StatusOK).JSON(info)
+	}
+}
+
+// GetFile godoc
+// @Summary Download a file
+// @Description Streams the file of a file field of a record
+// @Tags files
+// @Accept */*
+// @Produce octet-stream
+// @Success 200 {file} file
+// @Router /collections/{name}/records/{id}/files/{field} [get]
+// @Param name path string true "Collection Name"
+// @Param id path string true "Record Id"
+// @Param field path string true "Field Name"
+func (core *Core) GetFile(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+	recordId := c.Params("id")
+	fieldName := c.Params("field")
+
+	info, contents, err := core.vault.GetFile(c.Context(), principal, collectionName, recordId, fieldName)
+	if err != nil {
+		return err
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": info.Filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	c.Set(fiber.HeaderContentType, info.ContentType)
+	c.Set(fiber.HeaderContentDisposition, disposition)
+	// The response is sent once the handler returns, and closes contents when done
+	return c.Status(http.StatusOK).SendStream(contents, int(info.Size))
+}
+
+// DeleteFile godoc
+// @Summary Delete a file
+// @Description Deletes the file of a file field of a record, setting the field to null
+// @Tags files
+// @Accept */*
+// @Produce plain
+// @Success 200 {string} string "File deleted"
+// @Router /collections/{name}/records/{id}/files/{field} [delete]
+// @Param name path string true "Collection Name"
+// @Param id path string true "Record Id"
+// @Param field path string true "Field Name"
+func (core *Core) DeleteFile(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	collectionName := c.Params("name")
+	recordId := c.Params("id")
+	fieldName := c.Params("field")
+
+	if err := core.vault.DeleteFile(c.Context(), principal, collectionName, recordId, fieldName); err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).SendString("File deleted")
+}
+
+// bufferStreamedBody reads streamed request bodies whole, up to limit, for the handlers that
+// parse them from memory. Bodies are only streamed so files can be uploaded without holding
+// them in memory, see SetupApi.
+func bufferStreamedBody(limit int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !c.Request().IsBodyStream() {
+			return c.Next()
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Context().RequestBodyStream(), int64(limit)+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > limit {
+			c.Context().SetConnectionClose()
+			return &fiber.Error{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("request body is larger than the limit of %d bytes", limit)}
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
+}
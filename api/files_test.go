@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_vault "github.com/subrose/vault"
+)
+
+func newFileRequest(t *testing.T, url string, headers map[string]string, filename string, contents []byte) *http.Request {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Error creating form file: %v", err)
+	}
+	if _, err := part.Write(contents); err != nil {
+		t.Fatalf("Error writing form file: %v", err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatalf("Error closing form: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPut, url, body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+func TestFiles(t *testing.T) {
+	app, core := InitTestingVault(t)
+	authHeaders := map[string]string{
+		"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+	}
+
+	applicantsCollection := &_vault.Collection{
+		Name: "applicants",
+		Fields: map[string]_vault.Field{
+			"name":     {Type: "name"},
+			"passport": {Type: "file", ContentTypes: []string{"application/pdf"}},
+		},
+	}
+	request := newRequest(t, http.MethodPost, "/collections", authHeaders, applicantsCollection)
+	response := performRequest(t, app, request)
+	checkResponse(t, response, http.StatusCreated, nil)
+
+	request = newRequest(t, http.MethodPost, "/collections/applicants/records", authHeaders, map[string]string{"name": "John Crawford"})
+	response = performRequest(t, app, request)
+	var recordId string
+	checkResponse(t, response, http.StatusCreated, &recordId)
+	fileUrl := fmt.Sprintf("/collections/applicants/records/%s/files/passport", recordId)
+	passport := []byte("%PDF-1.4\n% passport of John Crawford\n")
+
+	t.Run("can upload and download a file", func(t *testing.T) {
+		request := newFileRequest(t, fileUrl, authHeaders, "passport.pdf", passport)
+		response := performRequest(t, app, request)
+		var info _vault.FileInfo
+		checkResponse(t, response, http.StatusOK, &info)
+		if info.Filename != "passport.pdf" || info.ContentType != "application/pdf" || info.Size != int64(len(passport)) {
+			t.Errorf("Unexpected file info, got %+v", info)
+		}
+
+		request = newRequest(t, http.MethodGet, fileUrl, authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusOK, nil)
+		downloaded, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatalf("Error reading file: %v", err)
+		}
+		if !bytes.Equal(downloaded, passport) {
+			t.Errorf("Expected the uploaded file, got %q", downloaded)
+		}
+		if disposition := response.Header.Get("Content-Disposition"); disposition != `attachment; filename=passport.pdf` {
+			t.Errorf("Unexpected content disposition, got %s", disposition)
+		}
+	})
+
+	t.Run("files must have an accepted content type", func(t *testing.T) {
+		request := newFileRequest(t, fileUrl, authHeaders, "passport.html", []byte("<html><body>passport</body></html>"))
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusBadRequest, nil)
+	})
+
+	t.Run("files must be uploaded as a form", func(t *testing.T) {
+		request := newRequest(t, http.MethodPut, fileUrl, authHeaders, map[string]string{"file": "passport"})
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusUnsupportedMediaType, nil)
+	})
+
+	t.Run("can delete a file", func(t *testing.T) {
+		request := newRequest(t, http.MethodDelete, fileUrl, authHeaders, nil)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusOK, nil)
+
+		request = newRequest(t, http.MethodGet, fileUrl, authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusNotFound, nil)
+	})
+}
@@ -43,6 +43,16 @@ func GetSessionPrincipal(c *fiber.Ctx) _vault.Principal {
 	return c.Locals(PRINCIPAL_CONTEXT_KEY).(_vault.Principal)
 }
 
+// sealGuard rejects requests while the vault is sealed.
+func sealGuard(core *Core) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if core.vault.IsSealed() {
+			return &_vault.SealedError{}
+		}
+		return ctx.Next()
+	}
+}
+
 func authGuard(core *Core) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		authHeader := ctx.Get("Authorization")
@@ -70,6 +80,10 @@ func authGuard(core *Core) fiber.Handler {
 		password := credentials[1]
 
 		principal, err := core.vault.Login(ctx.Context(), username, password)
+		var se *_vault.SealedError
+		if errors.As(err, &se) {
+			return err
+		}
 		if err != nil {
 			core.logger.Error(fmt.Sprintf("Error logging in: %s", err.Error()))
 			return &AuthError{"Invalid username or password"}
@@ -99,6 +113,9 @@ func (core *Core) customErrorHandler(ctx *fiber.Ctx, err error) error {
 	var co *_vault.ConflictError
 	var va *_vault.ValidationErrors
 	var ns *_vault.NotSupportedError
+	var se *_vault.SealedError
+	var sh *_vault.ShreddedError
+	var ie *_vault.IntegrityError
 
 	switch {
 	case errors.As(err, &ve):
@@ -114,7 +131,17 @@ func (core *Core) customErrorHandler(ctx *fiber.Ctx, err error) error {
 	case errors.As(err, &co):
 		return ctx.Status(http.StatusConflict).JSON(ErrorResponse{co.Error(), nil})
 	case errors.As(err, &va):
-		return ctx.Status(http.StatusBadRequest).JSON(ErrorResponse{va.Error(), nil})
+		failures := make([]string, len(va.Errors))
+		for i, failure := range va.Errors {
+			failures[i] = strings.TrimSpace(fmt.Sprintf("%s: %s %s", failure.FailedField, failure.Tag, failure.Value))
+		}
+		return ctx.Status(http.StatusBadRequest).JSON(ErrorResponse{va.Error(), failures})
+	case errors.As(err, &se):
+		return ctx.Status(http.StatusServiceUnavailable).JSON(ErrorResponse{se.Error(), nil})
+	case errors.As(err, &sh):
+		return ctx.Status(http.StatusGone).JSON(ErrorResponse{sh.Error(), nil})
+	case errors.As(err, &ie):
+		return ctx.Status(http.StatusUnprocessableEntity).JSON(ErrorResponse{ie.Error(), nil})
 	default:
 		// Handle other types of errors by returning a generic 500 - this should remain obscure as it can leak information
 		core.logger.Error(fmt.Sprintf("Unhandled error: %s", err.Error()))
@@ -152,27 +179,39 @@ func SetupApi(core *Core) *fiber.App {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		ErrorHandler:          core.customErrorHandler,
+		// Files are streamed through the vault rather than held in memory
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 	app.Use(helmet.New())
 	app.Use(ApiLogger(core))
 	app.Use(recover.New())
 
+	// Registered before the body is buffered for the other routes, so uploads stay streamed
+	filesGroup := app.Group("/collections/:name/records/:id/files")
+	filesGroup.Use(sealGuard(core), authGuard(core))
+	filesGroup.Put("/:field", core.PutFile)
+	filesGroup.Get("/:field", core.GetFile)
+	filesGroup.Delete("/:field", core.DeleteFile)
+	app.Use(bufferStreamedBody(fiber.DefaultBodyLimit))
+
 	setupSwagger(app)
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.Status(http.StatusOK).SendString("OK")
 	})
 
 	principalGroup := app.Group("/principals")
-	principalGroup.Use(authGuard(core))
+	principalGroup.Use(sealGuard(core), authGuard(core))
 	principalGroup.Get(":username", core.GetPrincipal)
 	principalGroup.Post("", core.CreatePrincipal)
 	principalGroup.Delete(":username", core.DeletePrincipal)
 
 	collectionsGroup := app.Group("/collections")
-	collectionsGroup.Use(authGuard(core))
+	collectionsGroup.Use(sealGuard(core), authGuard(core))
 	collectionsGroup.Get("", core.GetCollections)
 	collectionsGroup.Get("/:name", core.GetCollection)
 	collectionsGroup.Delete("/:name", core.DeleteCollection)
+	collectionsGroup.Post("/:name/verify", core.VerifyCollection)
 	collectionsGroup.Post("", core.CreateCollection)
 	collectionsGroup.Post("/:name/records", core.CreateRecord)
 	collectionsGroup.Get("/:name/records", core.GetRecords)
@@ -180,16 +219,45 @@ func SetupApi(core *Core) *fiber.App {
 	collectionsGroup.Post("/:name/records/search", core.SearchRecords) // TODO: Should this be a POST?
 	collectionsGroup.Put("/:name/records/:id", core.UpdateRecord)
 	collectionsGroup.Delete("/:name/records/:id", core.DeleteRecord)
+	collectionsGroup.Post("/:name/records/:id/shred", core.ShredSubject)
+	collectionsGroup.Post("/:name/records/:id/verify", core.VerifyField)
+	collectionsGroup.Post("/:name/fpe/decrypt", core.DecryptFPE)
+
+	ptypesGroup := app.Group("/ptypes")
+	ptypesGroup.Use(sealGuard(core), authGuard(core))
+	ptypesGroup.Get("", core.GetPTypes)
 
 	policiesGroup := app.Group("/policies")
-	policiesGroup.Use(authGuard(core))
+	policiesGroup.Use(sealGuard(core), authGuard(core))
 	policiesGroup.Get(":policyId", core.GetPolicyById)
 	policiesGroup.Post("", JSONOnlyMiddleware, core.CreatePolicy)
 	policiesGroup.Get("", core.GetPolicies)
 	policiesGroup.Delete(":policyId", core.DeletePolicy)
 
+	sysGroup := app.Group("/sys")
+	sysGroup.Post("/init", JSONOnlyMiddleware, core.InitSeal)
+	sysGroup.Post("/unseal", JSONOnlyMiddleware, core.Unseal)
+	sysGroup.Get("/seal-status", core.GetSealStatus)
+	sysGroup.Use(sealGuard(core), authGuard(core))
+	sysGroup.Post("/seal", core.Seal)
+	sysGroup.Get("/keyring", core.GetKeyringStatus)
+	sysGroup.Post("/keyring/rotate", core.RotateKey)
+	sysGroup.Post("/keyring/rekey", core.StartRekey)
+	sysGroup.Get("/keyring/rekey/:jobId", core.GetRekeyJob)
+
+	transitGroup := app.Group("/transit")
+	transitGroup.Use(sealGuard(core), authGuard(core))
+	transitGroup.Post("/keys", JSONOnlyMiddleware, core.CreateTransitKey)
+	transitGroup.Get("/keys/:name", core.GetTransitKey)
+	transitGroup.Post("/keys/:name/rotate", core.RotateTransitKey)
+	transitGroup.Post("/encrypt/:name", JSONOnlyMiddleware, core.TransitEncrypt)
+	transitGroup.Post("/decrypt/:name", JSONOnlyMiddleware, core.TransitDecrypt)
+	transitGroup.Post("/rewrap/:name", JSONOnlyMiddleware, core.TransitRewrap)
+	transitGroup.Post("/sign/:name", JSONOnlyMiddleware, core.TransitSign)
+	transitGroup.Post("/verify/:name", JSONOnlyMiddleware, core.TransitVerify)
+
 	tokensGroup := app.Group("/tokens")
-	tokensGroup.Use(authGuard(core))
+	tokensGroup.Use(sealGuard(core), authGuard(core))
 	tokensGroup.Get(":tokenId", core.GetTokenById)
 	tokensGroup.Post("", core.CreateToken)
 
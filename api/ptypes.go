@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	_vault "github.com/subrose/vault"
+)
+
+// GetPTypes godoc
+// @Summary Get the field types
+// @Description Returns the types collection fields can have and the formats their values can be returned in
+// @Tags ptypes
+// @Accept */*
+// @Produce json
+// @Success 200 {array} _vault.PTypeInfo
+// @Router /ptypes [get]
+func (core *Core) GetPTypes(c *fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(_vault.PTypes())
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	_vault "github.com/subrose/vault"
+)
+
+func TestPTypes(t *testing.T) {
+	app, core := InitTestingVault(t)
+	authHeaders := map[string]string{
+		"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+	}
+
+	t.Run("can list the ptypes and their formats", func(t *testing.T) {
+		request := newRequest(t, http.MethodGet, "/ptypes", authHeaders, nil)
+		response := performRequest(t, app, request)
+		var pTypes []_vault.PTypeInfo
+		checkResponse(t, response, http.StatusOK, &pTypes)
+
+		formats := map[_vault.PTypeName]map[string]string{}
+		for _, info := range pTypes {
+			formats[info.Name] = info.Formats
+		}
+		assert.NotEqual(t, "", formats[_vault.PhoneNumberType]["national"])
+		assert.NotEqual(t, "", formats[_vault.EmailType]["domain"])
+		assert.NotEqual(t, "", formats[_vault.DateType]["age"])
+		assert.NotEqual(t, "", formats[_vault.NameType]["initials"])
+	})
+
+	t.Run("cannot list the ptypes without authentication", func(t *testing.T) {
+		request := newRequest(t, http.MethodGet, "/ptypes", map[string]string{}, nil)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusUnauthorized, nil)
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetKeyringStatus godoc
+// @Summary Get the keyring status
+// @Description Returns the active key version and all key versions of the data keyring
+// @Tags sys
+// @Accept */*
+// @Produce json
+// @Success 200 {object} vault.KeyringStatus
+// @Router /sys/keyring [get]
+func (core *Core) GetKeyringStatus(c *fiber.Ctx) error {
+	sessionPrincipal := GetSessionPrincipal(c)
+	status, err := core.vault.GetKeyringStatus(c.Context(), sessionPrincipal)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// RotateKey godoc
+// @Summary Rotate the data key
+// @Description Creates a new data key used for all new writes, older keys remain available for decryption
+// @Tags sys
+// @Accept */*
+// @Produce json
+// @Success 201 {object} vault.KeyringStatus
+// @Router /sys/keyring/rotate [post]
+func (core *Core) RotateKey(c *fiber.Ctx) error {
+	sessionPrincipal := GetSessionPrincipal(c)
+	status, err := core.vault.RotateKey(c.Context(), sessionPrincipal)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(status)
+}
+
+// StartRekey godoc
+// @Summary Re-encrypt all records with the active key
+// @Description Starts a background job re-encrypting every collection with the active data key
+// @Tags sys
+// @Accept */*
+// @Produce json
+// @Success 202 {object} vault.RekeyJob
+// @Router /sys/keyring/rekey [post]
+func (core *Core) StartRekey(c *fiber.Ctx) error {
+	sessionPrincipal := GetSessionPrincipal(c)
+	job, err := core.vault.StartRekey(c.Context(), sessionPrincipal)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusAccepted).JSON(job)
+}
+
+// GetRekeyJob godoc
+// @Summary Get a re-encryption job
+// @Description Returns the progress of a re-encryption job, per collection
+// @Tags sys
+// @Accept */*
+// @Produce json
+// @Success 200 {object} vault.RekeyJob
+// @Router /sys/keyring/rekey/{jobId} [get]
+// @Param jobId path string true "Job Id"
+func (core *Core) GetRekeyJob(c *fiber.Ctx) error {
+	jobId := c.Params("jobId")
+	sessionPrincipal := GetSessionPrincipal(c)
+	job, err := core.vault.GetRekeyJob(c.Context(), sessionPrincipal, jobId)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(job)
+}
+
+type InitRequest struct {
+	Shares    int `json:"shares" validate:"required"`
+	Threshold int `json:"threshold" validate:"required"`
+}
+
+type InitResponse struct {
+	Keys      []string `json:"keys"`
+	Shares    int      `json:"shares"`
+	Threshold int      `json:"threshold"`
+}
+
+type UnsealRequest struct {
+	Key string `json:"key" validate:"required"`
+}
+
+// InitSeal godoc
+// @Summary Initialise the seal
+// @Description Generates the master key and returns it split into key shares, threshold of which are needed to unseal. The key shares are only ever returned once.
+// @Tags sys
+// @Accept json
+// @Produce json
+// @Success 200 {object} InitResponse
+// @Router /sys/init [post]
+func (core *Core) InitSeal(c *fiber.Ctx) error {
+	initRequest := new(InitRequest)
+	if err := core.ParseJsonBody(c.Body(), &initRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	keys, err := core.vault.InitSeal(c.Context(), initRequest.Shares, initRequest.Threshold)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(InitResponse{keys, initRequest.Shares, initRequest.Threshold})
+}
+
+// Unseal godoc
+// @Summary Submit a key share
+// @Description Submits a key share, the vault is unsealed once threshold key shares have been submitted
+// @Tags sys
+// @Accept json
+// @Produce json
+// @Success 200 {object} vault.SealStatus
+// @Router /sys/unseal [post]
+func (core *Core) Unseal(c *fiber.Ctx) error {
+	unsealRequest := new(UnsealRequest)
+	if err := core.ParseJsonBody(c.Body(), &unsealRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	status, err := core.vault.Unseal(c.Context(), unsealRequest.Key)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// Seal godoc
+// @Summary Seal the vault
+// @Description Wipes the master key from memory, the vault has to be unsealed again before it can be used
+// @Tags sys
+// @Accept */*
+// @Produce json
+// @Success 204
+// @Router /sys/seal [post]
+func (core *Core) Seal(c *fiber.Ctx) error {
+	sessionPrincipal := GetSessionPrincipal(c)
+	if err := core.vault.Seal(c.Context(), sessionPrincipal); err != nil {
+		return err
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// GetSealStatus godoc
+// @Summary Get the seal status
+// @Description Returns whether the vault is initialised and sealed, and the progress of unsealing
+// @Tags sys
+// @Accept */*
+// @Produce json
+// @Success 200 {object} vault.SealStatus
+// @Router /sys/seal-status [get]
+func (core *Core) GetSealStatus(c *fiber.Ctx) error {
+	status, err := core.vault.GetSealStatus(c.Context())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(status)
+}
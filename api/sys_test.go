@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	_vault "github.com/subrose/vault"
+)
+
+func TestKeyring(t *testing.T) {
+	app, core := InitTestingVault(t)
+	authHeaders := map[string]string{
+		"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+	}
+
+	t.Run("can rotate the data key", func(t *testing.T) {
+		request := newRequest(t, http.MethodGet, "/sys/keyring", authHeaders, nil)
+		response := performRequest(t, app, request)
+		var before _vault.KeyringStatus
+		checkResponse(t, response, http.StatusOK, &before)
+
+		request = newRequest(t, http.MethodPost, "/sys/keyring/rotate", authHeaders, nil)
+		response = performRequest(t, app, request)
+		var after _vault.KeyringStatus
+		checkResponse(t, response, http.StatusCreated, &after)
+
+		assert.Equal(t, before.ActiveVersion+1, after.ActiveVersion)
+	})
+
+	t.Run("can start and track a rekey job", func(t *testing.T) {
+		request := newRequest(t, http.MethodPost, "/sys/keyring/rekey", authHeaders, nil)
+		response := performRequest(t, app, request)
+		var job _vault.RekeyJob
+		checkResponse(t, response, http.StatusAccepted, &job)
+
+		request = newRequest(t, http.MethodGet, fmt.Sprintf("/sys/keyring/rekey/%s", job.Id), authHeaders, nil)
+		response = performRequest(t, app, request)
+		var trackedJob _vault.RekeyJob
+		checkResponse(t, response, http.StatusOK, &trackedJob)
+
+		assert.Equal(t, job.Id, trackedJob.Id)
+	})
+}
+
+func TestSeal(t *testing.T) {
+	t.Setenv("THORN_KEY_PROVIDER", "shamir")
+	app, core := InitTestingVault(t)
+	authHeaders := map[string]string{
+		"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+	}
+
+	t.Run("rejects requests while sealed", func(t *testing.T) {
+		request := newRequest(t, http.MethodGet, "/collections", authHeaders, nil)
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusServiceUnavailable, nil)
+
+		request = newRequest(t, http.MethodGet, "/sys/seal-status", nil, nil)
+		response = performRequest(t, app, request)
+		var status _vault.SealStatus
+		checkResponse(t, response, http.StatusOK, &status)
+		assert.Equal(t, false, status.Initialized)
+		assert.Equal(t, true, status.Sealed)
+	})
+
+	t.Run("can initialise, unseal and seal", func(t *testing.T) {
+		request := newRequest(t, http.MethodPost, "/sys/init", nil, InitRequest{Shares: 5, Threshold: 3})
+		response := performRequest(t, app, request)
+		var initResponse InitResponse
+		checkResponse(t, response, http.StatusOK, &initResponse)
+		assert.Equal(t, 5, len(initResponse.Keys))
+
+		var status _vault.SealStatus
+		for _, key := range initResponse.Keys[:3] {
+			request = newRequest(t, http.MethodPost, "/sys/unseal", nil, UnsealRequest{Key: key})
+			response = performRequest(t, app, request)
+			checkResponse(t, response, http.StatusOK, &status)
+		}
+		assert.Equal(t, false, status.Sealed)
+
+		request = newRequest(t, http.MethodGet, "/collections", authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusOK, nil)
+
+		request = newRequest(t, http.MethodPost, "/sys/seal", authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusNoContent, nil)
+
+		request = newRequest(t, http.MethodGet, "/collections", authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusServiceUnavailable, nil)
+	})
+}
@@ -22,6 +22,7 @@ var adminPrincipal = _vault.Principal{Username: "admin", Password: "admin", Poli
 func InitTestingVault(t *testing.T) (*fiber.App, *Core) {
 	// Read environment variables if a test.env file exists, error is ignored on purpose
 	_ = godotenv.Load("../test.env")
+	t.Setenv("THORN_FILES_PATH", t.TempDir())
 
 	coreConfig, err := ReadConfigs()
 	if err != nil {
@@ -38,7 +39,7 @@ func InitTestingVault(t *testing.T) (*fiber.App, *Core) {
 		t.Fatal("Failed to create db", err)
 	}
 
-	priv, err := _vault.NewAESPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
+	priv, err := _vault.NewAESGCMPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
 	if err != nil {
 		t.Fatal("Failed to create privatiser", err)
 	}
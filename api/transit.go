@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	_vault "github.com/subrose/vault"
+)
+
+type TransitEncryptRequest struct {
+	Plaintext string `json:"plaintext" validate:"required"`
+}
+
+type TransitCiphertextRequest struct {
+	Ciphertext string `json:"ciphertext" validate:"required"`
+}
+
+type TransitCiphertextResponse struct {
+	Ciphertext string `json:"ciphertext"`
+}
+
+type TransitPlaintextResponse struct {
+	Plaintext string `json:"plaintext"`
+}
+
+type TransitSignRequest struct {
+	Input string `json:"input" validate:"required"`
+}
+
+type TransitSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+type TransitVerifyRequest struct {
+	Input     string `json:"input" validate:"required"`
+	Signature string `json:"signature" validate:"required"`
+}
+
+type TransitVerifyResponse struct {
+	Valid bool `json:"valid"`
+}
+
+// CreateTransitKey godoc
+// @Summary Create a transit key
+// @Description Creates a named key to encrypt and sign data that isn't stored in a collection
+// @Tags transit
+// @Accept json
+// @Produce json
+// @Success 201 {object} _vault.KeyringStatus
+// @Router /transit/keys [post]
+// @Param key body _vault.TransitKey true "Transit key"
+func (core *Core) CreateTransitKey(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	key := &_vault.TransitKey{}
+	if err := core.ParseJsonBody(c.Body(), key); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	status, err := core.vault.CreateTransitKey(c.Context(), principal, key)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(status)
+}
+
+// GetTransitKey godoc
+// @Summary Get a transit key
+// @Description Returns the active and available versions of a transit key
+// @Tags transit
+// @Accept */*
+// @Produce json
+// @Success 200 {object} _vault.KeyringStatus
+// @Router /transit/keys/{name} [get]
+// @Param name path string true "Transit Key Name"
+func (core *Core) GetTransitKey(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	status, err := core.vault.GetTransitKey(c.Context(), principal, c.Params("name"))
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// RotateTransitKey godoc
+// @Summary Rotate a transit key
+// @Description Creates a new version of a transit key, used for all new ciphertexts and signatures
+// @Tags transit
+// @Accept */*
+// @Produce json
+// @Success 201 {object} _vault.KeyringStatus
+// @Router /transit/keys/{name}/rotate [post]
+// @Param name path string true "Transit Key Name"
+func (core *Core) RotateTransitKey(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	status, err := core.vault.RotateTransitKey(c.Context(), principal, c.Params("name"))
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(status)
+}
+
+// TransitEncrypt godoc
+// @Summary Encrypt with a transit key
+// @Description Encrypts a plaintext, which should be base64 encoded for binary data, with the active version of a transit key
+// @Tags transit
+// @Accept json
+// @Produce json
+// @Success 200 {object} TransitCiphertextResponse
+// @Router /transit/encrypt/{name} [post]
+// @Param name path string true "Transit Key Name"
+// @Param request body TransitEncryptRequest true "Plaintext to encrypt"
+func (core *Core) TransitEncrypt(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	encryptRequest := new(TransitEncryptRequest)
+	if err := core.ParseJsonBody(c.Body(), &encryptRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	ciphertext, err := core.vault.TransitEncrypt(c.Context(), principal, c.Params("name"), encryptRequest.Plaintext)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(TransitCiphertextResponse{ciphertext})
+}
+
+// TransitDecrypt godoc
+// @Summary Decrypt with a transit key
+// @Description Decrypts a ciphertext written with any version of a transit key
+// @Tags transit
+// @Accept json
+// @Produce json
+// @Success 200 {object} TransitPlaintextResponse
+// @Router /transit/decrypt/{name} [post]
+// @Param name path string true "Transit Key Name"
+// @Param request body TransitCiphertextRequest true "Ciphertext to decrypt"
+func (core *Core) TransitDecrypt(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	decryptRequest := new(TransitCiphertextRequest)
+	if err := core.ParseJsonBody(c.Body(), &decryptRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	plaintext, err := core.vault.TransitDecrypt(c.Context(), principal, c.Params("name"), decryptRequest.Ciphertext)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(TransitPlaintextResponse{plaintext})
+}
+
+// TransitRewrap godoc
+// @Summary Rewrap with a transit key
+// @Description Re-encrypts a ciphertext with the active version of a transit key without revealing the plaintext
+// @Tags transit
+// @Accept json
+// @Produce json
+// @Success 200 {object} TransitCiphertextResponse
+// @Router /transit/rewrap/{name} [post]
+// @Param name path string true "Transit Key Name"
+// @Param request body TransitCiphertextRequest true "Ciphertext to rewrap"
+func (core *Core) TransitRewrap(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	rewrapRequest := new(TransitCiphertextRequest)
+	if err := core.ParseJsonBody(c.Body(), &rewrapRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	ciphertext, err := core.vault.TransitRewrap(c.Context(), principal, c.Params("name"), rewrapRequest.Ciphertext)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(TransitCiphertextResponse{ciphertext})
+}
+
+// TransitSign godoc
+// @Summary Sign with a transit key
+// @Description Signs an input with the active version of a transit key
+// @Tags transit
+// @Accept json
+// @Produce json
+// @Success 200 {object} TransitSignResponse
+// @Router /transit/sign/{name} [post]
+// @Param name path string true "Transit Key Name"
+// @Param request body TransitSignRequest true "Input to sign"
+func (core *Core) TransitSign(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	signRequest := new(TransitSignRequest)
+	if err := core.ParseJsonBody(c.Body(), &signRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	signature, err := core.vault.TransitSign(c.Context(), principal, c.Params("name"), signRequest.Input)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(TransitSignResponse{signature})
+}
+
+// TransitVerify godoc
+// @Summary Verify with a transit key
+// @Description Checks a signature made with any version of a transit key
+// @Tags transit
+// @Accept json
+// @Produce json
+// @Success 200 {object} TransitVerifyResponse
+// @Router /transit/verify/{name} [post]
+// @Param name path string true "Transit Key Name"
+// @Param request body TransitVerifyRequest true "Input and signature to verify"
+func (core *Core) TransitVerify(c *fiber.Ctx) error {
+	principal := GetSessionPrincipal(c)
+	verifyRequest := new(TransitVerifyRequest)
+	if err := core.ParseJsonBody(c.Body(), &verifyRequest); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{"Invalid body", []string{err.Error()}})
+	}
+
+	valid, err := core.vault.TransitVerify(c.Context(), principal, c.Params("name"), verifyRequest.Input, verifyRequest.Signature)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).JSON(TransitVerifyResponse{valid})
+}
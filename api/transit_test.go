@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	_vault "github.com/subrose/vault"
+)
+
+func TestTransit(t *testing.T) {
+	app, core := InitTestingVault(t)
+	authHeaders := map[string]string{
+		"Authorization": createBasicAuthHeader(core.conf.ADMIN_USERNAME, core.conf.ADMIN_PASSWORD),
+	}
+
+	t.Run("can create a transit key", func(t *testing.T) {
+		request := newRequest(t, http.MethodPost, "/transit/keys", authHeaders, _vault.TransitKey{Name: "payments"})
+		response := performRequest(t, app, request)
+		var status _vault.KeyringStatus
+		checkResponse(t, response, http.StatusCreated, &status)
+		assert.Equal(t, "payments", status.Name)
+		assert.Equal(t, 1, status.ActiveVersion)
+	})
+
+	t.Run("can encrypt, rewrap and decrypt", func(t *testing.T) {
+		request := newRequest(t, http.MethodPost, "/transit/encrypt/payments", authHeaders, TransitEncryptRequest{"aGVsbG8="})
+		response := performRequest(t, app, request)
+		var encrypted TransitCiphertextResponse
+		checkResponse(t, response, http.StatusOK, &encrypted)
+
+		request = newRequest(t, http.MethodPost, "/transit/keys/payments/rotate", authHeaders, nil)
+		response = performRequest(t, app, request)
+		checkResponse(t, response, http.StatusCreated, nil)
+
+		request = newRequest(t, http.MethodPost, "/transit/rewrap/payments", authHeaders, TransitCiphertextRequest{encrypted.Ciphertext})
+		response = performRequest(t, app, request)
+		var rewrapped TransitCiphertextResponse
+		checkResponse(t, response, http.StatusOK, &rewrapped)
+		assert.NotEqual(t, encrypted.Ciphertext, rewrapped.Ciphertext)
+
+		request = newRequest(t, http.MethodPost, "/transit/decrypt/payments", authHeaders, TransitCiphertextRequest{rewrapped.Ciphertext})
+		response = performRequest(t, app, request)
+		var decrypted TransitPlaintextResponse
+		checkResponse(t, response, http.StatusOK, &decrypted)
+		assert.Equal(t, "aGVsbG8=", decrypted.Plaintext)
+	})
+
+	t.Run("can sign and verify", func(t *testing.T) {
+		request := newRequest(t, http.MethodPost, "/transit/sign/payments", authHeaders, TransitSignRequest{"payload"})
+		response := performRequest(t, app, request)
+		var signed TransitSignResponse
+		checkResponse(t, response, http.StatusOK, &signed)
+
+		request = newRequest(t, http.MethodPost, "/transit/verify/payments", authHeaders, TransitVerifyRequest{"payload", signed.Signature})
+		response = performRequest(t, app, request)
+		var verified TransitVerifyResponse
+		checkResponse(t, response, http.StatusOK, &verified)
+		assert.Equal(t, true, verified.Valid)
+	})
+
+	t.Run("returns 404 for unknown transit keys", func(t *testing.T) {
+		request := newRequest(t, http.MethodPost, "/transit/encrypt/missing", authHeaders, TransitEncryptRequest{"aGVsbG8="})
+		response := performRequest(t, app, request)
+		checkResponse(t, response, http.StatusNotFound, nil)
+	})
+}
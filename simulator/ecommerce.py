@@ -35,7 +35,7 @@ admin.create_collection(
                 "is_indexed": False,
             },
             "credit_card": {
-                "type": "credit_card",
+                "type": "cc_number",
                 "is_indexed": False,
             },
             "address": {
@@ -122,7 +122,7 @@ for i in range(10):
         "name": fake.name(),
         "email": fake.email(),
         "phone": fake.e164(),
-        "credit_card": fake.credit_card_full(),
+        "credit_card": fake.credit_card_number(card_type="visa"),
         "address": fake.address(),
     }
     record_id = backend.create_record(
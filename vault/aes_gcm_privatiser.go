@@ -0,0 +1,65 @@
+package vault
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Ciphertexts produced by AESGCMPrivatiser carry this prefix so they can be told apart from
+// values written by the legacy AESPrivatiser, whose base64 output never contains a colon.
+const gcmCiphertextPrefix = "v1:"
+
+// AESGCMPrivatiser encrypts values with AES-GCM using a random nonce per value, so identical
+// plaintexts produce different ciphertexts and tampered ciphertexts fail to decrypt.
+// Values written by AESPrivatiser with the same secret remain readable.
+type AESGCMPrivatiser struct {
+	aead   cipher.AEAD
+	legacy *AESPrivatiser
+}
+
+func NewAESGCMPrivatiser(secret string) (*AESGCMPrivatiser, error) {
+	legacy, err := NewAESPrivatiser(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(legacy.block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMPrivatiser{aead, legacy}, nil
+}
+
+func (p *AESGCMPrivatiser) Encrypt(text string) (string, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := p.aead.Seal(nonce, nonce, []byte(text), nil)
+
+	return gcmCiphertextPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (p *AESGCMPrivatiser) Decrypt(encodedText string) (string, error) {
+	if !strings.HasPrefix(encodedText, gcmCiphertextPrefix) {
+		return p.legacy.Decrypt(encodedText)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encodedText[len(gcmCiphertextPrefix):])
+	if err != nil {
+		return "", err
+	}
+	if len(data) < p.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := data[:p.aead.NonceSize()], data[p.aead.NonceSize():]
+	plainText, err := p.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("ciphertext failed authentication")
+	}
+
+	return string(plainText), nil
+}
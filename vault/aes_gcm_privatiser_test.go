@@ -0,0 +1,55 @@
+package vault
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAESGCMPrivatiser(t *testing.T) {
+	secret := "abc&1*~#^2^#s0^=)^^7%b34"
+
+	t.Run("can encrypt and decrypt", func(t *testing.T) {
+		p, err := NewAESGCMPrivatiser(secret)
+		assert.NoError(t, err)
+
+		encrypted, err := p.Encrypt("hello world!")
+		assert.NoError(t, err)
+
+		decrypted, err := p.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+
+	t.Run("identical plaintexts produce different ciphertexts", func(t *testing.T) {
+		p, _ := NewAESGCMPrivatiser(secret)
+
+		first, _ := p.Encrypt("hello world!")
+		second, _ := p.Encrypt("hello world!")
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("tampered ciphertext fails to decrypt", func(t *testing.T) {
+		p, _ := NewAESGCMPrivatiser(secret)
+		encrypted, _ := p.Encrypt("hello world!")
+
+		data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, gcmCiphertextPrefix))
+		data[len(data)-1] ^= 0x01
+		tampered := gcmCiphertextPrefix + base64.StdEncoding.EncodeToString(data)
+
+		_, err := p.Decrypt(tampered)
+		assert.Error(t, err)
+	})
+
+	t.Run("can decrypt legacy ciphertext", func(t *testing.T) {
+		legacy, _ := NewAESPrivatiser(secret)
+		encrypted, _ := legacy.Encrypt("hello world!")
+
+		p, _ := NewAESGCMPrivatiser(secret)
+		decrypted, err := p.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+}
@@ -0,0 +1,123 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BackfillBlindIndexes computes the missing blind indexes of records written before indexed
+// fields were searched by blind index, so that SearchRecords finds them, and recomputes those of
+// collections whose indexes have another version than blindIndex's. It's run once the keys of
+// the vault are loaded, after the migrations adding the index columns.
+func (vault Vault) BackfillBlindIndexes(ctx context.Context) error {
+	collectionNames, err := vault.Db.GetCollections(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, collectionName := range collectionNames {
+		col, err := vault.Db.GetCollection(ctx, collectionName)
+		if err != nil {
+			return err
+		}
+		rebuild := col.IndexVersion != blindIndexVersion
+		var recordIds []string
+		if rebuild {
+			recordIds, err = vault.Db.GetRecords(ctx, collectionName)
+		} else {
+			recordIds, err = vault.Db.GetUnindexedRecords(ctx, collectionName)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(recordIds) > 0 {
+			priv, err := vault.collectionPrivatiser(col)
+			if err != nil {
+				return err
+			}
+			for _, recordId := range recordIds {
+				if err := vault.backfillBlindIndexes(ctx, priv, col, recordId, rebuild); err != nil {
+					return fmt.Errorf("failed to index record %s of collection %s: %w", recordId, collectionName, err)
+				}
+			}
+			vault.Logger.Info(fmt.Sprintf("Indexed %d records of collection %s", len(recordIds), collectionName))
+		}
+		if rebuild {
+			if err := vault.Db.SetCollectionIndexVersion(ctx, collectionName, blindIndexVersion); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backfillBlindIndexes computes the missing blind indexes of a record, or all of them when
+// rebuilding, re-signing it if it already has a MAC.
+func (vault Vault) backfillBlindIndexes(ctx context.Context, priv Privatiser, col *Collection, recordId string, rebuild bool) error {
+	current, err := vault.Db.GetRecord(ctx, col.Name, recordId)
+	if err != nil {
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			return nil
+		}
+		return err
+	}
+	recordPriv, err := vault.recordPrivatiser(ctx, col, priv, current)
+	if err != nil {
+		var se *ShreddedError
+		if errors.As(err, &se) {
+			return nil
+		}
+		return err
+	}
+	if err := vault.verifyRecord(col, current); err != nil {
+		var ie *IntegrityError
+		if errors.As(err, &ie) {
+			vault.Logger.Warn(err.Error())
+			return nil
+		}
+		return err
+	}
+
+	indexedRecord := make(Record)
+	for fieldName, field := range col.Fields {
+		column := blindIndexColumn(fieldName)
+		if !field.IsIndexed || fieldName == subject_id_field || current[fieldName] == "" || (current[column] != "" && !rebuild) {
+			continue
+		}
+		plainValue, err := vault.decryptField(recordPriv, col, recordId, fieldName, current[fieldName])
+		if err != nil {
+			return err
+		}
+		if indexedRecord[column], err = vault.blindIndex(col.Name, fieldName, plainValue); err != nil {
+			return err
+		}
+	}
+	if len(indexedRecord) == 0 {
+		return nil
+	}
+
+	if current[record_mac_field] != "" {
+		// The MAC covers the blind indexes
+		signedRecord := make(Record)
+		for fieldName, value := range current {
+			signedRecord[fieldName] = value
+		}
+		for fieldName, value := range indexedRecord {
+			signedRecord[fieldName] = value
+		}
+		if indexedRecord[record_mac_field], err = vault.recordMAC(col, signedRecord); err != nil {
+			return err
+		}
+	}
+
+	err = vault.Db.UpdateRecordIfUnchanged(ctx, col.Name, recordId, current, indexedRecord)
+	var ce *ConflictError
+	if errors.As(err, &ce) {
+		// Rewritten concurrently, with its blind indexes
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// BlobStore keeps the encrypted contents of file fields. Blobs are written once under a new
+// id and only ever read back or deleted, so stores don't need to support overwrites.
+type BlobStore interface {
+	Put(ctx context.Context, id string, content io.Reader) error
+	Get(ctx context.Context, id string) (io.ReadCloser, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Blob ids are generated by the vault, they are checked anyway as they become file names.
+var blobIdPattern = regexp.MustCompile("^blob_[A-Za-z0-9]+$")
+
+// LocalBlobStore keeps blobs as files in a directory of the local filesystem.
+type LocalBlobStore struct {
+	dir string
+}
+
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{dir}, nil
+}
+
+func (s *LocalBlobStore) path(id string) (string, error) {
+	if !blobIdPattern.MatchString(id) {
+		return "", &ValueError{Msg: fmt.Sprintf("invalid blob id %s", id)}
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// Put writes a blob to a temporary file first, so partial uploads are never visible.
+func (s *LocalBlobStore) Put(ctx context.Context, id string, content io.Reader) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalBlobStore) Get(ctx context.Context, id string) (io.ReadCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &NotFoundError{"blob", id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalBlobStore(dir)
+	assert.NoError(t, err)
+	id := GenerateId("blob")
+
+	assert.NoError(t, store.Put(ctx, id, strings.NewReader("encrypted contents")))
+	blob, err := store.Get(ctx, id)
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(blob)
+	assert.NoError(t, err)
+	assert.NoError(t, blob.Close())
+	assert.Equal(t, "encrypted contents", string(contents))
+
+	assert.NoError(t, store.Delete(ctx, id))
+	_, err = store.Get(ctx, id)
+	var notFoundErr *NotFoundError
+	assert.ErrorAs(t, err, &notFoundErr)
+	assert.NoError(t, store.Delete(ctx, id))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLocalBlobStoreInvalidIds(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, id := range []string{"", "../blob_1", "blob_1/../../etc", "record_1"} {
+		var valueErr *ValueError
+		assert.ErrorAs(t, store.Put(ctx, id, strings.NewReader("")), &valueErr, id)
+		_, err := store.Get(ctx, id)
+		assert.ErrorAs(t, err, &valueErr, id)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestLocalBlobStoreFailedPut(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalBlobStore(dir)
+	assert.NoError(t, err)
+
+	id := GenerateId("blob")
+	assert.Error(t, store.Put(ctx, id, failingReader{}))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
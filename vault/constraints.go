@@ -0,0 +1,108 @@
+package vault
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldConstraints restrict the values of a field beyond what its ptype accepts. Lengths are
+// in characters of the normalized value and all bounds are inclusive.
+type FieldConstraints struct {
+	Enum      []string `json:"enum,omitempty"` // Values must be one of these
+	MinLength *int     `json:"min_length,omitempty"`
+	MaxLength *int     `json:"max_length,omitempty"`
+	Min       *int     `json:"min,omitempty"`      // Integer fields only
+	Max       *int     `json:"max,omitempty"`      // Integer fields only
+	MinDate   string   `json:"min_date,omitempty"` // Date fields only, formatted as YYYY-MM-DD
+	MaxDate   string   `json:"max_date,omitempty"` // Date fields only, formatted as YYYY-MM-DD
+}
+
+// validate checks that the constraints apply to a field and can be met.
+func (c FieldConstraints) validate(field Field) error {
+	pType := PTypeName(field.Type)
+	if (c.Min != nil || c.Max != nil) && pType != IntegerType {
+		return &ValueError{Msg: fmt.Sprintf("min and max are only supported by the %s type", IntegerType)}
+	}
+	if (c.MinDate != "" || c.MaxDate != "") && pType != DateType {
+		return &ValueError{Msg: fmt.Sprintf("min_date and max_date are only supported by the %s type", DateType)}
+	}
+	if (c.MinLength != nil && *c.MinLength < 0) || (c.MaxLength != nil && *c.MaxLength < 0) {
+		return &ValueError{Msg: "min_length and max_length must not be negative"}
+	}
+	if c.MinLength != nil && c.MaxLength != nil && *c.MinLength > *c.MaxLength {
+		return &ValueError{Msg: "min_length must not be greater than max_length"}
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return &ValueError{Msg: "min must not be greater than max"}
+	}
+
+	for _, date := range []string{c.MinDate, c.MaxDate} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return &ValueError{Msg: fmt.Sprintf("invalid date %s, must be formatted as YYYY-MM-DD", date)}
+		}
+	}
+	if c.MinDate != "" && c.MaxDate != "" && c.MinDate > c.MaxDate {
+		return &ValueError{Msg: "min_date must not be after max_date"}
+	}
+
+	for _, value := range c.Enum {
+		pTypeValue, err := GetFieldPType(field, value)
+		if err != nil {
+			return &ValueError{Msg: fmt.Sprintf("invalid enum value %s: %s", value, err)}
+		}
+		// Values are compared once normalized
+		if pTypeValue.GetPlain() != value {
+			return &ValueError{Msg: fmt.Sprintf("enum value %s must be written as %s", value, pTypeValue.GetPlain())}
+		}
+	}
+	return nil
+}
+
+// check returns a ValidationError for every constraint a value fails.
+func (c FieldConstraints) check(fieldName string, value PType) []*ValidationError {
+	failures := []*ValidationError{}
+	fail := func(tag string, param string) {
+		failures = append(failures, &ValidationError{FailedField: fieldName, Tag: tag, Value: param})
+	}
+
+	plain := value.GetPlain()
+	if len(c.Enum) > 0 && !contains(c.Enum, plain) {
+		fail("enum", fmt.Sprint(c.Enum))
+	}
+	if c.MinLength != nil && utf8.RuneCountInString(plain) < *c.MinLength {
+		fail("min_length", strconv.Itoa(*c.MinLength))
+	}
+	if c.MaxLength != nil && utf8.RuneCountInString(plain) > *c.MaxLength {
+		fail("max_length", strconv.Itoa(*c.MaxLength))
+	}
+
+	switch v := value.(type) {
+	case Integer:
+		if c.Min != nil && v.val < *c.Min {
+			fail("min", strconv.Itoa(*c.Min))
+		}
+		if c.Max != nil && v.val > *c.Max {
+			fail("max", strconv.Itoa(*c.Max))
+		}
+	case Date:
+		// Dates formatted as YYYY-MM-DD compare chronologically
+		if c.MinDate != "" && plain < c.MinDate {
+			fail("min_date", c.MinDate)
+		}
+		if c.MaxDate != "" && plain > c.MaxDate {
+			fail("max_date", c.MaxDate)
+		}
+	}
+	return failures
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func intPointer(i int) *int {
+	return &i
+}
+
+func TestFieldConstraints(t *testing.T) {
+	t.Run("can check values against constraints", func(t *testing.T) {
+		for _, tc := range []struct {
+			pType       PTypeName
+			constraints FieldConstraints
+			value       string
+			failed      []string
+		}{
+			{StringType, FieldConstraints{Enum: []string{"active", "closed"}}, "active", []string{}},
+			{StringType, FieldConstraints{Enum: []string{"active", "closed"}}, "pending", []string{"enum"}},
+			{StringType, FieldConstraints{MinLength: intPointer(2), MaxLength: intPointer(4)}, "abcde", []string{"max_length"}},
+			{NameType, FieldConstraints{MinLength: intPointer(2)}, "Ö", []string{"min_length"}},
+			{IntegerType, FieldConstraints{Min: intPointer(0), Max: intPointer(150)}, "42", []string{}},
+			{IntegerType, FieldConstraints{Min: intPointer(0), Max: intPointer(150)}, "151", []string{"max"}},
+			{IntegerType, FieldConstraints{Min: intPointer(0), MaxLength: intPointer(1)}, "-10", []string{"max_length", "min"}},
+			{DateType, FieldConstraints{MinDate: "1900-01-01", MaxDate: "2023-12-31"}, "1899-12-31", []string{"min_date"}},
+			{DateType, FieldConstraints{MinDate: "1900-01-01", MaxDate: "2023-12-31"}, "2023-12-31", []string{}},
+		} {
+			value, err := GetPType(tc.pType, tc.value)
+			assert.NoError(t, err)
+			failed := []string{}
+			for _, failure := range tc.constraints.check("field", value) {
+				assert.Equal(t, "field", failure.FailedField)
+				failed = append(failed, failure.Tag)
+			}
+			assert.Equal(t, tc.failed, failed, tc.value)
+		}
+	})
+
+	t.Run("invalid constraints are rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			pType       PTypeName
+			constraints FieldConstraints
+		}{
+			{StringType, FieldConstraints{Min: intPointer(0)}},
+			{IntegerType, FieldConstraints{MinDate: "2000-01-01"}},
+			{IntegerType, FieldConstraints{Min: intPointer(10), Max: intPointer(0)}},
+			{StringType, FieldConstraints{MinLength: intPointer(-1)}},
+			{StringType, FieldConstraints{MinLength: intPointer(4), MaxLength: intPointer(2)}},
+			{DateType, FieldConstraints{MinDate: "01/01/2000"}},
+			{DateType, FieldConstraints{MinDate: "2020-01-01", MaxDate: "2010-01-01"}},
+			{EmailType, FieldConstraints{Enum: []string{"not an email"}}},
+			{IBANType, FieldConstraints{Enum: []string{"gb33 bukb 2020 1555 5555 55"}}},
+		} {
+			var ve *ValueError
+			assert.ErrorAs(t, tc.constraints.validate(Field{Type: string(tc.pType)}), &ve)
+		}
+		assert.NoError(t, FieldConstraints{Enum: []string{"GB33BUKB20201555555555"}}.validate(Field{Type: string(IBANType)}))
+	})
+}
@@ -0,0 +1,174 @@
+package vault
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ciphertexts encrypted with a collection data key are formatted as "v3:<base64 payload>", or
+// "v5:<base64 payload>" when they are bound to associated data.
+const (
+	dataKeyCiphertextPrefix      = "v3:"
+	boundDataKeyCiphertextPrefix = "v5:"
+)
+
+const dataKeySize = 32
+
+// DataKeyPrivatiser encrypts the records of a single collection with its own data encryption
+// key (DEK), or the records of a subject with its subject key. Values written before the
+// collection had a DEK are decrypted with fallback.
+type DataKeyPrivatiser struct {
+	aead        cipher.AEAD
+	fallback    Privatiser
+	prefix      string
+	boundPrefix string
+}
+
+func NewDataKeyPrivatiser(dataKey []byte, fallback Privatiser) (*DataKeyPrivatiser, error) {
+	return newKeyPrivatiser(dataKey, fallback, dataKeyCiphertextPrefix, boundDataKeyCiphertextPrefix)
+}
+
+func newKeyPrivatiser(key []byte, fallback Privatiser, prefix string, boundPrefix string) (*DataKeyPrivatiser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKeyPrivatiser{aead, fallback, prefix, boundPrefix}, nil
+}
+
+func (p *DataKeyPrivatiser) Encrypt(text string) (string, error) {
+	return p.seal(p.prefix, text, nil)
+}
+
+func (p *DataKeyPrivatiser) Decrypt(encodedText string) (string, error) {
+	return p.DecryptWithAD(encodedText, nil)
+}
+
+// EncryptWithAD encrypts text bound to associatedData, which must be given again to decrypt it.
+func (p *DataKeyPrivatiser) EncryptWithAD(text string, associatedData []byte) (string, error) {
+	return p.seal(p.boundPrefix, text, associatedData)
+}
+
+// DecryptWithAD decrypts a ciphertext bound to associatedData. Ciphertexts written before
+// values were bound are decrypted without it.
+func (p *DataKeyPrivatiser) DecryptWithAD(encodedText string, associatedData []byte) (string, error) {
+	switch {
+	case strings.HasPrefix(encodedText, p.boundPrefix):
+		return p.open(encodedText[len(p.boundPrefix):], associatedData)
+	case strings.HasPrefix(encodedText, p.prefix):
+		return p.open(encodedText[len(p.prefix):], nil)
+	default:
+		return decryptWithAD(p.fallback, encodedText, associatedData)
+	}
+}
+
+func (p *DataKeyPrivatiser) seal(prefix string, text string, associatedData []byte) (string, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := p.aead.Seal(nonce, nonce, []byte(text), associatedData)
+
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (p *DataKeyPrivatiser) open(payload string, associatedData []byte) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < p.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := data[:p.aead.NonceSize()], data[p.aead.NonceSize():]
+	plainText, err := p.aead.Open(nil, nonce, sealed, associatedData)
+	if err != nil {
+		return "", &IntegrityError{Msg: "ciphertext failed authentication"}
+	}
+	return string(plainText), nil
+}
+
+// isBound reports whether a ciphertext was encrypted with this privatiser's key and bound to
+// associated data.
+func (p *DataKeyPrivatiser) isBound(encodedText string) bool {
+	return strings.HasPrefix(encodedText, p.boundPrefix)
+}
+
+func isDataKeyCiphertext(encodedText string) bool {
+	return strings.HasPrefix(encodedText, dataKeyCiphertextPrefix)
+}
+
+// boundPrivatiser decrypts the values of a strict collection, whose records have all been
+// written bound to their record and field. Anything else stored in a field, such as a wrapped
+// key copied from elsewhere, is rejected rather than decrypted without associated data.
+type boundPrivatiser struct {
+	priv Privatiser
+}
+
+func (p boundPrivatiser) Encrypt(text string) (string, error) {
+	return p.priv.Encrypt(text)
+}
+
+func (p boundPrivatiser) Decrypt(encodedText string) (string, error) {
+	return p.DecryptWithAD(encodedText, nil)
+}
+
+func (p boundPrivatiser) EncryptWithAD(text string, associatedData []byte) (string, error) {
+	return encryptWithAD(p.priv, text, associatedData)
+}
+
+func (p boundPrivatiser) DecryptWithAD(encodedText string, associatedData []byte) (string, error) {
+	if !strings.HasPrefix(encodedText, boundDataKeyCiphertextPrefix) && !strings.HasPrefix(encodedText, boundSubjectKeyCiphertextPrefix) {
+		return "", &IntegrityError{Msg: "ciphertext is not bound to its field"}
+	}
+	return decryptWithAD(p.priv, encodedText, associatedData)
+}
+
+func (p boundPrivatiser) isBound(encodedText string) bool {
+	keyPriv, ok := p.priv.(interface{ isBound(string) bool })
+	return ok && keyPriv.isBound(encodedText)
+}
+
+// keyAssociatedData is the associated data a key is wrapped with, e.g. "data key" and the name
+// of its collection, so that it can't be unwrapped as a record value or as another kind of key.
+// PostgreSQL doesn't allow NUL in names, which keeps it apart from fieldAssociatedData.
+func keyAssociatedData(kind string, name string) []byte {
+	return []byte(fmt.Sprintf("%s\x00%s", kind, name))
+}
+
+// generateDataKey creates a new data key, returned wrapped by the vault privatiser.
+func (vault Vault) generateDataKey(collectionName string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	return encryptWithAD(vault.Priv, base64.StdEncoding.EncodeToString(dataKey), keyAssociatedData("data key", collectionName))
+}
+
+// collectionPrivatiser returns the privatiser for the records of a collection. Collections
+// created before data keys were introduced are encrypted with the vault privatiser directly.
+func (vault Vault) collectionPrivatiser(col *Collection) (Privatiser, error) {
+	dataKey, err := vault.collectionDataKey(col)
+	if err != nil || dataKey == nil {
+		return vault.Priv, err
+	}
+	return NewDataKeyPrivatiser(dataKey, vault.Priv)
+}
+
+// collectionDataKey unwraps the data key of a collection, which is nil for collections
+// created before data keys were introduced.
+func (vault Vault) collectionDataKey(col *Collection) ([]byte, error) {
+	if col.DataKey == "" {
+		return nil, nil
+	}
+	encodedKey, err := decryptWithAD(vault.Priv, col.DataKey, keyAssociatedData("data key", col.Name))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encodedKey)
+}
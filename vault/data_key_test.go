@@ -0,0 +1,174 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataKeyPrivatiser(t *testing.T) {
+	secretPriv, _ := NewAESGCMPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
+	newDataKey := func() []byte {
+		dataKey := make([]byte, dataKeySize)
+		_, _ = rand.Read(dataKey)
+		return dataKey
+	}
+
+	t.Run("can encrypt and decrypt", func(t *testing.T) {
+		p, err := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+		assert.NoError(t, err)
+
+		encrypted, err := p.Encrypt("hello world!")
+		assert.NoError(t, err)
+		assert.True(t, isDataKeyCiphertext(encrypted))
+
+		decrypted, err := p.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+
+	t.Run("cannot decrypt values of another data key", func(t *testing.T) {
+		p, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+		other, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+
+		encrypted, _ := other.Encrypt("hello world!")
+		_, err := p.Decrypt(encrypted)
+		assert.Error(t, err)
+	})
+
+	t.Run("binds values to associated data", func(t *testing.T) {
+		p, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+
+		encrypted, err := p.EncryptWithAD("hello world!", []byte("customers/rec_1/name"))
+		assert.NoError(t, err)
+		decrypted, err := p.DecryptWithAD(encrypted, []byte("customers/rec_1/name"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+
+		var ie *IntegrityError
+		_, err = p.DecryptWithAD(encrypted, []byte("customers/rec_2/name"))
+		assert.ErrorAs(t, err, &ie)
+		_, err = p.Decrypt(encrypted)
+		assert.ErrorAs(t, err, &ie)
+	})
+
+	t.Run("can decrypt values written before values were bound", func(t *testing.T) {
+		p, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+
+		encrypted, _ := p.Encrypt("hello world!")
+		decrypted, err := p.DecryptWithAD(encrypted, []byte("customers/rec_1/name"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+
+	t.Run("can decrypt values written before the data key", func(t *testing.T) {
+		p, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+
+		encrypted, _ := secretPriv.Encrypt("hello world!")
+		decrypted, err := p.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+
+	t.Run("strict collections only decrypt bound values", func(t *testing.T) {
+		p, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+		strict := boundPrivatiser{p}
+		associatedData := []byte("customers/rec_1/name")
+
+		encrypted, _ := strict.EncryptWithAD("hello world!", associatedData)
+		decrypted, err := strict.DecryptWithAD(encrypted, associatedData)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+		assert.True(t, strict.isBound(encrypted))
+
+		var ie *IntegrityError
+		for _, unbound := range []string{encrypted[len(boundDataKeyCiphertextPrefix):], mustEncrypt(t, p, "hello world!"), mustEncrypt(t, secretPriv, "hello world!")} {
+			_, err = strict.DecryptWithAD(unbound, associatedData)
+			assert.ErrorAs(t, err, &ie, unbound)
+		}
+	})
+
+	t.Run("wrapped keys can't be decrypted as values", func(t *testing.T) {
+		p, _ := NewDataKeyPrivatiser(newDataKey(), secretPriv)
+
+		wrappedKey, _ := p.EncryptWithAD("c2VjcmV0", keyAssociatedData("subject key", "rec_1"))
+		var ie *IntegrityError
+		_, err := p.DecryptWithAD(wrappedKey, []byte("customers/rec_1/name"))
+		assert.ErrorAs(t, err, &ie)
+		_, err = p.DecryptWithAD(wrappedKey, keyAssociatedData("private key", "rec_1"))
+		assert.ErrorAs(t, err, &ie)
+	})
+}
+
+func mustEncrypt(t *testing.T, priv Privatiser, text string) string {
+	encrypted, err := priv.Encrypt(text)
+	assert.NoError(t, err)
+	return encrypted
+}
+
+func TestCollectionDataKeys(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+	for _, name := range []string{"customers", "employees"} {
+		col := Collection{Name: name, Fields: map[string]Field{
+			"name": {Type: "name", IsIndexed: false},
+		}}
+		assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+	}
+
+	t.Run("collections get their own data key", func(t *testing.T) {
+		customers, _ := db.GetCollection(ctx, "customers")
+		employees, _ := db.GetCollection(ctx, "employees")
+		assert.NotEmpty(t, customers.DataKey)
+		assert.NotEmpty(t, employees.DataKey)
+		assert.NotEqual(t, customers.DataKey, employees.DataKey)
+	})
+
+	t.Run("records are encrypted under the collection data key", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "John Crawford"})
+		assert.NoError(t, err)
+
+		stored, _ := db.GetRecord(ctx, "customers", recordId)
+		_, err = vault.Priv.Decrypt(stored["name"])
+		assert.Error(t, err)
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, "customers", recordId, map[string]string{"name": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "John Crawford", record["name"])
+	})
+
+	t.Run("values moved to another record or field fail to decrypt", func(t *testing.T) {
+		col := Collection{Name: "patients", Fields: map[string]Field{
+			"name":    {Type: "name", IsIndexed: false},
+			"surname": {Type: "name", IsIndexed: false},
+		}}
+		assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+		recordId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John", "surname": "Crawford"})
+		stored, _ := db.GetRecord(ctx, col.Name, recordId)
+
+		// The MAC is cleared, which only older collections allow, so that the values themselves
+		// are checked
+		err := db.UpdateRecordIfUnchanged(ctx, col.Name, recordId, stored, Record{"name": stored["surname"], "surname": stored["name"], record_mac_field: ""})
+		assert.NoError(t, err)
+		db.(*SqlStore).db.Exec("UPDATE collections_metadata SET strict = false WHERE name = ?", col.Name)
+
+		_, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"name": "plain"})
+		var ie *IntegrityError
+		assert.ErrorAs(t, err, &ie)
+	})
+
+	t.Run("data key of one collection cannot decrypt another", func(t *testing.T) {
+		recordId, _ := vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "John Crawford"})
+		stored, _ := db.GetRecord(ctx, "customers", recordId)
+
+		employees, _ := db.GetCollection(ctx, "employees")
+		employeesPriv, err := vault.collectionPrivatiser(employees)
+		assert.NoError(t, err)
+		_, err = employeesPriv.Decrypt(stored["name"])
+		assert.Error(t, err)
+	})
+}
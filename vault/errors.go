@@ -41,6 +41,27 @@ func (e *ConflictError) Error() string {
 	return fmt.Sprintf("conflict: %s", e.resourceName)
 }
 
+type SealedError struct{}
+
+func (e *SealedError) Error() string {
+	return "vault is sealed"
+}
+
+// ShreddedError is returned for records of a subject whose key was destroyed.
+type ShreddedError struct{ subjectId string }
+
+func (e *ShreddedError) Error() string {
+	return fmt.Sprintf("subject %s was shredded", e.subjectId)
+}
+
+// IntegrityError is returned when a ciphertext fails authentication, e.g. because it was
+// tampered with or moved to another record or field.
+type IntegrityError struct{ Msg string }
+
+func (e *IntegrityError) Error() string {
+	return e.Msg
+}
+
 type ValueError struct{ Msg string }
 
 func (e *ValueError) Error() string {
@@ -77,7 +98,7 @@ type ValidationErrors struct {
 func (e ValidationErrors) Error() string {
 	errors := make([]string, len(e.Errors))
 	for i, err := range e.Errors {
-		errors[i] = fmt.Sprintf("%s: %s", err.FailedField, err.Tag)
+		errors[i] = strings.TrimSpace(fmt.Sprintf("%s: %s %s", err.FailedField, err.Tag, err.Value))
 	}
 	return strings.Join(errors, ", ")
 }
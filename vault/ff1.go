@@ -0,0 +1,160 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const ff1Alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// FF1 is the format-preserving encryption mode of NIST SP 800-38G. It encrypts a string of
+// numerals in the given radix into another string of the same length and radix.
+type FF1 struct {
+	block     cipher.Block
+	radix     int
+	minLength int
+}
+
+func NewFF1(key []byte, radix int) (*FF1, error) {
+	if radix < 2 || radix > len(ff1Alphabet) {
+		return nil, fmt.Errorf("unsupported radix %d", radix)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	// The domain must hold at least a million values (SP 800-38G Rev. 1)
+	minLength := int(math.Ceil(6 / math.Log10(float64(radix))))
+	return &FF1{block, radix, minLength}, nil
+}
+
+func (f *FF1) Encrypt(tweak []byte, numerals string) (string, error) {
+	return f.cipher(tweak, numerals, true)
+}
+
+func (f *FF1) Decrypt(tweak []byte, numerals string) (string, error) {
+	return f.cipher(tweak, numerals, false)
+}
+
+func (f *FF1) cipher(tweak []byte, numerals string, encrypt bool) (string, error) {
+	n := len(numerals)
+	if n < f.minLength {
+		return "", &ValueError{Msg: fmt.Sprintf("value must have at least %d characters to be format-preserving encrypted", f.minLength)}
+	}
+	for _, c := range numerals {
+		if strings.IndexRune(ff1Alphabet[:f.radix], c) < 0 {
+			return "", &ValueError{Msg: fmt.Sprintf("invalid numeral %q for radix %d", c, f.radix)}
+		}
+	}
+
+	u := n / 2
+	v := n - u
+	a, b := numerals[:u], numerals[u:]
+
+	radix := big.NewInt(int64(f.radix))
+	byteLen := int(math.Ceil(math.Ceil(float64(v)*math.Log2(float64(f.radix))) / 8))
+	d := 4*((byteLen+3)/4) + 4
+
+	p := make([]byte, 16)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3], p[4], p[5] = byte(f.radix>>16), byte(f.radix>>8), byte(f.radix)
+	p[6] = 10
+	p[7] = byte(u % 256)
+	binary.BigEndian.PutUint32(p[8:12], uint32(n))
+	binary.BigEndian.PutUint32(p[12:16], uint32(len(tweak)))
+
+	padLen := (((-len(tweak) - byteLen - 1) % 16) + 16) % 16
+	q := make([]byte, len(tweak)+padLen+1+byteLen)
+	copy(q, tweak)
+
+	modU := new(big.Int).Exp(radix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+
+	for round := 0; round < 10; round++ {
+		i := round
+		if !encrypt {
+			i = 9 - round
+		}
+
+		// The round function takes the half that isn't being modified
+		source := b
+		if !encrypt {
+			source = a
+		}
+		q[len(tweak)+padLen] = byte(i)
+		num := f.num(source).Bytes()
+		numField := q[len(q)-byteLen:]
+		for j := range numField {
+			numField[j] = 0
+		}
+		copy(numField[byteLen-len(num):], num)
+
+		y := new(big.Int).SetBytes(f.prf(p, q, d))
+
+		m, mod := u, modU
+		if i%2 == 1 {
+			m, mod = v, modV
+		}
+		var c *big.Int
+		if encrypt {
+			c = new(big.Int).Add(f.num(a), y)
+		} else {
+			c = new(big.Int).Sub(f.num(b), y)
+		}
+		c.Mod(c, mod)
+		result := f.str(c, m)
+
+		if encrypt {
+			a, b = b, result
+		} else {
+			a, b = result, a
+		}
+	}
+	return a + b, nil
+}
+
+// prf computes the round output S: the CBC-MAC of P || Q extended to d bytes.
+func (f *FF1) prf(p []byte, q []byte, d int) []byte {
+	r := make([]byte, 16)
+	for _, data := range [][]byte{p, q} {
+		for j := 0; j < len(data); j += 16 {
+			for k := 0; k < 16; k++ {
+				r[k] ^= data[j+k]
+			}
+			f.block.Encrypt(r, r)
+		}
+	}
+
+	s := make([]byte, 0, ((d+15)/16)*16)
+	s = append(s, r...)
+	for j := 1; len(s) < d; j++ {
+		block := make([]byte, 16)
+		copy(block, r)
+		counter := make([]byte, 8)
+		binary.BigEndian.PutUint64(counter, uint64(j))
+		for k := 0; k < 8; k++ {
+			block[8+k] ^= counter[k]
+		}
+		f.block.Encrypt(block, block)
+		s = append(s, block...)
+	}
+	return s[:d]
+}
+
+func (f *FF1) num(numerals string) *big.Int {
+	result, _ := new(big.Int).SetString(numerals, f.radix)
+	if result == nil {
+		return new(big.Int)
+	}
+	return result
+}
+
+func (f *FF1) str(x *big.Int, length int) string {
+	s := x.Text(f.radix)
+	return strings.Repeat("0", length-len(s)) + s
+}
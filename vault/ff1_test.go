@@ -0,0 +1,46 @@
+package vault
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFF1(t *testing.T) {
+	// Samples from NIST SP 800-38G
+	samples := []struct {
+		key        string
+		tweak      string
+		plainText  string
+		cipherText string
+	}{
+		{"2B7E151628AED2A6ABF7158809CF4F3C", "", "0123456789", "2433477484"},
+		{"2B7E151628AED2A6ABF7158809CF4F3C", "39383736353433323130", "0123456789", "6124200773"},
+		{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "", "0123456789", "6657667009"},
+		{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "39383736353433323130", "0123456789", "1001623463"},
+	}
+
+	for _, sample := range samples {
+		key, _ := hex.DecodeString(sample.key)
+		tweak, _ := hex.DecodeString(sample.tweak)
+		ff1, err := NewFF1(key, 10)
+		assert.NoError(t, err)
+
+		cipherText, err := ff1.Encrypt(tweak, sample.plainText)
+		assert.NoError(t, err)
+		assert.Equal(t, sample.cipherText, cipherText)
+
+		plainText, err := ff1.Decrypt(tweak, cipherText)
+		assert.NoError(t, err)
+		assert.Equal(t, sample.plainText, plainText)
+	}
+
+	t.Run("rejects values outside the domain", func(t *testing.T) {
+		ff1, _ := NewFF1(make([]byte, 32), 10)
+		_, err := ff1.Encrypt(nil, "12345")
+		assert.Error(t, err)
+		_, err = ff1.Encrypt(nil, "12345a")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,431 @@
+package vault
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultMaxFileSize is the size limit of file fields that don't set their own, in bytes.
+const DefaultMaxFileSize int64 = 10 << 20
+
+// FileInfo describes a file uploaded to a file field. It's the plain format of the field.
+type FileInfo struct {
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"` // Sniffed from the contents
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// File is the value of a file field. Its contents are kept in the vault's BlobStore,
+// encrypted under a key of their own which is only stored in the record. Records hold file
+// values encrypted like any other, so files are shredded along with their subject.
+type File struct {
+	info   FileInfo
+	blobId string
+	key    []byte
+}
+
+type storedFile struct {
+	FileInfo
+	BlobId string `json:"blob_id"`
+	Key    []byte `json:"key"`
+}
+
+func parseFile(value string) (File, error) {
+	var stored storedFile
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return File{}, &ValueError{Msg: "invalid file value"}
+	}
+	return File{stored.FileInfo, stored.BlobId, stored.Key}, nil
+}
+
+// stored returns the value of the field holding the file.
+func (f File) stored() string {
+	stored, _ := json.Marshal(storedFile{f.info, f.blobId, f.key})
+	return string(stored)
+}
+
+func (f File) Get(format string) (string, error) {
+	return GetFormat(f, FileType, format)
+}
+
+func (f File) GetPlain() string {
+	info, _ := json.Marshal(f.info)
+	return string(info)
+}
+
+// GetMasked returns the type and size of the file without its name.
+func (f File) GetMasked() string {
+	masked, _ := json.Marshal(map[string]interface{}{"content_type": f.info.ContentType, "size": f.info.Size})
+	return string(masked)
+}
+
+func (f File) Validate() error {
+	if !blobIdPattern.MatchString(f.blobId) || len(f.key) != fileKeySize {
+		return &ValueError{Msg: "invalid file value"}
+	}
+	return nil
+}
+
+// associatedData binds the encrypted contents of a file to the field it was uploaded to.
+func (f File) associatedData(collectionName, recordId, fieldName string) []byte {
+	return []byte(fmt.Sprintf("%s/%s", fieldAssociatedData(collectionName, recordId, fieldName), f.blobId))
+}
+
+// validateFile checks the options of a file field. Files are set by uploading them, so file
+// fields are always randomized and have no default.
+func (f Field) validateFile() error {
+	if PTypeName(f.Type) != FileType {
+		if f.MaxSize != 0 || len(f.ContentTypes) > 0 {
+			return &ValueError{Msg: fmt.Sprintf("max_size and content_types are only supported by the %s type", FileType)}
+		}
+		return nil
+	}
+	if f.IsIndexed || f.EncryptionMode() != FieldModeRandomized || f.Default != nil || f.Constraints != nil {
+		return &ValueError{Msg: "file fields can't be indexed, hashed, defaulted or constrained"}
+	}
+	if f.MaxSize < 0 {
+		return &ValueError{Msg: "max_size must not be negative"}
+	}
+	for _, contentType := range f.ContentTypes {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || len(params) > 0 || mediaType != contentType || strings.Count(mediaType, "/") != 1 {
+			return &ValueError{Msg: fmt.Sprintf("invalid content type %s, must be a lower case media type like application/pdf or image/*", contentType)}
+		}
+	}
+	return nil
+}
+
+func (f Field) maxFileSize() int64 {
+	if f.MaxSize == 0 {
+		return DefaultMaxFileSize
+	}
+	return f.MaxSize
+}
+
+// acceptsContentType reports whether files of a media type can be uploaded to the field.
+func (f Field) acceptsContentType(mediaType string) bool {
+	if len(f.ContentTypes) == 0 {
+		return true
+	}
+	for _, contentType := range f.ContentTypes {
+		if contentType == mediaType || (strings.HasSuffix(contentType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(contentType, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeFilename keeps the base name of an uploaded file, without control characters.
+func sanitizeFilename(filename string) string {
+	filename = filename[strings.LastIndexAny(filename, `/\`)+1:]
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+	if runes := []rune(filename); len(runes) > 255 {
+		filename = string(runes[:255])
+	}
+	return filename
+}
+
+func filePolicyPath(collectionName, recordID, fieldName string) string {
+	return fmt.Sprintf("%s/%s%s/%s%s/%s", COLLECTIONS_PPATH, collectionName, RECORDS_PPATH, recordID, FILES_PPATH, fieldName)
+}
+
+// fileField returns the collection of a file field, checking that files can be stored.
+func (vault Vault) fileField(ctx context.Context, collectionName string, fieldName string) (*Collection, Field, error) {
+	if vault.Blobs == nil {
+		return nil, Field{}, &NotSupportedError{Msg: "file storage is not configured"}
+	}
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, Field{}, err
+	}
+	field, ok := col.Fields[fieldName]
+	if !ok {
+		return nil, Field{}, &NotFoundError{resourceName: fmt.Sprintf("Field %s not found on collection %s", fieldName, collectionName)}
+	}
+	if PTypeName(field.Type) != FileType {
+		return nil, Field{}, &ValueError{Msg: fmt.Sprintf("Field %s is not a %s field", fieldName, FileType)}
+	}
+	return col, field, nil
+}
+
+// recordFile returns the file of a field of a record, or nil if it has none.
+func (vault Vault) recordFile(priv Privatiser, col *Collection, recordId string, fieldName string, encryptedRecord Record) (*File, error) {
+	value, err := vault.decryptField(priv, col, recordId, fieldName, encryptedRecord[fieldName])
+	if err != nil || value == Null {
+		return nil, err
+	}
+	file, err := parseFile(value)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// deleteBlob removes the contents of a file that's no longer referenced. Failures only leave
+// an unreadable blob behind, so they are logged rather than returned.
+func (vault Vault) deleteBlob(ctx context.Context, file *File) {
+	if file == nil {
+		return
+	}
+	if err := vault.Blobs.Delete(ctx, file.blobId); err != nil {
+		vault.Logger.Warn(fmt.Sprintf("Error deleting blob %s: %s", file.blobId, err))
+	}
+}
+
+// PutFile streams the contents of a file into a file field of a record, replacing the file
+// it had. Contents are size limited and their type sniffed as they are encrypted.
+func (vault Vault) PutFile(
+	ctx context.Context,
+	principal Principal,
+	collectionName string,
+	recordID string,
+	fieldName string,
+	filename string,
+	content io.Reader,
+) (*FileInfo, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, filePolicyPath(collectionName, recordID, fieldName)}); err != nil {
+		return nil, err
+	}
+	col, field, err := vault.fileField(ctx, collectionName, fieldName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vault.Db.GetRecord(ctx, collectionName, recordID); err != nil {
+		return nil, err
+	}
+
+	// The content type is sniffed from the first 512 bytes rather than trusted from the client
+	limited := &sizeLimitReader{src: content, max: field.maxFileSize()}
+	buffered := bufio.NewReaderSize(limited, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !field.acceptsContentType(mediaType) {
+		return nil, &ValueError{Msg: fmt.Sprintf("Field %s doesn't accept files of type %s", fieldName, mediaType)}
+	}
+
+	key := make([]byte, fileKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	file := File{FileInfo{Filename: sanitizeFilename(filename), ContentType: contentType, UploadedAt: time.Now().UTC()}, GenerateId("blob"), key}
+	encrypted, err := newEncryptingReader(buffered, key, file.associatedData(col.Name, recordID, fieldName))
+	if err != nil {
+		return nil, err
+	}
+	if err := vault.Blobs.Put(ctx, file.blobId, encrypted); err != nil {
+		return nil, err
+	}
+	file.info.Size = limited.read
+
+	// The file replaced is the one the record held when it was written
+	var previous *File
+	err = vault.writeRecord(ctx, col, recordID, func(current Record, priv Privatiser) (Record, error) {
+		var err error
+		if previous, err = vault.recordFile(priv, col, recordID, fieldName, current); err != nil {
+			return nil, err
+		}
+		encryptedRecord := make(Record)
+		if err := vault.encryptField(priv, col, recordID, fieldName, file.stored(), encryptedRecord); err != nil {
+			return nil, err
+		}
+		return encryptedRecord, nil
+	})
+	if err != nil {
+		vault.deleteBlob(ctx, &file)
+		return nil, err
+	}
+	vault.deleteBlob(ctx, previous)
+	return &file.info, nil
+}
+
+// GetFile returns a file of a record and a reader of its contents, which the caller closes.
+// Contents are authenticated a chunk at a time as they are read, so reading a tampered file
+// fails with an IntegrityError part way through.
+func (vault Vault) GetFile(
+	ctx context.Context,
+	principal Principal,
+	collectionName string,
+	recordID string,
+	fieldName string,
+) (*FileInfo, io.ReadCloser, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, filePolicyPath(collectionName, recordID, fieldName)}); err != nil {
+		return nil, nil, err
+	}
+	col, _, err := vault.fileField(ctx, collectionName, fieldName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := vault.validateDecrypt(ctx, principal, col, recordID); err != nil {
+		return nil, nil, err
+	}
+	encryptedRecord, err := vault.Db.GetRecord(ctx, collectionName, recordID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := vault.verifyRecord(col, encryptedRecord); err != nil {
+		return nil, nil, err
+	}
+	colPriv, err := vault.collectionPrivatiser(col)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := vault.recordPrivatiser(ctx, col, colPriv, encryptedRecord)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := vault.recordFile(priv, col, recordID, fieldName, encryptedRecord)
+	if err != nil {
+		return nil, nil, err
+	}
+	if file == nil {
+		return nil, nil, &NotFoundError{"file", fieldName}
+	}
+
+	blob, err := vault.Blobs.Get(ctx, file.blobId)
+	if err != nil {
+		return nil, nil, err
+	}
+	contents, err := newDecryptingReader(blob, file.key, file.associatedData(col.Name, recordID, fieldName))
+	if err != nil {
+		blob.Close()
+		return nil, nil, err
+	}
+	return &file.info, struct {
+		io.Reader
+		io.Closer
+	}{contents, blob}, nil
+}
+
+// DeleteFile removes the file of a file field of a record, setting the field to null.
+func (vault Vault) DeleteFile(
+	ctx context.Context,
+	principal Principal,
+	collectionName string,
+	recordID string,
+	fieldName string,
+) error {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, filePolicyPath(collectionName, recordID, fieldName)}); err != nil {
+		return err
+	}
+	col, _, err := vault.fileField(ctx, collectionName, fieldName)
+	if err != nil {
+		return err
+	}
+	var file *File
+	err = vault.writeRecord(ctx, col, recordID, func(current Record, priv Privatiser) (Record, error) {
+		var err error
+		if file, err = vault.recordFile(priv, col, recordID, fieldName, current); err != nil {
+			return nil, err
+		}
+		encryptedRecord := make(Record)
+		if err := vault.encryptField(priv, col, recordID, fieldName, Null, encryptedRecord); err != nil {
+			return nil, err
+		}
+		return encryptedRecord, nil
+	})
+	if err != nil {
+		return err
+	}
+	vault.deleteBlob(ctx, file)
+	return nil
+}
+
+// recordFiles returns the files of a record, so they can be deleted along with it.
+func (vault Vault) recordFiles(ctx context.Context, col *Collection, recordID string) ([]*File, error) {
+	files := []*File{}
+	if vault.Blobs == nil {
+		return files, nil
+	}
+	var encryptedRecord Record
+	var priv Privatiser
+	for fieldName, field := range col.Fields {
+		if PTypeName(field.Type) != FileType {
+			continue
+		}
+		if priv == nil {
+			var err error
+			if encryptedRecord, err = vault.Db.GetRecord(ctx, col.Name, recordID); err != nil {
+				return nil, err
+			}
+			colPriv, err := vault.collectionPrivatiser(col)
+			if err != nil {
+				return nil, err
+			}
+			if priv, err = vault.recordPrivatiser(ctx, col, colPriv, encryptedRecord); err != nil {
+				return nil, err
+			}
+		}
+		file, err := vault.recordFile(priv, col, recordID, fieldName, encryptedRecord)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// subjectFiles returns the files of a record and of the records referencing it in child
+// collections, which are deleted along with it by cascade.
+func (vault Vault) subjectFiles(ctx context.Context, col *Collection, recordID string) ([]*File, error) {
+	if vault.Blobs == nil {
+		return []*File{}, nil
+	}
+	collectionNames, err := vault.Db.GetCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	children := map[string][]*Collection{}
+	for _, collectionName := range collectionNames {
+		child, err := vault.Db.GetCollection(ctx, collectionName)
+		if err != nil {
+			return nil, err
+		}
+		if child.Parent != "" {
+			children[child.Parent] = append(children[child.Parent], child)
+		}
+	}
+
+	var collect func(col *Collection, recordID string) ([]*File, error)
+	collect = func(col *Collection, recordID string) ([]*File, error) {
+		files, err := vault.recordFiles(ctx, col, recordID)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children[col.Name] {
+			childIds, err := vault.Db.SearchRecords(ctx, child.Name, map[string]string{subject_id_field: recordID})
+			if err != nil {
+				return nil, err
+			}
+			for _, childId := range childIds {
+				childFiles, err := collect(child, childId)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, childFiles...)
+			}
+		}
+		return files, nil
+	}
+	return collect(col, recordID)
+}
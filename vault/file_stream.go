@@ -0,0 +1,171 @@
+package vault
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Files are encrypted in chunks so they are never held in memory whole. Every chunk is sealed
+// with AES-GCM under the file's own key, with its position and whether it's the last chunk
+// in the nonce, so chunks can't be reordered, dropped or truncated without failing to open.
+const (
+	fileFormatVersion = 1
+	fileChunkSize     = 64 * 1024
+	fileKeySize       = 32
+)
+
+func newFileAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != fileKeySize {
+		return nil, &ValueError{Msg: "invalid file key"}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the counter of a chunk followed by 1 for the last chunk. Keys are never
+// reused across files, so the counter is enough to keep nonces unique.
+func chunkNonce(aead cipher.AEAD, counter uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[aead.NonceSize()-9:], counter)
+	if last {
+		nonce[aead.NonceSize()-1] = 1
+	}
+	return nonce
+}
+
+// readChunk reads up to a chunk of src and reports whether nothing follows it.
+func readChunk(src *bufio.Reader, chunk []byte) (int, bool, error) {
+	n, err := io.ReadFull(src, chunk)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return n, true, nil
+	case err != nil:
+		return n, false, err
+	}
+	if _, err := src.Peek(1); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+// encryptingReader reads the encrypted form of a file from its contents.
+type encryptingReader struct {
+	src            *bufio.Reader
+	aead           cipher.AEAD
+	associatedData []byte
+	chunk          []byte
+	pending        []byte
+	counter        uint64
+	done           bool
+}
+
+func newEncryptingReader(src io.Reader, key []byte, associatedData []byte) (io.Reader, error) {
+	aead, err := newFileAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingReader{
+		src:            bufio.NewReaderSize(src, fileChunkSize),
+		aead:           aead,
+		associatedData: associatedData,
+		chunk:          make([]byte, fileChunkSize),
+		pending:        []byte{fileFormatVersion},
+	}, nil
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, last, err := readChunk(r.src, r.chunk)
+		if err != nil {
+			return 0, err
+		}
+		r.pending = r.aead.Seal(r.pending[:0], chunkNonce(r.aead, r.counter, last), r.chunk[:n], r.associatedData)
+		r.counter++
+		r.done = last
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// decryptingReader reads the contents of a file from its encrypted form. Chunks are only
+// returned once authenticated, a tampered or truncated file fails with an IntegrityError.
+type decryptingReader struct {
+	src            *bufio.Reader
+	aead           cipher.AEAD
+	associatedData []byte
+	chunk          []byte
+	pending        []byte
+	counter        uint64
+	started        bool
+	done           bool
+}
+
+func newDecryptingReader(src io.Reader, key []byte, associatedData []byte) (io.Reader, error) {
+	aead, err := newFileAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		src:            bufio.NewReaderSize(src, fileChunkSize+aead.Overhead()),
+		aead:           aead,
+		associatedData: associatedData,
+		chunk:          make([]byte, fileChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if !r.started {
+		version, err := r.src.ReadByte()
+		if err != nil || version != fileFormatVersion {
+			return 0, &IntegrityError{Msg: "file is not a supported encrypted file"}
+		}
+		r.started = true
+	}
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, last, err := readChunk(r.src, r.chunk)
+		if err != nil {
+			return 0, err
+		}
+		r.pending, err = r.aead.Open(r.pending[:0], chunkNonce(r.aead, r.counter, last), r.chunk[:n], r.associatedData)
+		if err != nil {
+			return 0, &IntegrityError{Msg: fmt.Sprintf("file failed authentication at chunk %d", r.counter)}
+		}
+		r.counter++
+		r.done = last
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// sizeLimitReader fails once more than max bytes are read from src.
+type sizeLimitReader struct {
+	src  io.Reader
+	max  int64
+	read int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.read += int64(n)
+	if r.read > r.max {
+		return 0, &ValueError{Msg: fmt.Sprintf("file is larger than the limit of %d bytes", r.max)}
+	}
+	return n, err
+}
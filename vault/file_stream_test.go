@@ -0,0 +1,78 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptFile(t *testing.T, contents []byte, key []byte, associatedData []byte) []byte {
+	encrypting, err := newEncryptingReader(bytes.NewReader(contents), key, associatedData)
+	assert.NoError(t, err)
+	encrypted, err := io.ReadAll(encrypting)
+	assert.NoError(t, err)
+	return encrypted
+}
+
+func decryptFile(encrypted []byte, key []byte, associatedData []byte) ([]byte, error) {
+	decrypting, err := newDecryptingReader(bytes.NewReader(encrypted), key, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decrypting)
+}
+
+func TestFileEncryption(t *testing.T) {
+	key := make([]byte, fileKeySize)
+	_, _ = rand.Read(key)
+	associatedData := []byte("customers/rec_1/passport/blob_1")
+
+	for _, size := range []int{0, 1, fileChunkSize - 1, fileChunkSize, fileChunkSize + 1, 3*fileChunkSize + 100} {
+		contents := make([]byte, size)
+		_, _ = rand.Read(contents)
+		encrypted := encryptFile(t, contents, key, associatedData)
+		decrypted, err := decryptFile(encrypted, key, associatedData)
+		assert.NoError(t, err, size)
+		assert.True(t, bytes.Equal(contents, decrypted), size)
+	}
+}
+
+func TestTamperedFiles(t *testing.T) {
+	key := make([]byte, fileKeySize)
+	_, _ = rand.Read(key)
+	associatedData := []byte("customers/rec_1/passport/blob_1")
+	contents := make([]byte, 2*fileChunkSize+10)
+	_, _ = rand.Read(contents)
+	encrypted := encryptFile(t, contents, key, associatedData)
+	chunkLength := fileChunkSize + 16
+
+	for name, tampered := range map[string][]byte{
+		"modified":             append(append([]byte{}, encrypted[:100]...), append([]byte{encrypted[100] ^ 1}, encrypted[101:]...)...),
+		"truncated at a chunk": encrypted[:1+2*chunkLength],
+		"truncated mid chunk":  encrypted[:len(encrypted)-5],
+		"missing its first":    append([]byte{fileFormatVersion}, encrypted[1+chunkLength:]...),
+		"unknown format":       append([]byte{2}, encrypted[1:]...),
+		"emptied":              {},
+	} {
+		_, err := decryptFile(tampered, key, associatedData)
+		var integrityErr *IntegrityError
+		assert.ErrorAs(t, err, &integrityErr, name)
+	}
+
+	_, err := decryptFile(encrypted, key, []byte("customers/rec_2/passport/blob_1"))
+	var integrityErr *IntegrityError
+	assert.ErrorAs(t, err, &integrityErr)
+}
+
+func TestSizeLimitReader(t *testing.T) {
+	read, err := io.ReadAll(&sizeLimitReader{src: bytes.NewReader(make([]byte, 10)), max: 10})
+	assert.NoError(t, err)
+	assert.Len(t, read, 10)
+
+	_, err = io.ReadAll(&sizeLimitReader{src: bytes.NewReader(make([]byte, 11)), max: 10})
+	var valueErr *ValueError
+	assert.ErrorAs(t, err, &valueErr)
+}
@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilePType(t *testing.T) {
+	file := File{FileInfo{"passport.pdf", "application/pdf", 1024, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, GenerateId("blob"), make([]byte, fileKeySize)}
+
+	value, err := GetPType(FileType, file.stored())
+	assert.NoError(t, err)
+	assert.Equal(t, file, value)
+
+	plain, err := value.Get(PLAIN_FORMAT)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"filename": "passport.pdf", "content_type": "application/pdf", "size": 1024, "uploaded_at": "2024-01-01T00:00:00Z"}`, plain)
+	masked, err := value.Get(MASKED_FORMAT)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"content_type": "application/pdf", "size": 1024}`, masked)
+
+	_, err = GetPType(FileType, `{"filename": "passport.pdf"}`)
+	assert.Error(t, err)
+	_, err = GetPType(FileType, "passport.pdf")
+	assert.Error(t, err)
+}
+
+func TestFileFieldOptions(t *testing.T) {
+	empty := ""
+	for _, field := range []Field{
+		{Type: "string", MaxSize: 1024},
+		{Type: "string", ContentTypes: []string{"application/pdf"}},
+		{Type: "file", IsIndexed: true},
+		{Type: "file", Mode: FieldModeHashed},
+		{Type: "file", Default: &empty},
+		{Type: "file", Constraints: &FieldConstraints{}},
+		{Type: "file", MaxSize: -1},
+		{Type: "file", ContentTypes: []string{"pdf"}},
+		{Type: "file", ContentTypes: []string{"Application/PDF"}},
+		{Type: "file", ContentTypes: []string{"text/plain; charset=utf-8"}},
+	} {
+		var ve *ValueError
+		assert.ErrorAs(t, field.validateFile(), &ve, field)
+	}
+	assert.NoError(t, Field{Type: "file", MaxSize: 1024, ContentTypes: []string{"application/pdf", "image/*"}}.validateFile())
+}
+
+func TestFileContentTypes(t *testing.T) {
+	field := Field{Type: "file", ContentTypes: []string{"application/pdf", "image/*"}}
+	assert.True(t, field.acceptsContentType("application/pdf"))
+	assert.True(t, field.acceptsContentType("image/png"))
+	assert.False(t, field.acceptsContentType("text/html"))
+	assert.False(t, field.acceptsContentType("imagery/png"))
+	assert.True(t, Field{Type: "file"}.acceptsContentType("text/html"))
+
+	assert.Equal(t, DefaultMaxFileSize, Field{Type: "file"}.maxFileSize())
+	assert.Equal(t, int64(1024), Field{Type: "file", MaxSize: 1024}.maxFileSize())
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "passport.pdf", sanitizeFilename("../../passport.pdf"))
+	assert.Equal(t, "consent.pdf", sanitizeFilename(`C:\Users\john\consent.pdf`))
+	assert.Equal(t, "consent.pdf", sanitizeFilename("con\r\nsent.pdf"))
+	assert.Len(t, []rune(sanitizeFilename(strings.Repeat("é", 300))), 255)
+}
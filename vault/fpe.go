@@ -0,0 +1,217 @@
+package vault
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/phonenumbers"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	FPE_FORMAT       = "fpe"
+	FPE_LAST4_FORMAT = "fpe_last4"
+)
+
+// Limits on format-preserving encryption. Values to decrypt come from requests, so they are
+// bounded in length, and cycle walking gives up instead of looping on a value that no valid
+// value encrypts to. Valid values need a handful of rounds, so the cap is never hit for them.
+const (
+	maxFPEValueLength = 64
+	maxFPECycles      = 1000
+)
+
+func isFPEFormat(format string) bool {
+	return format == FPE_FORMAT || format == FPE_LAST4_FORMAT
+}
+
+// fpeScheme describes which part of a value of a ptype is format-preserving encrypted. The
+// digits returned by split are encrypted with FF1 and joined back with the prefix and suffix;
+// encryption is repeated (cycle walking) until the joined value is valid, so that valid values
+// always map to valid values.
+type fpeScheme struct {
+	split func(value string) (prefix string, digits string, err error)
+	valid func(value string) bool
+}
+
+var fpeSchemes = map[PTypeName]fpeScheme{
+	CreditCardNumberType: {
+		split: func(value string) (string, string, error) { return "", value, nil },
+		valid: func(value string) bool { return CreditCardNumber{value}.Validate() == nil },
+	},
+	PhoneNumberType: {
+		split: splitPhoneNumber,
+		valid: func(value string) bool { return true },
+	},
+	IntegerType: {
+		split: func(value string) (string, string, error) {
+			if strings.HasPrefix(value, "-") {
+				return "-", value[1:], nil
+			}
+			return "", value, nil
+		},
+		// Integers don't have leading zeros
+		valid: func(value string) bool {
+			digits := strings.TrimPrefix(value, "-")
+			return len(digits) == 1 || len(digits) > 1 && digits[0] != '0'
+		},
+	},
+}
+
+// splitPhoneNumber splits an E.164 phone number into its country code, which is kept as is,
+// and its national number.
+func splitPhoneNumber(value string) (string, string, error) {
+	digits := strings.TrimPrefix(value, "+")
+	// Country codes are a prefix code, so the first known prefix is the country code
+	for i := 1; i <= 3 && i < len(digits); i++ {
+		countryCode, err := strconv.Atoi(digits[:i])
+		if err != nil {
+			break
+		}
+		if phonenumbers.GetRegionCodeForCountryCode(countryCode) != phonenumbers.UNKNOWN_REGION {
+			return "+" + digits[:i], digits[i:], nil
+		}
+	}
+	return "", "", &ValueError{Msg: fmt.Sprintf("invalid phone number %s", value)}
+}
+
+// fpeCipher returns the FF1 cipher of the values of a subject, keyed by the subject key so that
+// shredding the subject makes its encrypted values irreversible too. Subjects created before
+// subject keys were introduced have none, their values are keyed by the collection data key.
+func (vault Vault) fpeCipher(col *Collection, subjectKey []byte) (*FF1, error) {
+	secret := subjectKey
+	if secret == nil {
+		dataKey, err := vault.collectionDataKey(col)
+		if err != nil {
+			return nil, err
+		}
+		if dataKey == nil {
+			return nil, &NotSupportedError{Msg: fmt.Sprintf("collection %s has no data key, it needs to be rekeyed before using format-preserving encryption", col.Name)}
+		}
+		secret = dataKey
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("fpe")), key); err != nil {
+		return nil, err
+	}
+	return NewFF1(key, 10)
+}
+
+func (vault Vault) fpe(col *Collection, subjectKey []byte, fieldName string, format string, value string, encrypt bool) (string, error) {
+	scheme, ok := fpeSchemes[PTypeName(col.Fields[fieldName].Type)]
+	if !ok {
+		return "", &NotSupportedError{Msg: fmt.Sprintf("Format %s is not supported for type %s", format, col.Fields[fieldName].Type)}
+	}
+	ff1, err := vault.fpeCipher(col, subjectKey)
+	if err != nil {
+		return "", err
+	}
+	tweak := []byte(col.Name + "/" + fieldName)
+
+	if len(value) > maxFPEValueLength {
+		return "", &ValueError{Msg: fmt.Sprintf("value is longer than %d characters", maxFPEValueLength)}
+	}
+	if !scheme.valid(value) {
+		return "", &ValueError{Msg: fmt.Sprintf("invalid value for format %s", format)}
+	}
+	prefix, digits, err := scheme.split(value)
+	if err != nil {
+		return "", err
+	}
+	suffix := ""
+	if format == FPE_LAST4_FORMAT {
+		if len(digits) <= 4 {
+			return "", &ValueError{Msg: fmt.Sprintf("value is too short for format %s", format)}
+		}
+		digits, suffix = digits[:len(digits)-4], digits[len(digits)-4:]
+	}
+
+	for i := 0; i < maxFPECycles; i++ {
+		if encrypt {
+			digits, err = ff1.Encrypt(tweak, digits)
+		} else {
+			digits, err = ff1.Decrypt(tweak, digits)
+		}
+		if err != nil {
+			return "", err
+		}
+		if scheme.valid(prefix + digits + suffix) {
+			return prefix + digits + suffix, nil
+		}
+	}
+	return "", &ValueError{Msg: fmt.Sprintf("invalid value for format %s", format)}
+}
+
+// formatField returns a value of a record in the requested format. Format-preserving
+// encryption needs the key of the record's subject and generalizations are declared on the
+// field, other formats are handled by the ptype.
+func (vault Vault) formatField(col *Collection, recordId string, subjectKey []byte, fieldName string, value PType, format string) (string, error) {
+	if isFPEFormat(format) {
+		return vault.fpe(col, subjectKey, fieldName, format, value.GetPlain(), true)
+	}
+	if generalization, ok := col.Fields[fieldName].Generalizations[format]; ok {
+		noise, err := vault.generalizationNoise(col, recordId, fieldName, format, generalization.Noise)
+		if err != nil {
+			return "", err
+		}
+		return generalization.generalize(value, time.Now(), noise)
+	}
+	return value.Get(format)
+}
+
+// DecryptFPE reverses format-preserving encryption of a value of a field of a record.
+func (vault Vault) DecryptFPE(
+	ctx context.Context,
+	principal Principal,
+	collectionName string,
+	recordId string,
+	fieldName string,
+	format string,
+	value string,
+) (string, error) {
+	_request := Request{principal, PolicyActionRead, fmt.Sprintf("%s/%s%s/%s", COLLECTIONS_PPATH, collectionName, FPE_PPATH, fieldName)}
+	if err := vault.ValidateAction(ctx, _request); err != nil {
+		return "", err
+	}
+	if !isFPEFormat(format) {
+		return "", &ValueError{Msg: fmt.Sprintf("%s is not a format-preserving encryption format", format)}
+	}
+
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := col.Fields[fieldName]; !ok {
+		return "", &NotFoundError{resourceName: fmt.Sprintf("Field %s not found on collection %s", fieldName, collectionName)}
+	}
+	if col.WriteOnly {
+		// Like their records, the values of write-only collections take the decrypt action
+		_request.Action = PolicyActionDecrypt
+		if err := vault.ValidateAction(ctx, _request); err != nil {
+			return "", err
+		}
+	}
+
+	record, err := vault.Db.GetRecord(ctx, collectionName, recordId)
+	if err != nil {
+		return "", err
+	}
+	if err := vault.verifyRecord(col, record); err != nil {
+		return "", err
+	}
+	colPriv, err := vault.collectionPrivatiser(col)
+	if err != nil {
+		return "", err
+	}
+	subjectKey, err := vault.subjectKey(ctx, col, colPriv, record)
+	if err != nil {
+		return "", err
+	}
+	return vault.fpe(col, subjectKey, fieldName, format, value, false)
+}
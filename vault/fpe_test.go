@@ -0,0 +1,151 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatPreservingEncryption(t *testing.T) {
+	priv, _ := NewAESGCMPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
+	vault := Vault{Priv: priv}
+	dataKey, _ := vault.generateDataKey("payments")
+	col := &Collection{Name: "payments", DataKey: dataKey, Fields: map[string]Field{
+		"card":   {Type: "cc_number"},
+		"phone":  {Type: "phone_number"},
+		"amount": {Type: "integer"},
+		"name":   {Type: "name"},
+	}}
+
+	roundTrip := func(t *testing.T, field string, format string, value string) string {
+		pType, err := GetPType(PTypeName(col.Fields[field].Type), value)
+		assert.NoError(t, err)
+		encrypted, err := vault.formatField(col, "rec_1", nil, field, pType, format)
+		assert.NoError(t, err)
+		assert.NotEqual(t, value, encrypted)
+		assert.Len(t, encrypted, len(value))
+
+		decrypted, err := vault.fpe(col, nil, field, format, encrypted, false)
+		assert.NoError(t, err)
+		assert.Equal(t, value, decrypted)
+		return encrypted
+	}
+
+	t.Run("card numbers stay valid card numbers", func(t *testing.T) {
+		encrypted := roundTrip(t, "card", FPE_FORMAT, "4111111111111111")
+		assert.NoError(t, CreditCardNumber{encrypted}.Validate())
+	})
+
+	t.Run("can preserve the last four digits", func(t *testing.T) {
+		encrypted := roundTrip(t, "card", FPE_LAST4_FORMAT, "4111111111111111")
+		assert.True(t, strings.HasSuffix(encrypted, "1111"))
+		assert.NoError(t, CreditCardNumber{encrypted}.Validate())
+	})
+
+	t.Run("phone numbers keep their country code", func(t *testing.T) {
+		encrypted := roundTrip(t, "phone", FPE_FORMAT, "+447700900123")
+		assert.True(t, strings.HasPrefix(encrypted, "+44"))
+	})
+
+	t.Run("integers keep their sign and have no leading zero", func(t *testing.T) {
+		encrypted := roundTrip(t, "amount", FPE_FORMAT, "-12345678")
+		assert.True(t, strings.HasPrefix(encrypted, "-"))
+		assert.NotEqual(t, byte('0'), encrypted[1])
+	})
+
+	t.Run("is deterministic per field", func(t *testing.T) {
+		first, _ := vault.fpe(col, nil, "card", FPE_FORMAT, "4111111111111111", true)
+		second, _ := vault.fpe(col, nil, "card", FPE_FORMAT, "4111111111111111", true)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("is keyed by the subject key", func(t *testing.T) {
+		johnKey, janeKey := make([]byte, dataKeySize), make([]byte, dataKeySize)
+		_, _ = rand.Read(johnKey)
+		_, _ = rand.Read(janeKey)
+		john, err := vault.fpe(col, johnKey, "card", FPE_FORMAT, "4111111111111111", true)
+		assert.NoError(t, err)
+		jane, err := vault.fpe(col, janeKey, "card", FPE_FORMAT, "4111111111111111", true)
+		assert.NoError(t, err)
+		assert.NotEqual(t, john, jane)
+
+		decrypted, err := vault.fpe(col, johnKey, "card", FPE_FORMAT, john, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "4111111111111111", decrypted)
+	})
+
+	t.Run("is not supported for other types", func(t *testing.T) {
+		pType, _ := GetPType(NameType, "John Crawford")
+		_, err := vault.formatField(col, "rec_1", nil, "name", pType, FPE_FORMAT)
+		var ns *NotSupportedError
+		assert.ErrorAs(t, err, &ns)
+	})
+
+	t.Run("rejects values that are too short", func(t *testing.T) {
+		pType, _ := GetPType(IntegerType, "42")
+		_, err := vault.formatField(col, "rec_1", nil, "amount", pType, FPE_FORMAT)
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("rejects invalid values to decrypt", func(t *testing.T) {
+		// No card number encrypts to these, so cycle walking would never end on them
+		for _, value := range []string{"123456", "4111111111111112", "", strings.Repeat("4", 100)} {
+			_, err := vault.fpe(col, nil, "card", FPE_FORMAT, value, false)
+			var ve *ValueError
+			assert.ErrorAs(t, err, &ve, value)
+		}
+		_, err := vault.fpe(col, nil, "amount", FPE_FORMAT, "-", false)
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+}
+
+func TestDecryptFPE(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	readerPrincipal := Principal{Username: "reader", Policies: []string{"read-all-customers"}}
+
+	col := Collection{Name: "payments", Fields: map[string]Field{
+		"card": {Type: "cc_number", IsIndexed: false},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+	recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"card": "4111111111111111"})
+	assert.NoError(t, err)
+
+	record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"card": FPE_FORMAT})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "4111111111111111", record["card"])
+
+	t.Run("can decrypt with access to the fpe resource", func(t *testing.T) {
+		decrypted, err := vault.DecryptFPE(ctx, rootPrincipal, col.Name, recordId, "card", FPE_FORMAT, record["card"])
+		assert.NoError(t, err)
+		assert.Equal(t, "4111111111111111", decrypted)
+	})
+
+	t.Run("cannot decrypt without access to the fpe resource", func(t *testing.T) {
+		_, err := vault.DecryptFPE(ctx, readerPrincipal, col.Name, recordId, "card", FPE_FORMAT, record["card"])
+		var fe *ForbiddenError
+		assert.ErrorAs(t, err, &fe)
+	})
+
+	t.Run("cannot decrypt once the subject is shredded", func(t *testing.T) {
+		stored, _ := db.GetRecord(ctx, col.Name, recordId)
+		_, err := vault.ShredSubject(ctx, rootPrincipal, col.Name, recordId)
+		assert.NoError(t, err)
+
+		_, err = vault.DecryptFPE(ctx, rootPrincipal, col.Name, recordId, "card", FPE_FORMAT, record["card"])
+		var nf *NotFoundError
+		assert.ErrorAs(t, err, &nf)
+
+		// Nor with a copy of the record, e.g. from a backup
+		assert.NoError(t, db.CreateRecord(ctx, col.Name, stored))
+		_, err = vault.DecryptFPE(ctx, rootPrincipal, col.Name, recordId, "card", FPE_FORMAT, record["card"])
+		var se *ShreddedError
+		assert.ErrorAs(t, err, &se)
+	})
+}
@@ -0,0 +1,123 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// DateTruncation is the precision a generalized date is reduced to.
+type DateTruncation string
+
+const (
+	DateTruncationYear   DateTruncation = "year"   // e.g. 1990
+	DateTruncationDecade DateTruncation = "decade" // e.g. 1990s
+)
+
+// Generalization is a format declared on a date or integer field that reduces values to a
+// range, so they stay useful for analytics without identifying anyone. It's requested like
+// any other format by its name, e.g. dob.ageband. Exactly one of Width, Bands and Truncate is
+// set; Width and Bands apply to the age in years of dates.
+type Generalization struct {
+	Width    int            `json:"width,omitempty"`    // Buckets of this width, e.g. 10 gives 30-39
+	Bands    []int          `json:"bands,omitempty"`    // Ascending boundaries, e.g. [18, 65] gives <18, 18-64 and 65+
+	Truncate DateTruncation `json:"truncate,omitempty"` // Dates only
+	Noise    int            `json:"noise,omitempty"`    // Values are moved by up to this much first, in days for dates, by the same amount on every read of a record
+}
+
+func (g Generalization) validate(pType PTypeName) error {
+	if pType != DateType && pType != IntegerType {
+		return &ValueError{Msg: fmt.Sprintf("generalizations are only supported by the %s and %s types", DateType, IntegerType)}
+	}
+
+	options := 0
+	if g.Width != 0 {
+		options++
+	}
+	if len(g.Bands) > 0 {
+		options++
+	}
+	if g.Truncate != "" {
+		options++
+	}
+	if options != 1 {
+		return &ValueError{Msg: "generalizations require exactly one of width, bands and truncate"}
+	}
+
+	switch {
+	case g.Width < 0:
+		return &ValueError{Msg: "generalization width must be positive"}
+	case !strictlyAscending(g.Bands):
+		return &ValueError{Msg: "generalization bands must be strictly ascending"}
+	case g.Truncate != "" && pType != DateType:
+		return &ValueError{Msg: fmt.Sprintf("truncate is only supported by the %s type", DateType)}
+	case g.Truncate != "" && g.Truncate != DateTruncationYear && g.Truncate != DateTruncationDecade:
+		return &ValueError{Msg: fmt.Sprintf("generalizations truncate dates to %s or %s", DateTruncationYear, DateTruncationDecade)}
+	case g.Noise < 0:
+		return &ValueError{Msg: "generalization noise must be positive"}
+	}
+	return nil
+}
+
+// generalize returns a date or integer value, moved by noise, reduced to its range.
+func (g Generalization) generalize(value PType, now time.Time, noise int) (string, error) {
+	switch v := value.(type) {
+	case Integer:
+		return g.bucket(v.val + noise), nil
+	case Date:
+		date := v.val.AddDate(0, 0, noise)
+		switch g.Truncate {
+		case DateTruncationYear:
+			return date.Format("2006"), nil
+		case DateTruncationDecade:
+			return fmt.Sprintf("%ds", date.Year()-date.Year()%10), nil
+		}
+		return g.bucket(age(date, now)), nil
+	}
+	return "", &NotSupportedError{Msg: fmt.Sprintf("generalizations are only supported by the %s and %s types", DateType, IntegerType)}
+}
+
+func (g Generalization) bucket(n int) string {
+	if g.Width > 0 {
+		low := n - n%g.Width
+		if n < 0 && n%g.Width != 0 {
+			low -= g.Width
+		}
+		return fmt.Sprintf("%d-%d", low, low+g.Width-1)
+	}
+
+	if n < g.Bands[0] {
+		return fmt.Sprintf("<%d", g.Bands[0])
+	}
+	for i := 1; i < len(g.Bands); i++ {
+		if n < g.Bands[i] {
+			return fmt.Sprintf("%d-%d", g.Bands[i-1], g.Bands[i]-1)
+		}
+	}
+	return fmt.Sprintf("%d+", g.Bands[len(g.Bands)-1])
+}
+
+func strictlyAscending(values []int) bool {
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// generalizationNoise returns the noise a value of a record is moved by before it's
+// generalized: a number between -max and max derived from a MAC of the record, field and
+// format. Noise drawn afresh on every read would average out over repeated reads.
+func (vault Vault) generalizationNoise(col *Collection, recordId string, fieldName string, format string, max int) (int, error) {
+	if max == 0 {
+		return 0, nil
+	}
+	mac, err := vault.Signer.Sign(fmt.Sprintf("noise:%s/%s/%s/%s", col.Name, recordId, fieldName, format))
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256([]byte(mac))
+	return int(binary.BigEndian.Uint64(sum[:8])%uint64(2*max+1)) - max, nil
+}
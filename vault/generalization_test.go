@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneralization(t *testing.T) {
+	now := time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC)
+	dob, _ := GetPType(DateType, "1990-07-15")
+	income, _ := GetPType(IntegerType, "43500")
+
+	t.Run("can reduce values to buckets and bands", func(t *testing.T) {
+		for _, tc := range []struct {
+			generalization Generalization
+			value          PType
+			expected       string
+		}{
+			{Generalization{Width: 10000}, income, "40000-49999"},
+			{Generalization{Width: 10}, Integer{-5}, "-10--1"},
+			{Generalization{Width: 10}, dob, "30-39"},
+			{Generalization{Bands: []int{18, 25, 35, 50, 65}}, dob, "25-34"},
+			{Generalization{Bands: []int{18, 25, 35, 50, 65}}, Integer{12}, "<18"},
+			{Generalization{Bands: []int{18, 25, 35, 50, 65}}, Integer{70}, "65+"},
+			{Generalization{Truncate: DateTruncationYear}, dob, "1990"},
+			{Generalization{Truncate: DateTruncationDecade}, dob, "1990s"},
+		} {
+			generalized, err := tc.generalization.generalize(tc.value, now, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, generalized)
+		}
+	})
+
+	t.Run("noise is bounded and the same on every read of a record", func(t *testing.T) {
+		signer, _ := NewHMACSigner([]byte("key"))
+		vault := Vault{Signer: signer}
+		col := &Collection{Name: "customers"}
+		values := map[int]bool{}
+		for i := 0; i < 100; i++ {
+			recordId := "rec_" + strconv.Itoa(i)
+			noise, err := vault.generalizationNoise(col, recordId, "income", "band", 100)
+			assert.NoError(t, err)
+			assert.InDelta(t, 0, noise, 100)
+			values[noise] = true
+
+			again, _ := vault.generalizationNoise(col, recordId, "income", "band", 100)
+			assert.Equal(t, noise, again)
+		}
+		assert.Greater(t, len(values), 1)
+
+		generalized, err := Generalization{Width: 1, Noise: 100}.generalize(income, now, -7)
+		assert.NoError(t, err)
+		assert.Equal(t, "43493-43493", generalized)
+	})
+
+	t.Run("invalid generalizations are rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			generalization Generalization
+			pType          PTypeName
+		}{
+			{Generalization{Width: 10}, StringType},
+			{Generalization{}, IntegerType},
+			{Generalization{Width: 10, Bands: []int{18}}, IntegerType},
+			{Generalization{Width: -10}, IntegerType},
+			{Generalization{Bands: []int{18, 18}}, IntegerType},
+			{Generalization{Truncate: DateTruncationYear}, IntegerType},
+			{Generalization{Truncate: "century"}, DateType},
+			{Generalization{Width: 10, Noise: -1}, IntegerType},
+		} {
+			var ve *ValueError
+			assert.ErrorAs(t, tc.generalization.validate(tc.pType), &ve)
+		}
+	})
+}
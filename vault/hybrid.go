@@ -0,0 +1,274 @@
+package vault
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Ciphertexts of write-only collections are formatted as
+// "x1:<base64 ephemeral public key || nonce || sealed>".
+const hybridCiphertextPrefix = "x1:"
+
+// HybridPrivatiser encrypts values to the X25519 public key of a write-only collection. Each
+// value is sealed with a key agreed between a fresh ephemeral key pair and the collection key,
+// so encrypting never needs the private key. Values are encrypted with inner first, which keeps
+// them bound to their subject key.
+type HybridPrivatiser struct {
+	publicKey  *ecdh.PublicKey
+	privateKey func() ([]byte, error)
+	inner      Privatiser
+}
+
+// NewHybridPrivatiser creates a privatiser for a public key. privateKey is only called to
+// decrypt and may be nil for a privatiser that can only encrypt.
+func NewHybridPrivatiser(publicKey []byte, privateKey func() ([]byte, error), inner Privatiser) (*HybridPrivatiser, error) {
+	key, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridPrivatiser{key, privateKey, inner}, nil
+}
+
+func (p *HybridPrivatiser) Encrypt(text string) (string, error) {
+	return p.EncryptWithAD(text, nil)
+}
+
+func (p *HybridPrivatiser) Decrypt(encodedText string) (string, error) {
+	return p.DecryptWithAD(encodedText, nil)
+}
+
+func (p *HybridPrivatiser) EncryptWithAD(text string, associatedData []byte) (string, error) {
+	innerText, err := encryptWithAD(p.inner, text, associatedData)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeralKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	sharedSecret, err := ephemeralKey.ECDH(p.publicKey)
+	if err != nil {
+		return "", err
+	}
+	ephemeralPublicKey := ephemeralKey.PublicKey().Bytes()
+	sealed, err := p.seal(sharedSecret, ephemeralPublicKey, []byte(innerText), associatedData)
+	if err != nil {
+		return "", err
+	}
+
+	return hybridCiphertextPrefix + base64.StdEncoding.EncodeToString(append(ephemeralPublicKey, sealed...)), nil
+}
+
+func (p *HybridPrivatiser) DecryptWithAD(encodedText string, associatedData []byte) (string, error) {
+	if !p.isBound(encodedText) {
+		return decryptWithAD(p.inner, encodedText, associatedData)
+	}
+	if p.privateKey == nil {
+		return "", &NotSupportedError{Msg: "the private key of the collection is not available"}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encodedText[len(hybridCiphertextPrefix):])
+	if err != nil {
+		return "", err
+	}
+	keySize := len(p.publicKey.Bytes())
+	if len(data) < keySize {
+		return "", errors.New("ciphertext too short")
+	}
+	ephemeralPublicKey, sealed := data[:keySize], data[keySize:]
+
+	rawPrivateKey, err := p.privateKey()
+	if err != nil {
+		return "", err
+	}
+	privateKey, err := ecdh.X25519().NewPrivateKey(rawPrivateKey)
+	if err != nil {
+		return "", err
+	}
+	ephemeralKey, err := ecdh.X25519().NewPublicKey(ephemeralPublicKey)
+	if err != nil {
+		return "", &IntegrityError{Msg: "ciphertext failed authentication"}
+	}
+	sharedSecret, err := privateKey.ECDH(ephemeralKey)
+	if err != nil {
+		return "", &IntegrityError{Msg: "ciphertext failed authentication"}
+	}
+	innerText, err := p.open(sharedSecret, ephemeralPublicKey, sealed, associatedData)
+	if err != nil {
+		return "", err
+	}
+	return decryptWithAD(p.inner, string(innerText), associatedData)
+}
+
+// isBound reports whether a ciphertext was encrypted to the collection public key.
+func (p *HybridPrivatiser) isBound(encodedText string) bool {
+	return strings.HasPrefix(encodedText, hybridCiphertextPrefix)
+}
+
+// aead derives the key sealing a value from the shared secret and both public keys.
+func (p *HybridPrivatiser) aead(sharedSecret []byte, ephemeralPublicKey []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), p.publicKey.Bytes()...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, salt, []byte("hybrid")), key); err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func (p *HybridPrivatiser) seal(sharedSecret []byte, ephemeralPublicKey []byte, plainText []byte, associatedData []byte) ([]byte, error) {
+	aead, err := p.aead(sharedSecret, ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plainText, associatedData), nil
+}
+
+func (p *HybridPrivatiser) open(sharedSecret []byte, ephemeralPublicKey []byte, sealed []byte, associatedData []byte) ([]byte, error) {
+	aead, err := p.aead(sharedSecret, ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	plainText, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData)
+	if err != nil {
+		return nil, &IntegrityError{Msg: "ciphertext failed authentication"}
+	}
+	return plainText, nil
+}
+
+// The private keys of write-only collections are wrapped by their own keyring rather than by the
+// data key of their collection, which is unwrapped to write every record.
+const privateKeyringName = "private keys"
+
+// privateKeyring loads the keyring wrapping the private keys of write-only collections. It's only
+// loaded to create a collection or decrypt its values, never to write records.
+func (vault Vault) privateKeyring(ctx context.Context) (*Keyring, error) {
+	return vault.privatiserKeyring(ctx, privateKeyringName, true)
+}
+
+// generateKeyPair creates the key pair of a write-only collection. The private key is wrapped
+// by the private keyring.
+func (vault Vault) generateKeyPair(ctx context.Context, col *Collection) error {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	keyring, err := vault.privateKeyring(ctx)
+	if err != nil {
+		return err
+	}
+	wrappedKey, err := keyring.EncryptWithAD(base64.StdEncoding.EncodeToString(privateKey.Bytes()), keyAssociatedData("private key", col.Name))
+	if err != nil {
+		return err
+	}
+	col.PublicKey = base64.StdEncoding.EncodeToString(privateKey.PublicKey().Bytes())
+	col.PrivateKey = wrappedKey
+	return nil
+}
+
+// unwrapPrivateKey unwraps the private key of a write-only collection. Keys created before the
+// private keyring are wrapped by the collection's data key until RewrapPrivateKeys moves them.
+func (vault Vault) unwrapPrivateKey(ctx context.Context, col *Collection, colPriv Privatiser) ([]byte, error) {
+	associatedData := keyAssociatedData("private key", col.Name)
+	var encodedKey string
+	if strings.HasPrefix(col.PrivateKey, boundKeyringCiphertextPrefix) {
+		keyring, err := vault.privateKeyring(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if encodedKey, err = keyring.DecryptWithAD(col.PrivateKey, associatedData); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if encodedKey, err = decryptWithAD(colPriv, col.PrivateKey, associatedData); err != nil {
+			return nil, err
+		}
+	}
+	return base64.StdEncoding.DecodeString(encodedKey)
+}
+
+// RewrapPrivateKeys moves the private keys of the write-only collections created before the
+// private keyring out from under their data key. It returns the number of keys rewrapped.
+func (vault Vault) RewrapPrivateKeys(ctx context.Context) (int, error) {
+	collectionNames, err := vault.Db.GetCollections(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	for _, collectionName := range collectionNames {
+		col, err := vault.Db.GetCollection(ctx, collectionName)
+		if err != nil {
+			return rewrapped, err
+		}
+		if !col.WriteOnly || strings.HasPrefix(col.PrivateKey, boundKeyringCiphertextPrefix) {
+			continue
+		}
+		colPriv, err := vault.collectionPrivatiser(col)
+		if err != nil {
+			return rewrapped, err
+		}
+		privateKey, err := vault.unwrapPrivateKey(ctx, col, colPriv)
+		if err != nil {
+			return rewrapped, err
+		}
+		keyring, err := vault.privateKeyring(ctx)
+		if err != nil {
+			return rewrapped, err
+		}
+		wrappedKey, err := keyring.EncryptWithAD(base64.StdEncoding.EncodeToString(privateKey), keyAssociatedData("private key", col.Name))
+		if err != nil {
+			return rewrapped, err
+		}
+		if err := vault.Db.UpdateCollectionPrivateKey(ctx, col.Name, col.PrivateKey, wrappedKey); err != nil {
+			var ce *ConflictError
+			if errors.As(err, &ce) {
+				// Rewrapped by another instance
+				continue
+			}
+			return rewrapped, err
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+// writeOnlyPrivatiser wraps the privatiser of a record of a write-only collection. The private
+// key of the collection is only unwrapped when a value is decrypted.
+func (vault Vault) writeOnlyPrivatiser(ctx context.Context, col *Collection, colPriv Privatiser, priv Privatiser) (Privatiser, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(col.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey := func() ([]byte, error) {
+		return vault.unwrapPrivateKey(ctx, col, colPriv)
+	}
+	return NewHybridPrivatiser(publicKey, privateKey, priv)
+}
+
+// validateDecrypt checks that a principal may decrypt the records of a write-only collection,
+// which takes the decrypt action on top of read.
+func (vault Vault) validateDecrypt(ctx context.Context, principal Principal, col *Collection, recordId string) error {
+	if !col.WriteOnly {
+		return nil
+	}
+	return vault.ValidateAction(ctx, Request{principal, PolicyActionDecrypt, fmt.Sprintf("%s/%s%s/%s", COLLECTIONS_PPATH, col.Name, RECORDS_PPATH, recordId)})
+}
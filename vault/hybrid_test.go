@@ -0,0 +1,140 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHybridPrivatiser(t *testing.T) {
+	inner, _ := NewAESGCMPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
+	privateKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	getPrivateKey := func() ([]byte, error) { return privateKey.Bytes(), nil }
+	associatedData := []byte("customers/rec_1/name")
+
+	t.Run("can encrypt and decrypt", func(t *testing.T) {
+		p, err := NewHybridPrivatiser(privateKey.PublicKey().Bytes(), getPrivateKey, inner)
+		assert.NoError(t, err)
+
+		encrypted, err := p.EncryptWithAD("hello world!", associatedData)
+		assert.NoError(t, err)
+		assert.True(t, p.isBound(encrypted))
+
+		decrypted, err := p.DecryptWithAD(encrypted, associatedData)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+
+	t.Run("can encrypt but not decrypt without the private key", func(t *testing.T) {
+		writer, _ := NewHybridPrivatiser(privateKey.PublicKey().Bytes(), nil, inner)
+		reader, _ := NewHybridPrivatiser(privateKey.PublicKey().Bytes(), getPrivateKey, inner)
+
+		encrypted, err := writer.EncryptWithAD("hello world!", associatedData)
+		assert.NoError(t, err)
+		_, err = writer.DecryptWithAD(encrypted, associatedData)
+		var ns *NotSupportedError
+		assert.ErrorAs(t, err, &ns)
+
+		decrypted, err := reader.DecryptWithAD(encrypted, associatedData)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world!", decrypted)
+	})
+
+	t.Run("cannot decrypt with other associated data", func(t *testing.T) {
+		p, _ := NewHybridPrivatiser(privateKey.PublicKey().Bytes(), getPrivateKey, inner)
+
+		encrypted, _ := p.EncryptWithAD("hello world!", associatedData)
+		_, err := p.DecryptWithAD(encrypted, []byte("customers/rec_2/name"))
+		var ie *IntegrityError
+		assert.ErrorAs(t, err, &ie)
+	})
+
+	t.Run("cannot decrypt with another private key", func(t *testing.T) {
+		otherKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+		writer, _ := NewHybridPrivatiser(privateKey.PublicKey().Bytes(), nil, inner)
+		other, _ := NewHybridPrivatiser(otherKey.PublicKey().Bytes(), func() ([]byte, error) { return otherKey.Bytes(), nil }, inner)
+
+		encrypted, _ := writer.EncryptWithAD("hello world!", associatedData)
+		_, err := other.DecryptWithAD(encrypted, associatedData)
+		assert.Error(t, err)
+	})
+}
+
+func TestWriteOnlyCollections(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	_ = db.CreatePolicy(ctx, &Policy{
+		Id:        "ingest-events",
+		Effect:    EffectAllow,
+		Actions:   []PolicyAction{PolicyActionRead, PolicyActionWrite},
+		Resources: []string{"/collections/events*"},
+	})
+	writerPrincipal := Principal{Username: "ingest", Policies: []string{"ingest-events"}}
+
+	col := Collection{Name: "events", WriteOnly: true, Fields: map[string]Field{
+		"email": {Type: "email", IsIndexed: true},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+	assert.NotEmpty(t, col.PublicKey)
+
+	recordId, err := vault.CreateRecord(ctx, writerPrincipal, col.Name, Record{"email": "john@crawford.com"})
+	assert.NoError(t, err)
+
+	t.Run("writers can create and search records but not read them", func(t *testing.T) {
+		recordIds, err := vault.SearchRecords(ctx, writerPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{recordId}, recordIds)
+
+		_, err = vault.GetRecord(ctx, writerPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		var fe *ForbiddenError
+		assert.ErrorAs(t, err, &fe)
+	})
+
+	t.Run("records are encrypted to the collection public key", func(t *testing.T) {
+		stored, _ := db.GetRecord(ctx, col.Name, recordId)
+		assert.True(t, strings.HasPrefix(stored["email"], hybridCiphertextPrefix))
+	})
+
+	t.Run("principals with the decrypt action can read records", func(t *testing.T) {
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "john@crawford.com", record["email"])
+	})
+
+	t.Run("writers can't reverse format-preserving encryption", func(t *testing.T) {
+		_, err := vault.DecryptFPE(ctx, writerPrincipal, col.Name, recordId, "email", FPE_FORMAT, "john@crawford.com")
+		var fe *ForbiddenError
+		assert.ErrorAs(t, err, &fe)
+	})
+
+	t.Run("private keys are wrapped by the private keyring", func(t *testing.T) {
+		dbCol, _ := db.GetCollection(ctx, col.Name)
+		assert.True(t, strings.HasPrefix(dbCol.PrivateKey, boundKeyringCiphertextPrefix))
+
+		// Simulate a private key wrapped by the data key before the private keyring
+		colPriv, _ := vault.collectionPrivatiser(dbCol)
+		privateKey, _ := vault.unwrapPrivateKey(ctx, dbCol, colPriv)
+		legacyKey, _ := encryptWithAD(colPriv, base64.StdEncoding.EncodeToString(privateKey), keyAssociatedData("private key", col.Name))
+		assert.NoError(t, db.UpdateCollectionPrivateKey(ctx, col.Name, dbCol.PrivateKey, legacyKey))
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "john@crawford.com", record["email"])
+
+		rewrapped, err := vault.RewrapPrivateKeys(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, rewrapped)
+		dbCol, _ = db.GetCollection(ctx, col.Name)
+		assert.True(t, strings.HasPrefix(dbCol.PrivateKey, boundKeyringCiphertextPrefix))
+
+		record, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "john@crawford.com", record["email"])
+	})
+}
@@ -0,0 +1,475 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JSONPath declares a nested path of a json field as sensitive. Its values are validated and
+// masked as values of its ptype, and can be requested in any format of it, e.g.
+// history[*].postcode.masked. Paths are written relative to the field, like history[*].postcode
+// or [0].city, where [*] is every element of an array.
+type JSONPath struct {
+	Type    string `json:"type" validate:"required"` // Any registered ptype but json
+	Pattern string `json:"pattern,omitempty"`        // Values of regex paths must match it
+	Mask    string `json:"mask,omitempty"`           // Template of the masked format of regex paths
+}
+
+func (p JSONPath) field() Field {
+	return Field{Type: p.Type, Pattern: p.Pattern, Mask: p.Mask}
+}
+
+// jsonValueMarker prefixes the JSON objects and arrays held in records, which records encode
+// as JSON rather than as strings.
+const jsonValueMarker = Null + "json:"
+
+// JSONValue marks a JSON object or array so a record holding it encodes it as JSON. Values of
+// json fields can be written either marked or as plain JSON text, and GetRecord returns
+// documents and path selections marked.
+func JSONValue(document string) string {
+	return jsonValueMarker + document
+}
+
+// ParseJSONValue returns the JSON document of a value marked with JSONValue.
+func ParseJSONValue(value string) (string, bool) {
+	if !strings.HasPrefix(value, jsonValueMarker) {
+		return "", false
+	}
+	return strings.TrimPrefix(value, jsonValueMarker), true
+}
+
+// JSONDocument is the value of a json field: a JSON object or array, whose sensitive paths are
+// declared by its field. Their paths are protected by policies on <field><path>.<format>, and
+// sensitive paths are masked in the documents read plain by principals who can't read them
+// plain. Documents are encrypted whole rather than per path: the vault decrypts the whole
+// document to return any path of it, the paths only differ in who they are returned to.
+type JSONDocument struct {
+	val   string
+	paths map[string]JSONPath
+}
+
+func (d JSONDocument) Get(format string) (string, error) {
+	return GetFormat(d, JSONType, format)
+}
+
+func (d JSONDocument) GetPlain() string {
+	return d.val
+}
+
+// GetMasked returns the document with the values of its sensitive paths masked.
+func (d JSONDocument) GetMasked() string {
+	document, err := d.masked(nil)
+	if err != nil {
+		return "*"
+	}
+	masked, _ := json.Marshal(document)
+	return string(masked)
+}
+
+func (d JSONDocument) Validate() error {
+	document, err := d.decode()
+	if err != nil {
+		return err
+	}
+	switch document.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return &ValueError{Msg: "json values must be an object or an array"}
+	}
+	return d.eachPathValue(document, func(path JSONPath, value PType) error { return nil })
+}
+
+// ValidateWrite applies the write rules of the ptypes of the sensitive paths.
+func (d JSONDocument) ValidateWrite() error {
+	document, err := d.decode()
+	if err != nil {
+		return err
+	}
+	return d.eachPathValue(document, func(path JSONPath, value PType) error {
+		if writeValidator, ok := value.(WriteValidator); ok {
+			return writeValidator.ValidateWrite()
+		}
+		return nil
+	})
+}
+
+// decode returns a fresh copy of the document, numbers are kept as written.
+func (d JSONDocument) decode() (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(d.val))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, &ValueError{Msg: fmt.Sprintf("invalid json value: %s", err)}
+	}
+	if decoder.More() {
+		return nil, &ValueError{Msg: "invalid json value: more than one value"}
+	}
+	return document, nil
+}
+
+// eachPathValue parses the values of the sensitive paths of a document with their ptypes.
+func (d JSONDocument) eachPathValue(document interface{}, fn func(path JSONPath, value PType) error) error {
+	for pathName, path := range d.paths {
+		steps, err := parseJSONPath(pathName)
+		if err != nil {
+			return err
+		}
+		_, err = transformJSON(document, steps, func(leaf interface{}) (interface{}, error) {
+			value, err := parseJSONLeaf(path, leaf)
+			if err != nil {
+				return nil, &ValueError{Msg: fmt.Sprintf("path %s: %s", pathName, err)}
+			}
+			return leaf, fn(path, value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// masked returns the document with the values of its sensitive paths masked, but for those
+// in plainPaths.
+func (d JSONDocument) masked(plainPaths map[string]bool) (interface{}, error) {
+	document, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	for pathName, path := range d.paths {
+		if plainPaths[pathName] {
+			continue
+		}
+		steps, err := parseJSONPath(pathName)
+		if err != nil {
+			return nil, err
+		}
+		path := path
+		document, err = transformJSON(document, steps, func(leaf interface{}) (interface{}, error) {
+			return formatJSONLeaf(path, leaf, MASKED_FORMAT)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return document, nil
+}
+
+// format returns the values at a path of the document in a format. Paths that aren't declared
+// sensitive can only be returned plain or masked, which masks the sensitive paths within them.
+// Returned plain, they mask the sensitive paths within them that aren't in plainPaths, the
+// paths that can be read plain themselves. Objects and arrays are returned marked with
+// JSONValue, missing paths as Null.
+func (d JSONDocument) format(pathName string, format string, plainPaths map[string]bool) (string, error) {
+	steps, err := parseJSONPath(pathName)
+	if err != nil {
+		return "", err
+	}
+
+	var document interface{}
+	switch format {
+	case PLAIN_FORMAT:
+		if _, ok := d.declaredPath(steps); ok {
+			// Requested plain themselves
+			document, err = d.decode()
+		} else {
+			document, err = d.masked(plainPaths)
+		}
+	case MASKED_FORMAT:
+		document, err = d.masked(nil)
+	default:
+		path, ok := d.declaredPath(steps)
+		if !ok {
+			return "", &NotSupportedError{Msg: fmt.Sprintf("Format %s is not supported by path %s, which isn't declared sensitive", format, pathName)}
+		}
+		if document, err = d.decode(); err != nil {
+			return "", err
+		}
+		document, err = transformJSON(document, steps, func(leaf interface{}) (interface{}, error) {
+			return formatJSONLeaf(path, leaf, format)
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	selected, ok := selectJSON(document, steps)
+	if !ok || selected == nil {
+		return Null, nil
+	}
+	switch value := selected.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	encoded, err := json.Marshal(selected)
+	if err != nil {
+		return "", err
+	}
+	return JSONValue(string(encoded)), nil
+}
+
+// declaredPath returns the sensitive path a requested path selects, where [*] of a declared
+// path also matches any index.
+func (d JSONDocument) declaredPath(steps []jsonPathStep) (JSONPath, bool) {
+	var wildcardMatch *JSONPath
+	for pathName, path := range d.paths {
+		declaredSteps, err := parseJSONPath(pathName)
+		if err != nil || len(declaredSteps) != len(steps) {
+			continue
+		}
+		exact, matches := true, true
+		for i, step := range steps {
+			if declaredSteps[i] == step {
+				continue
+			}
+			exact = false
+			if !declaredSteps[i].wildcard || !step.isIndex {
+				matches = false
+				break
+			}
+		}
+		if exact {
+			return path, true
+		}
+		if matches {
+			path := path
+			wildcardMatch = &path
+		}
+	}
+	if wildcardMatch == nil {
+		return JSONPath{}, false
+	}
+	return *wildcardMatch, true
+}
+
+// parseJSONLeaf parses a value at a sensitive path with the path's ptype.
+func parseJSONLeaf(path JSONPath, leaf interface{}) (PType, error) {
+	var value string
+	switch leafValue := leaf.(type) {
+	case string:
+		value = leafValue
+	case json.Number:
+		value = leafValue.String()
+	case bool:
+		value = strconv.FormatBool(leafValue)
+	default:
+		return nil, &ValueError{Msg: "sensitive paths must hold strings, numbers or booleans"}
+	}
+	return GetFieldPType(path.field(), value)
+}
+
+func formatJSONLeaf(path JSONPath, leaf interface{}, format string) (interface{}, error) {
+	if leaf == nil {
+		return nil, nil
+	}
+	value, err := parseJSONLeaf(path, leaf)
+	if err != nil {
+		return nil, err
+	}
+	return value.Get(format)
+}
+
+// jsonPathStep is a step of a path into a JSON document: an object member, an array index or
+// every element of an array.
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+var jsonPathSegment = regexp.MustCompile(`^([A-Za-z0-9_-]*)((?:\[(?:\d+|\*)\])*)$`)
+
+// parseJSONPath parses a path relative to a json field: members separated by dots, each
+// followed by any number of [n] or [*]. The empty path is the whole document.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	steps := []jsonPathStep{}
+	if path == "" {
+		return steps, nil
+	}
+	for i, segment := range strings.Split(path, ".") {
+		match := jsonPathSegment.FindStringSubmatch(segment)
+		// Only the first segment can start with an index, for documents that are arrays
+		if match == nil || segment == "" || (match[1] == "" && i > 0) {
+			return nil, &ValueError{Msg: fmt.Sprintf("invalid path %s", path)}
+		}
+		if match[1] != "" {
+			steps = append(steps, jsonPathStep{key: match[1]})
+		}
+		for _, index := range strings.Split(strings.Trim(match[2], "[]"), "][") {
+			switch index {
+			case "":
+			case "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			default:
+				n, err := strconv.Atoi(index)
+				if err != nil {
+					return nil, &ValueError{Msg: fmt.Sprintf("invalid path %s", path)}
+				}
+				steps = append(steps, jsonPathStep{index: n, isIndex: true})
+			}
+		}
+	}
+	return steps, nil
+}
+
+// joinFieldPath returns the name a path into a field is requested by, the reverse of
+// splitFieldPath.
+func joinFieldPath(field string, path string) string {
+	if path == "" || strings.HasPrefix(path, "[") {
+		return field + path
+	}
+	return field + "." + path
+}
+
+// plainJSONPaths returns the sensitive paths of a json field of a record that a principal can
+// read plain, so that reading a document or a path containing them plain doesn't reveal the
+// others.
+func (vault Vault) plainJSONPaths(ctx context.Context, principal Principal, col *Collection, recordID string, fieldName string) (map[string]bool, error) {
+	plainPaths := map[string]bool{}
+	paths := col.Fields[fieldName].Paths
+	if len(paths) == 0 {
+		return plainPaths, nil
+	}
+	policies, err := vault.Db.GetPolicies(ctx, principal.Policies)
+	if err != nil {
+		return nil, err
+	}
+	for pathName := range paths {
+		resource := fmt.Sprintf("%s/%s%s/%s/%s.%s", COLLECTIONS_PPATH, col.Name, RECORDS_PPATH, recordID, joinFieldPath(fieldName, pathName), PLAIN_FORMAT)
+		plainPaths[pathName] = EvaluateRequest(Request{principal, PolicyActionRead, resource}, policies)
+	}
+	return plainPaths, nil
+}
+
+// splitFieldPath splits a requested field into the name of the field and a path into it, e.g.
+// history[*].postcode into history and [*].postcode, or prefs.theme into prefs and theme.
+func splitFieldPath(field string) (string, string) {
+	i := strings.IndexAny(field, ".[")
+	switch {
+	case i < 0:
+		return field, ""
+	case field[i] == '.':
+		return field[:i], field[i+1:]
+	}
+	return field[:i], field[i:]
+}
+
+// selectJSON returns the value at a path of a document. Paths through [*] select an array of
+// the values found in each element.
+func selectJSON(value interface{}, steps []jsonPathStep) (interface{}, bool) {
+	if len(steps) == 0 {
+		return value, true
+	}
+	step, rest := steps[0], steps[1:]
+	switch {
+	case step.wildcard:
+		elements, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		selected := []interface{}{}
+		for _, element := range elements {
+			if elementValue, ok := selectJSON(element, rest); ok {
+				selected = append(selected, elementValue)
+			}
+		}
+		return selected, true
+	case step.isIndex:
+		elements, ok := value.([]interface{})
+		if !ok || step.index >= len(elements) {
+			return nil, false
+		}
+		return selectJSON(elements[step.index], rest)
+	}
+	members, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	member, ok := members[step.key]
+	if !ok {
+		return nil, false
+	}
+	return selectJSON(member, rest)
+}
+
+// transformJSON replaces every value at a path of a document with the result of fn, leaving
+// the document as is where the path doesn't exist. Null values are left null.
+func transformJSON(value interface{}, steps []jsonPathStep, fn func(leaf interface{}) (interface{}, error)) (interface{}, error) {
+	if len(steps) == 0 {
+		if value == nil {
+			return nil, nil
+		}
+		return fn(value)
+	}
+	step, rest := steps[0], steps[1:]
+	switch {
+	case step.wildcard, step.isIndex:
+		elements, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		for i := range elements {
+			if step.isIndex && i != step.index {
+				continue
+			}
+			transformed, err := transformJSON(elements[i], rest, fn)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = transformed
+		}
+		return elements, nil
+	}
+	members, ok := value.(map[string]interface{})
+	if !ok {
+		return value, nil
+	}
+	if member, ok := members[step.key]; ok {
+		transformed, err := transformJSON(member, rest, fn)
+		if err != nil {
+			return nil, err
+		}
+		members[step.key] = transformed
+	}
+	return members, nil
+}
+
+// validatePaths checks the sensitive paths of a json field.
+func (f Field) validatePaths() error {
+	if PTypeName(f.Type) != JSONType {
+		if len(f.Paths) > 0 {
+			return &ValueError{Msg: fmt.Sprintf("paths are only supported by the %s type", JSONType)}
+		}
+		return nil
+	}
+	for pathName, path := range f.Paths {
+		if _, err := parseJSONPath(pathName); err != nil || pathName == "" {
+			return &ValueError{Msg: fmt.Sprintf("invalid path %q", pathName)}
+		}
+		if !IsPTypeRegistered(PTypeName(path.Type)) || PTypeName(path.Type) == JSONType {
+			return &ValueError{Msg: fmt.Sprintf("path %s: unknown type %s", pathName, path.Type)}
+		}
+		if err := path.field().validatePattern(); err != nil {
+			return &ValueError{Msg: fmt.Sprintf("path %s: %s", pathName, err)}
+		}
+	}
+	return nil
+}
+
+// compactJSON returns JSON text without insignificant whitespace.
+func compactJSON(data []byte) (string, error) {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, data); err != nil {
+		return "", err
+	}
+	return compacted.String(), nil
+}
@@ -0,0 +1,135 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	steps, err := parseJSONPath("history[*].postcode")
+	assert.NoError(t, err)
+	assert.Equal(t, []jsonPathStep{{key: "history"}, {wildcard: true}, {key: "postcode"}}, steps)
+
+	steps, err = parseJSONPath("[1][*]")
+	assert.NoError(t, err)
+	assert.Equal(t, []jsonPathStep{{index: 1, isIndex: true}, {wildcard: true}}, steps)
+
+	for _, invalid := range []string{"history.", "history..postcode", "history.[0]", "history[x]", "history[*"} {
+		_, err := parseJSONPath(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSplitFieldPath(t *testing.T) {
+	for requested, expected := range map[string][2]string{
+		"name":                {"name", ""},
+		"prefs.theme":         {"prefs", "theme"},
+		"history[*].postcode": {"history", "[*].postcode"},
+	} {
+		field, path := splitFieldPath(requested)
+		assert.Equal(t, expected, [2]string{field, path})
+		assert.Equal(t, requested, joinFieldPath(field, path))
+	}
+}
+
+func TestJSONDocument(t *testing.T) {
+	field := Field{Type: "json", Paths: map[string]JSONPath{
+		"email":                {Type: "email"},
+		"history[*].postcode":  {Type: "regex", Pattern: `([A-Z0-9]+) ([A-Z0-9]+)`, Mask: "$1 ***"},
+		"history[*].moved_in":  {Type: "date"},
+		"preferences.children": {Type: "integer"},
+	}}
+	document := `{
+		"email": "john@crawford.com",
+		"history": [
+			{"postcode": "SW1A 2AA", "moved_in": "2010-05-01"},
+			{"postcode": "LS1 4AP", "moved_in": null}
+		],
+		"preferences": {"theme": "dark", "children": 2}
+	}`
+
+	t.Run("values must be objects or arrays with valid sensitive paths", func(t *testing.T) {
+		_, err := GetFieldPType(field, `"just a string"`)
+		assert.Error(t, err)
+		_, err = GetFieldPType(field, `{"email": "not an email"}`)
+		assert.ErrorContains(t, err, "path email")
+		_, err = GetFieldPType(field, `{"email": {"address": "john@crawford.com"}}`)
+		assert.Error(t, err)
+		_, err = GetFieldPType(field, `{"history": "not an array"}`)
+		assert.NoError(t, err)
+		_, err = GetFieldPType(field, JSONValue(`[]`))
+		assert.NoError(t, err)
+	})
+
+	value, err := GetFieldPType(field, document)
+	assert.NoError(t, err)
+	jsonDocument := value.(JSONDocument)
+	plainPaths := map[string]bool{}
+	for pathName := range field.Paths {
+		plainPaths[pathName] = true
+	}
+
+	t.Run("paths are returned in the formats of their ptype", func(t *testing.T) {
+		for path, expected := range map[string]string{
+			"email.plain":                 "john@crawford.com",
+			"email.domain":                "crawford.com",
+			"history[0].postcode.plain":   "SW1A 2AA",
+			"history[*].postcode.masked":  JSONValue(`["SW1A ***","LS1 ***"]`),
+			"history[*].moved_in.year":    JSONValue(`["2010",null]`),
+			"preferences.children.plain":  "2",
+			"preferences.masked":          JSONValue(`{"children":"*","theme":"dark"}`),
+			"history[5].postcode.plain":   Null,
+			"preferences.language.masked": Null,
+		} {
+			i := strings.LastIndex(path, ".")
+			formatted, err := jsonDocument.format(path[:i], path[i+1:], plainPaths)
+			assert.NoError(t, err, path)
+			assert.Equal(t, expected, formatted, path)
+		}
+	})
+
+	t.Run("paths that aren't sensitive can only be plain or masked", func(t *testing.T) {
+		_, err := jsonDocument.format("preferences.theme", "last4", plainPaths)
+		var notSupportedErr *NotSupportedError
+		assert.ErrorAs(t, err, &notSupportedErr)
+	})
+
+	t.Run("plain paths mask the sensitive paths within them that can't be read plain", func(t *testing.T) {
+		formatted, err := jsonDocument.format("history", PLAIN_FORMAT, map[string]bool{"history[*].moved_in": true})
+		assert.NoError(t, err)
+		assert.Equal(t, JSONValue(`[{"moved_in":"2010-05-01","postcode":"SW1A ***"},{"moved_in":null,"postcode":"LS1 ***"}]`), formatted)
+
+		formatted, err = jsonDocument.format("history[0].postcode", PLAIN_FORMAT, map[string]bool{})
+		assert.NoError(t, err)
+		assert.Equal(t, "SW1A 2AA", formatted)
+	})
+
+	t.Run("the masked document masks every sensitive path", func(t *testing.T) {
+		masked, err := jsonDocument.Get(MASKED_FORMAT)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"email": "****@crawford.com",
+			"history": [
+				{"postcode": "SW1A ***", "moved_in": "****-**-**"},
+				{"postcode": "LS1 ***", "moved_in": null}
+			],
+			"preferences": {"theme": "dark", "children": "*"}
+		}`, masked)
+	})
+}
+
+func TestValidatePaths(t *testing.T) {
+	for _, field := range []Field{
+		{Type: "string", Paths: map[string]JSONPath{"email": {Type: "email"}}},
+		{Type: "json", Paths: map[string]JSONPath{"history[": {Type: "string"}}},
+		{Type: "json", Paths: map[string]JSONPath{"email": {Type: "unknown"}}},
+		{Type: "json", Paths: map[string]JSONPath{"nested": {Type: "json"}}},
+		{Type: "json", Paths: map[string]JSONPath{"code": {Type: "regex"}}},
+	} {
+		var ve *ValueError
+		assert.ErrorAs(t, field.validatePaths(), &ve)
+	}
+	assert.NoError(t, Field{Type: "json", Paths: map[string]JSONPath{"[*].postcode": {Type: "string"}}}.validatePaths())
+}
@@ -0,0 +1,148 @@
+package vault
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyProvider protects the root key material of the vault. Keys generated by the vault
+// (keyring keys, the signing key) are only ever stored wrapped by a provider.
+type KeyProvider interface {
+	Wrap(ctx context.Context, key []byte) (string, error)
+	Unwrap(ctx context.Context, wrappedKey string) ([]byte, error)
+}
+
+// Keys wrapped by SecretKeyProvider are formatted as "s1:<base64 payload>".
+const secretWrapPrefix = "s1:"
+
+// SecretKeyProvider wraps keys with an AES-256-GCM key derived from a secret of any length
+// using HKDF. Keys wrapped with AESGCMPrivatiser before key providers were introduced remain
+// readable when the secret is a valid AES key.
+type SecretKeyProvider struct {
+	aead   cipher.AEAD
+	legacy Privatiser
+}
+
+func NewSecretKeyProvider(secret string) (*SecretKeyProvider, error) {
+	if secret == "" {
+		return nil, errors.New("encryption secret cannot be empty")
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte("subrose/vault key provider")), key); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &SecretKeyProvider{aead: aead}
+	if legacy, err := NewAESGCMPrivatiser(secret); err == nil {
+		p.legacy = legacy
+	}
+	return p, nil
+}
+
+func (p *SecretKeyProvider) Wrap(ctx context.Context, key []byte) (string, error) {
+	sealed, err := sealGCM(p.aead, key)
+	if err != nil {
+		return "", err
+	}
+	return secretWrapPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (p *SecretKeyProvider) Unwrap(ctx context.Context, wrappedKey string) ([]byte, error) {
+	if !strings.HasPrefix(wrappedKey, secretWrapPrefix) {
+		if p.legacy == nil {
+			return nil, errors.New("key was not wrapped by this provider")
+		}
+		encodedKey, err := p.legacy.Decrypt(wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(encodedKey)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(wrappedKey[len(secretWrapPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	return openGCM(p.aead, data)
+}
+
+func sealGCM(aead cipher.AEAD, plainText []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plainText, nil), nil
+}
+
+func openGCM(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plainText, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.New("ciphertext failed authentication")
+	}
+	return plainText, nil
+}
+
+// The signing key is stored wrapped in the keyring table under this name. It is never rotated
+// since blind indexes are computed with it.
+const signingKeyring = "signing"
+
+const signingKeySize = 32
+
+// NewProviderSigner returns an HMACSigner whose key is generated on first use and stored
+// wrapped by the key provider.
+func NewProviderSigner(ctx context.Context, db VaultDB, provider KeyProvider) (*HMACSigner, error) {
+	storedKeys, err := db.GetKeyringKeys(ctx, signingKeyring)
+	if err != nil {
+		return nil, err
+	}
+	if len(storedKeys) > 0 {
+		key, err := provider.Unwrap(ctx, storedKeys[0].WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap signing key: %w", err)
+		}
+		return NewHMACSigner(key)
+	}
+
+	key := make([]byte, signingKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := provider.Wrap(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	err = db.CreateKeyringKey(ctx, &KeyringKey{
+		Keyring:    signingKeyring,
+		Version:    1,
+		WrappedKey: wrappedKey,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		var ce *ConflictError
+		if errors.As(err, &ce) {
+			// Another instance created it first
+			return NewProviderSigner(ctx, db, provider)
+		}
+		return nil, err
+	}
+	return NewHMACSigner(key)
+}
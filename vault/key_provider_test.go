@@ -0,0 +1,52 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	t.Run("can wrap and unwrap with a secret of any length", func(t *testing.T) {
+		for _, secret := range []string{"short", "abc&1*~#^2^#s0^=)^^7%b34", "a passphrase that is much longer than any aes key"} {
+			p, err := NewSecretKeyProvider(secret)
+			assert.NoError(t, err)
+
+			wrapped, err := p.Wrap(ctx, key)
+			assert.NoError(t, err)
+			unwrapped, err := p.Unwrap(ctx, wrapped)
+			assert.NoError(t, err)
+			assert.Equal(t, key, unwrapped)
+		}
+	})
+
+	t.Run("cannot unwrap with another secret", func(t *testing.T) {
+		p, _ := NewSecretKeyProvider("secret")
+		other, _ := NewSecretKeyProvider("another secret")
+
+		wrapped, _ := other.Wrap(ctx, key)
+		_, err := p.Unwrap(ctx, wrapped)
+		assert.Error(t, err)
+	})
+
+	t.Run("can unwrap keys wrapped before key providers", func(t *testing.T) {
+		secret := "abc&1*~#^2^#s0^=)^^7%b34"
+		secretPriv, _ := NewAESGCMPrivatiser(secret)
+		wrapped, _ := secretPriv.Encrypt(base64.StdEncoding.EncodeToString(key))
+
+		p, _ := NewSecretKeyProvider(secret)
+		unwrapped, err := p.Unwrap(ctx, wrapped)
+		assert.NoError(t, err)
+		assert.Equal(t, key, unwrapped)
+	})
+
+	t.Run("rejects an empty secret", func(t *testing.T) {
+		_, err := NewSecretKeyProvider("")
+		assert.Error(t, err)
+	})
+}
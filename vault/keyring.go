@@ -0,0 +1,448 @@
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Ciphertexts produced by a Keyring are formatted as "v2:<key version>:<base64 payload>", or
+// "v7:<key version>:<base64 payload>" when they are bound to associated data.
+const (
+	keyringCiphertextPrefix      = "v2:"
+	boundKeyringCiphertextPrefix = "v7:"
+)
+
+const keyringKeySize = 32
+
+type KeyringKey struct {
+	Keyring    string    `json:"keyring"`
+	Version    int       `json:"version"`
+	WrappedKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type KeyringStatus struct {
+	Name          string `json:"name"`
+	ActiveVersion int    `json:"active_version"`
+	Versions      []int  `json:"versions"`
+}
+
+// Keyring is a Privatiser and Signer backed by versioned AES-256-GCM keys. New values are
+// always encrypted and signed with the active (latest) key and each ciphertext or signature
+// records the version it was written with, so keys that have been rotated out remain usable
+// for decryption and verification. Key material is stored in the database, wrapped by the
+// key provider.
+type Keyring struct {
+	name     string
+	db       VaultDB
+	provider KeyProvider
+	fallback Privatiser
+	mu       sync.RWMutex
+	keys     map[int]cipher.AEAD
+	signers  map[int]*HMACSigner
+	active   int
+	jobs     map[string]*RekeyJob
+}
+
+// KeyringCache keeps the keyrings loaded on demand, those of transit keys and the private
+// keyring, so that their keys are unwrapped once rather than on every call. Keyrings are
+// reloaded after ttl to pick up the versions rotated by other instances.
+type KeyringCache struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	keyrings map[string]cachedKeyring
+}
+
+type cachedKeyring struct {
+	keyring   *Keyring
+	expiresAt time.Time
+}
+
+func NewKeyringCache(ttl time.Duration) *KeyringCache {
+	return &KeyringCache{ttl: ttl, keyrings: map[string]cachedKeyring{}}
+}
+
+func (c *KeyringCache) get(name string) (*Keyring, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.keyrings[name]
+	if !ok || !time.Now().Before(cached.expiresAt) {
+		return nil, false
+	}
+	return cached.keyring, true
+}
+
+func (c *KeyringCache) add(name string, keyring *Keyring) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keyrings[name] = cachedKeyring{keyring, time.Now().Add(c.ttl)}
+}
+
+func (c *KeyringCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keyrings, name)
+}
+
+// clear drops every keyring, along with its unwrapped keys.
+func (c *KeyringCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keyrings = map[string]cachedKeyring{}
+}
+
+// NewKeyring loads the named keyring from the database, creating its first key if it
+// doesn't exist yet. Values that weren't written by a keyring are decrypted with fallback,
+// which may be nil.
+func NewKeyring(ctx context.Context, db VaultDB, name string, provider KeyProvider, fallback Privatiser) (*Keyring, error) {
+	k := &Keyring{
+		name:     name,
+		db:       db,
+		provider: provider,
+		fallback: fallback,
+		keys:     map[int]cipher.AEAD{},
+		signers:  map[int]*HMACSigner{},
+		jobs:     map[string]*RekeyJob{},
+	}
+	if err := k.load(ctx); err != nil {
+		return nil, err
+	}
+	if k.active == 0 {
+		if _, err := k.Rotate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) load(ctx context.Context) error {
+	storedKeys, err := k.db.GetKeyringKeys(ctx, k.name)
+	if err != nil {
+		return err
+	}
+
+	// Keys are unwrapped without holding the lock, as the provider may call out to a KMS.
+	k.mu.RLock()
+	var missingKeys []*KeyringKey
+	for _, storedKey := range storedKeys {
+		if _, ok := k.keys[storedKey.Version]; !ok {
+			missingKeys = append(missingKeys, storedKey)
+		}
+	}
+	k.mu.RUnlock()
+
+	unwrappedKeys := make(map[int][]byte, len(missingKeys))
+	for _, storedKey := range missingKeys {
+		key, err := k.provider.Unwrap(ctx, storedKey.WrappedKey)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap key %d of keyring %s: %w", storedKey.Version, k.name, err)
+		}
+		unwrappedKeys[storedKey.Version] = key
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for version, key := range unwrappedKeys {
+		if _, ok := k.keys[version]; ok {
+			// Loaded concurrently
+			continue
+		}
+		if err := k.add(version, key); err != nil {
+			return err
+		}
+		if version > k.active {
+			k.active = version
+		}
+	}
+	return nil
+}
+
+// RewrapKeyrings wraps the keys of every keyring that provider can't unwrap with it, unwrapping
+// them with previous. It moves the keyrings of a vault to another key provider, e.g. when a
+// vault starts being sealed with key shares, and returns the number of keys rewrapped.
+func RewrapKeyrings(ctx context.Context, db VaultDB, previous KeyProvider, provider KeyProvider) (int, error) {
+	keyrings, err := db.GetKeyrings(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	for _, keyring := range keyrings {
+		if isPrivatiserKeyring(keyring) {
+			// Wrapped by the vault's keyring, which moves along with it
+			continue
+		}
+		storedKeys, err := db.GetKeyringKeys(ctx, keyring)
+		if err != nil {
+			return rewrapped, err
+		}
+		for _, storedKey := range storedKeys {
+			if _, err := provider.Unwrap(ctx, storedKey.WrappedKey); err == nil {
+				continue
+			}
+			key, err := previous.Unwrap(ctx, storedKey.WrappedKey)
+			if err != nil {
+				return rewrapped, fmt.Errorf("failed to unwrap key %d of keyring %s: %w", storedKey.Version, keyring, err)
+			}
+			current := storedKey.WrappedKey
+			if storedKey.WrappedKey, err = provider.Wrap(ctx, key); err != nil {
+				return rewrapped, err
+			}
+			if err := db.UpdateKeyringKey(ctx, storedKey, current); err != nil {
+				var ce *ConflictError
+				if errors.As(err, &ce) {
+					// Rewrapped concurrently
+					continue
+				}
+				return rewrapped, err
+			}
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
+
+// add makes a key available under its version. Signatures are made with a key derived from it
+// so that no key is used both to encrypt and to sign.
+func (k *Keyring) add(version int, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	signingKey := make([]byte, keyringKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("keyring signing")), signingKey); err != nil {
+		return err
+	}
+	signer, err := NewHMACSigner(signingKey)
+	if err != nil {
+		return err
+	}
+	k.keys[version] = aead
+	k.signers[version] = signer
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Rotate generates a new key and makes it the active one, returning its version.
+func (k *Keyring) Rotate(ctx context.Context) (int, error) {
+	// Pick up keys created by other instances so the new version is the latest one.
+	if err := k.load(ctx); err != nil {
+		return 0, err
+	}
+
+	key := make([]byte, keyringKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	wrappedKey, err := k.provider.Wrap(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	version := k.active + 1
+	err = k.db.CreateKeyringKey(ctx, &KeyringKey{
+		Keyring:    k.name,
+		Version:    version,
+		WrappedKey: wrappedKey,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := k.add(version, key); err != nil {
+		return 0, err
+	}
+	k.active = version
+	return version, nil
+}
+
+func (k *Keyring) Status() KeyringStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	versions := make([]int, 0, len(k.keys))
+	for version := range k.keys {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return KeyringStatus{Name: k.name, ActiveVersion: k.active, Versions: versions}
+}
+
+// IsActive reports whether a ciphertext was written with the active key.
+func (k *Keyring) IsActive(ciphertext string) bool {
+	version, _, err := parseKeyringCiphertext(ciphertext)
+	if err != nil {
+		return false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return version == k.active
+}
+
+func (k *Keyring) key(version int) (cipher.AEAD, error) {
+	k.mu.RLock()
+	aead, ok := k.keys[version]
+	k.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	// The key may have been created by another instance since we last loaded the keyring.
+	if err := k.load(context.Background()); err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if aead, ok := k.keys[version]; ok {
+		return aead, nil
+	}
+	return nil, &NotFoundError{fmt.Sprintf("keyring %s key version", k.name), strconv.Itoa(version)}
+}
+
+func (k *Keyring) signer(version int) (*HMACSigner, error) {
+	if _, err := k.key(version); err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.signers[version], nil
+}
+
+func (k *Keyring) Encrypt(text string) (string, error) {
+	return k.seal(keyringCiphertextPrefix, text, nil)
+}
+
+func (k *Keyring) Decrypt(encodedText string) (string, error) {
+	return k.DecryptWithAD(encodedText, nil)
+}
+
+// EncryptWithAD encrypts text with the active key, bound to associatedData.
+func (k *Keyring) EncryptWithAD(text string, associatedData []byte) (string, error) {
+	return k.seal(boundKeyringCiphertextPrefix, text, associatedData)
+}
+
+// DecryptWithAD decrypts a ciphertext bound to associatedData. Ciphertexts written without
+// associated data are decrypted without it.
+func (k *Keyring) DecryptWithAD(encodedText string, associatedData []byte) (string, error) {
+	switch {
+	case strings.HasPrefix(encodedText, boundKeyringCiphertextPrefix):
+	case strings.HasPrefix(encodedText, keyringCiphertextPrefix):
+		associatedData = nil
+	case k.fallback == nil:
+		return "", errors.New("ciphertext was not written by a keyring")
+	default:
+		return k.fallback.Decrypt(encodedText)
+	}
+
+	version, data, err := parseKeyringCiphertext(encodedText)
+	if err != nil {
+		return "", err
+	}
+	aead, err := k.key(version)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plainText, err := aead.Open(nil, nonce, sealed, associatedData)
+	if err != nil {
+		return "", &IntegrityError{Msg: "ciphertext failed authentication"}
+	}
+	return string(plainText), nil
+}
+
+func (k *Keyring) seal(prefix string, text string, associatedData []byte) (string, error) {
+	k.mu.RLock()
+	version, aead := k.active, k.keys[k.active]
+	k.mu.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(text), associatedData)
+
+	return fmt.Sprintf("%s%d:%s", prefix, version, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// isBound reports whether a ciphertext was written by a keyring bound to associated data.
+func (k *Keyring) isBound(encodedText string) bool {
+	return strings.HasPrefix(encodedText, boundKeyringCiphertextPrefix)
+}
+
+// Sign signs message with the active key. Signatures are formatted as
+// "v2:<key version>:<hex HMAC>".
+func (k *Keyring) Sign(message string) (string, error) {
+	k.mu.RLock()
+	version, signer := k.active, k.signers[k.active]
+	k.mu.RUnlock()
+
+	signature, err := signer.Sign(message)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s", keyringCiphertextPrefix, version, signature), nil
+}
+
+// Verify checks a signature made with any version of the keyring.
+func (k *Keyring) Verify(message, signature string) (bool, error) {
+	parts := strings.SplitN(strings.TrimPrefix(signature, keyringCiphertextPrefix), ":", 2)
+	if !strings.HasPrefix(signature, keyringCiphertextPrefix) || len(parts) != 2 {
+		return false, &ValueError{Msg: "invalid keyring signature"}
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false, &ValueError{Msg: "invalid keyring signature version"}
+	}
+	signer, err := k.signer(version)
+	if err != nil {
+		return false, err
+	}
+	return signer.Verify(message, parts[1])
+}
+
+func parseKeyringCiphertext(encodedText string) (int, []byte, error) {
+	prefix := keyringCiphertextPrefix
+	if strings.HasPrefix(encodedText, boundKeyringCiphertextPrefix) {
+		prefix = boundKeyringCiphertextPrefix
+	}
+	parts := strings.SplitN(strings.TrimPrefix(encodedText, prefix), ":", 2)
+	if !strings.HasPrefix(encodedText, prefix) || len(parts) != 2 {
+		return 0, nil, errors.New("invalid keyring ciphertext")
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, nil, errors.New("invalid keyring ciphertext version")
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, data, nil
+}
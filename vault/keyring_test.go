@@ -0,0 +1,209 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	ctx := context.Background()
+	secretPriv, _ := NewAESGCMPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
+	provider, _ := NewSecretKeyProvider("abc&1*~#^2^#s0^=)^^7%b34")
+
+	t.Run("can decrypt values written with rotated keys", func(t *testing.T) {
+		_, db, _ := initVault(t)
+		keyring, err := NewKeyring(ctx, db, "data", provider, secretPriv)
+		assert.NoError(t, err)
+
+		before, _ := keyring.Encrypt("hello")
+		version, err := keyring.Rotate(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+		after, _ := keyring.Encrypt("world")
+
+		assert.False(t, keyring.IsActive(before))
+		assert.True(t, keyring.IsActive(after))
+
+		// A keyring loaded from the database can read both
+		reloaded, err := NewKeyring(ctx, db, "data", provider, secretPriv)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, reloaded.Status().ActiveVersion)
+		for ciphertext, expected := range map[string]string{before: "hello", after: "world"} {
+			decrypted, err := reloaded.Decrypt(ciphertext)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, decrypted)
+		}
+	})
+
+	t.Run("can decrypt values written before the keyring", func(t *testing.T) {
+		_, db, _ := initVault(t)
+		keyring, _ := NewKeyring(ctx, db, "data", provider, secretPriv)
+
+		encrypted, _ := secretPriv.Encrypt("hello")
+		decrypted, err := keyring.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", decrypted)
+	})
+
+	t.Run("can verify signatures made with rotated keys", func(t *testing.T) {
+		_, db, _ := initVault(t)
+		keyring, _ := NewKeyring(ctx, db, "data", provider, secretPriv)
+
+		before, err := keyring.Sign("hello")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(before, "v2:1:"))
+		_, _ = keyring.Rotate(ctx)
+		after, _ := keyring.Sign("hello")
+		assert.True(t, strings.HasPrefix(after, "v2:2:"))
+
+		for _, signature := range []string{before, after} {
+			valid, err := keyring.Verify("hello", signature)
+			assert.NoError(t, err)
+			assert.True(t, valid)
+		}
+		valid, err := keyring.Verify("world", after)
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("rekey job rewraps data keys with the active key", func(t *testing.T) {
+		vault, db, _ := initVault(t)
+		keyring, _ := NewKeyring(ctx, db, "data", provider, secretPriv)
+		vault.Priv = keyring
+		rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+		col := Collection{Name: "customers", Fields: map[string]Field{
+			"email": {Type: "email", IsIndexed: true},
+		}}
+		_ = vault.CreateCollection(ctx, rootPrincipal, &col)
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		storedBefore, _ := db.GetRecord(ctx, col.Name, recordId)
+
+		_, err = vault.RotateKey(ctx, rootPrincipal)
+		assert.NoError(t, err)
+
+		job, err := vault.StartRekey(ctx, rootPrincipal)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			job, _ = vault.GetRekeyJob(ctx, rootPrincipal, job.Id)
+			return job.Status != RekeyJobRunning
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, RekeyJobCompleted, job.Status)
+		assert.Equal(t, []*RekeyCollectionProgress{{Name: "customers", Total: 1, Processed: 1, Rekeyed: 0}}, job.Collections)
+
+		dbCol, _ := db.GetCollection(ctx, col.Name)
+		assert.True(t, keyring.IsActive(dbCol.DataKey))
+
+		// Records are encrypted with the data key so they are left untouched
+		storedAfter, _ := db.GetRecord(ctx, col.Name, recordId)
+		assert.Equal(t, storedBefore["email"], storedAfter["email"])
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "john@crawford.com", record["email"])
+	})
+
+	t.Run("rekey job moves records written before data keys to a subject key", func(t *testing.T) {
+		vault, db, _ := initVault(t)
+		keyring, _ := NewKeyring(ctx, db, "data", provider, secretPriv)
+		vault.Priv = keyring
+		rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+		col := Collection{Name: "customers", Fields: map[string]Field{
+			"email": {Type: "email", IsIndexed: true},
+		}}
+		_ = vault.CreateCollection(ctx, rootPrincipal, &col)
+		recordId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"email": "john@crawford.com"})
+
+		// Simulate a collection created before data keys were introduced
+		dbCol, _ := db.GetCollection(ctx, col.Name)
+		legacyEmail, _ := keyring.Encrypt("john@crawford.com")
+		stored, _ := db.GetRecord(ctx, col.Name, recordId)
+		_ = db.UpdateRecordIfUnchanged(ctx, col.Name, recordId, stored, Record{"email": legacyEmail, "email_bidx": stored["email_bidx"], record_mac_field: ""})
+		_ = db.UpdateCollectionDataKey(ctx, col.Name, dbCol.DataKey, "")
+		db.(*SqlStore).db.Exec("DELETE FROM subject_keys")
+		db.(*SqlStore).db.Exec("UPDATE collections_metadata SET strict = false")
+
+		job, err := vault.StartRekey(ctx, rootPrincipal)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			job, _ = vault.GetRekeyJob(ctx, rootPrincipal, job.Id)
+			return job.Status != RekeyJobRunning
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, RekeyJobCompleted, job.Status)
+		assert.Equal(t, []*RekeyCollectionProgress{{Name: "customers", Total: 1, Processed: 1, Rekeyed: 1}}, job.Collections)
+
+		dbCol, _ = db.GetCollection(ctx, col.Name)
+		assert.True(t, keyring.isBound(dbCol.DataKey))
+		assert.True(t, dbCol.Strict)
+		stored, _ = db.GetRecord(ctx, col.Name, recordId)
+		assert.True(t, strings.HasPrefix(stored["email"], boundSubjectKeyCiphertextPrefix))
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "john@crawford.com", record["email"])
+
+		// The data key copied into a field of a strict collection is not decrypted
+		_ = db.UpdateRecordIfUnchanged(ctx, col.Name, recordId, stored, Record{"email": dbCol.DataKey})
+		_, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"email": "plain"})
+		var ie *IntegrityError
+		assert.ErrorAs(t, err, &ie)
+	})
+
+	t.Run("keyrings can be moved to another key provider", func(t *testing.T) {
+		_, db, _ := initVault(t)
+		keyring, _ := NewKeyring(ctx, db, "data", provider, secretPriv)
+		_, _ = keyring.Rotate(ctx)
+		encrypted, _ := keyring.Encrypt("hello")
+		// Transit keys are wrapped by the keyring itself and are left alone
+		_, _ = NewKeyring(ctx, db, transitKeyringPrefix+"payments", privatiserKeyProvider{keyring, transitKeyringPrefix + "payments"}, nil)
+
+		sealProvider, _ := newSealKeyProvider([]byte("0123456789abcdef0123456789abcdef"))
+		_, err := NewKeyring(ctx, db, "data", sealProvider, nil)
+		assert.Error(t, err)
+
+		rewrapped, err := RewrapKeyrings(ctx, db, provider, sealProvider)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, rewrapped)
+		rewrapped, err = RewrapKeyrings(ctx, db, provider, sealProvider)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, rewrapped)
+
+		resealed, err := NewKeyring(ctx, db, "data", sealProvider, nil)
+		assert.NoError(t, err)
+		decrypted, err := resealed.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", decrypted)
+	})
+}
+
+func TestKeyringCache(t *testing.T) {
+	cache := NewKeyringCache(time.Minute)
+	keyring := &Keyring{name: "transit/payments"}
+
+	_, ok := cache.get(keyring.name)
+	assert.False(t, ok)
+	cache.add(keyring.name, keyring)
+	cached, ok := cache.get(keyring.name)
+	assert.True(t, ok)
+	assert.Same(t, keyring, cached)
+
+	cache.remove(keyring.name)
+	_, ok = cache.get(keyring.name)
+	assert.False(t, ok)
+
+	cache.add(keyring.name, keyring)
+	cache.clear()
+	_, ok = cache.get(keyring.name)
+	assert.False(t, ok)
+
+	expiring := NewKeyringCache(0)
+	expiring.add(keyring.name, keyring)
+	_, ok = expiring.get(keyring.name)
+	assert.False(t, ok)
+}
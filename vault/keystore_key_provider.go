@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Keys wrapped by KeystoreKeyProvider are formatted as "ks1:<base64 payload>".
+const keystoreWrapPrefix = "ks1:"
+
+const keystoreKDF = "argon2id"
+
+// Default argon2id parameters for new keystores, following the recommendations of RFC 9106.
+const (
+	keystoreArgonTime    = 3
+	keystoreArgonMemory  = 64 * 1024
+	keystoreArgonThreads = 4
+)
+
+// keystoreFile is the on-disk format of a keystore. The master key is encrypted with a key
+// derived from the passphrase, and the KDF parameters are kept so they can be raised later.
+type keystoreFile struct {
+	KDF          string `json:"kdf"`
+	Salt         string `json:"salt"`
+	Time         uint32 `json:"time"`
+	Memory       uint32 `json:"memory"`
+	Threads      uint8  `json:"threads"`
+	EncryptedKey string `json:"encrypted_key"`
+}
+
+// KeystoreKeyProvider wraps keys with a master key kept in an encrypted keystore file, which
+// is unlocked with a passphrase. It stands in for an HSM when none is available.
+type KeystoreKeyProvider struct {
+	aead cipher.AEAD
+}
+
+// CreateKeystore writes a new keystore holding a random master key to path, failing if the
+// file already exists.
+func CreateKeystore(path string, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("keystore passphrase cannot be empty")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	masterKey := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		return err
+	}
+
+	ks := keystoreFile{
+		KDF:     keystoreKDF,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Time:    keystoreArgonTime,
+		Memory:  keystoreArgonMemory,
+		Threads: keystoreArgonThreads,
+	}
+	aead, err := ks.passphraseAEAD(passphrase)
+	if err != nil {
+		return err
+	}
+	encryptedKey, err := sealGCM(aead, masterKey)
+	if err != nil {
+		return err
+	}
+	ks.EncryptedKey = base64.StdEncoding.EncodeToString(encryptedKey)
+
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// NewKeystoreKeyProvider unlocks the keystore at path with passphrase.
+func NewKeystoreKeyProvider(path string, passphrase string) (*KeystoreKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks keystoreFile
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, err
+	}
+	if ks.KDF != keystoreKDF {
+		return nil, &NotSupportedError{Msg: "unsupported keystore kdf " + ks.KDF}
+	}
+
+	aead, err := ks.passphraseAEAD(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(ks.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := openGCM(aead, encryptedKey)
+	if err != nil {
+		return nil, errors.New("failed to unlock keystore: invalid passphrase")
+	}
+
+	masterAEAD, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &KeystoreKeyProvider{masterAEAD}, nil
+}
+
+func (ks keystoreFile) passphraseAEAD(passphrase string) (cipher.AEAD, error) {
+	salt, err := base64.StdEncoding.DecodeString(ks.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(argon2.IDKey([]byte(passphrase), salt, ks.Time, ks.Memory, ks.Threads, 32))
+}
+
+func (p *KeystoreKeyProvider) Wrap(ctx context.Context, key []byte) (string, error) {
+	sealed, err := sealGCM(p.aead, key)
+	if err != nil {
+		return "", err
+	}
+	return keystoreWrapPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (p *KeystoreKeyProvider) Unwrap(ctx context.Context, wrappedKey string) ([]byte, error) {
+	if !strings.HasPrefix(wrappedKey, keystoreWrapPrefix) {
+		return nil, errors.New("key was not wrapped by this provider")
+	}
+	data, err := base64.StdEncoding.DecodeString(wrappedKey[len(keystoreWrapPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	return openGCM(p.aead, data)
+}
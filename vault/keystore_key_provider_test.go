@@ -0,0 +1,39 @@
+package vault
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeystoreKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	assert.NoError(t, CreateKeystore(path, "correct horse battery staple"))
+
+	t.Run("can wrap and unwrap", func(t *testing.T) {
+		p, err := NewKeystoreKeyProvider(path, "correct horse battery staple")
+		assert.NoError(t, err)
+
+		wrapped, err := p.Wrap(ctx, key)
+		assert.NoError(t, err)
+
+		// The master key survives reopening the keystore
+		reopened, _ := NewKeystoreKeyProvider(path, "correct horse battery staple")
+		unwrapped, err := reopened.Unwrap(ctx, wrapped)
+		assert.NoError(t, err)
+		assert.Equal(t, key, unwrapped)
+	})
+
+	t.Run("cannot unlock with the wrong passphrase", func(t *testing.T) {
+		_, err := NewKeystoreKeyProvider(path, "wrong passphrase")
+		assert.Error(t, err)
+	})
+
+	t.Run("does not overwrite an existing keystore", func(t *testing.T) {
+		assert.Error(t, CreateKeystore(path, "another passphrase"))
+	})
+}
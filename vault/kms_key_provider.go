@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type kmsWrapRequest struct {
+	Plaintext string `json:"plaintext"`
+}
+
+type kmsWrapResponse struct {
+	Ciphertext string `json:"ciphertext"`
+}
+
+type kmsUnwrapRequest struct {
+	Ciphertext string `json:"ciphertext"`
+}
+
+type kmsUnwrapResponse struct {
+	Plaintext string `json:"plaintext"`
+}
+
+// KMSKeyProvider wraps keys with a key held by an external key management service. Keys are
+// sent base64 encoded to POST <url>/v1/keys/<key id>/wrap and /unwrap; the key encryption key
+// never leaves the service.
+type KMSKeyProvider struct {
+	url    string
+	keyId  string
+	token  string
+	client *http.Client
+}
+
+func NewKMSKeyProvider(kmsUrl string, keyId string, token string) (*KMSKeyProvider, error) {
+	if _, err := url.ParseRequestURI(kmsUrl); err != nil {
+		return nil, fmt.Errorf("invalid kms url: %w", err)
+	}
+	if keyId == "" {
+		return nil, fmt.Errorf("kms key id cannot be empty")
+	}
+	return &KMSKeyProvider{
+		url:    strings.TrimSuffix(kmsUrl, "/"),
+		keyId:  keyId,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *KMSKeyProvider) Wrap(ctx context.Context, key []byte) (string, error) {
+	var response kmsWrapResponse
+	err := p.call(ctx, "wrap", kmsWrapRequest{base64.StdEncoding.EncodeToString(key)}, &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Ciphertext, nil
+}
+
+func (p *KMSKeyProvider) Unwrap(ctx context.Context, wrappedKey string) ([]byte, error) {
+	var response kmsUnwrapResponse
+	if err := p.call(ctx, "unwrap", kmsUnwrapRequest{wrappedKey}, &response); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(response.Plaintext)
+}
+
+func (p *KMSKeyProvider) call(ctx context.Context, operation string, payload interface{}, target interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/v1/keys/%s/%s", p.url, url.PathEscape(p.keyId), operation)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s failed: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms %s failed with status %d", operation, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
@@ -0,0 +1,140 @@
+package vault
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKMSKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	server := httptest.NewServer(newLocalKMS("token"))
+	defer server.Close()
+
+	t.Run("can wrap and unwrap", func(t *testing.T) {
+		p, err := NewKMSKeyProvider(server.URL, "vault", "token")
+		assert.NoError(t, err)
+
+		wrapped, err := p.Wrap(ctx, key)
+		assert.NoError(t, err)
+		unwrapped, err := p.Unwrap(ctx, wrapped)
+		assert.NoError(t, err)
+		assert.Equal(t, key, unwrapped)
+	})
+
+	t.Run("cannot unwrap with another key", func(t *testing.T) {
+		p, _ := NewKMSKeyProvider(server.URL, "vault", "token")
+		other, _ := NewKMSKeyProvider(server.URL, "other", "token")
+
+		wrapped, _ := other.Wrap(ctx, key)
+		_, err := p.Unwrap(ctx, wrapped)
+		assert.Error(t, err)
+	})
+
+	t.Run("fails without a valid token", func(t *testing.T) {
+		p, _ := NewKMSKeyProvider(server.URL, "vault", "wrong")
+		_, err := p.Wrap(ctx, key)
+		assert.Error(t, err)
+	})
+}
+
+// localKMS is an in-memory key management service speaking the protocol of KMSKeyProvider.
+// Its keys are generated on first use.
+type localKMS struct {
+	token string
+	mu    sync.Mutex
+	keys  map[string]cipher.AEAD
+}
+
+func newLocalKMS(token string) *localKMS {
+	return &localKMS{token: token, keys: map[string]cipher.AEAD{}}
+}
+
+func (kms *localKMS) key(keyId string) (cipher.AEAD, error) {
+	kms.mu.Lock()
+	defer kms.mu.Unlock()
+	if aead, ok := kms.keys[keyId]; ok {
+		return aead, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	kms.keys[keyId] = aead
+	return aead, nil
+}
+
+func (kms *localKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if kms.token != "" && r.Header.Get("Authorization") != "Bearer "+kms.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/keys/"), "/")
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/v1/keys/") || len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	aead, err := kms.key(parts[0])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response interface{}
+	switch parts[1] {
+	case "wrap":
+		var request kmsWrapRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		key, err := base64.StdEncoding.DecodeString(request.Plaintext)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sealed, err := sealGCM(aead, key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response = kmsWrapResponse{base64.StdEncoding.EncodeToString(sealed)}
+	case "unwrap":
+		var request kmsUnwrapRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, err := base64.StdEncoding.DecodeString(request.Ciphertext)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		key, err := openGCM(aead, data)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response = kmsUnwrapResponse{base64.StdEncoding.EncodeToString(key)}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
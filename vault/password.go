@@ -0,0 +1,205 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, hash string) (bool, error)
+	// NeedsRehash reports whether a hash was computed with different parameters than the
+	// hasher's, so it can be upgraded the next time the password is known.
+	NeedsRehash(hash string) bool
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Default argon2id cost, following the recommendations of RFC 9106.
+const (
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+)
+
+const (
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+)
+
+// Upper bounds on the argon2id cost, so that a hash can't make a login loop for minutes or
+// allocate more memory than a server has.
+const (
+	maxArgon2Time   = 64
+	maxArgon2Memory = 4 * 1024 * 1024
+)
+
+// Argon2Hasher hashes passwords with argon2id. Hashes are encoded in the PHC string format,
+// e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, so they carry the cost they were
+// computed with.
+type Argon2Hasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// NewArgon2Hasher creates a hasher with the given number of iterations, memory in KiB and
+// degree of parallelism.
+func NewArgon2Hasher(time uint32, memory uint32, threads uint8) (*Argon2Hasher, error) {
+	if !validArgon2Params(time, memory, threads) {
+		return nil, &ValueError{Msg: "invalid argon2 parameters"}
+	}
+	return &Argon2Hasher{time, memory, threads}, nil
+}
+
+func validArgon2Params(time uint32, memory uint32, threads uint8) bool {
+	return time >= 1 && time <= maxArgon2Time &&
+		threads >= 1 &&
+		memory >= 8*uint32(threads) && memory <= maxArgon2Memory
+}
+
+func (h *Argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, argon2KeySize)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.memory,
+		h.time,
+		h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+func (h *Argon2Hasher) Verify(password string, encodedHash string) (bool, error) {
+	params, salt, hash, err := parseArgon2Hash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, computed) == 1, nil
+}
+
+func (h *Argon2Hasher) NeedsRehash(encodedHash string) bool {
+	params, _, _, err := parseArgon2Hash(encodedHash)
+	return err != nil || *params != *h
+}
+
+func parseArgon2Hash(encodedHash string) (*Argon2Hasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, hash
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("unsupported argon2 version")
+	}
+	params := &Argon2Hasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	if !validArgon2Params(params.time, params.memory, params.threads) {
+		return nil, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(hash) == 0 {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+	return params, salt, hash, nil
+}
+
+func isPasswordHash(password string) bool {
+	return strings.HasPrefix(password, argon2idPrefix)
+}
+
+// passwordHasher returns the configured hasher, defaulting to argon2id with the default cost.
+func (vault Vault) passwordHasher() PasswordHasher {
+	if vault.Hasher != nil {
+		return vault.Hasher
+	}
+	return &Argon2Hasher{DefaultArgon2Time, DefaultArgon2Memory, DefaultArgon2Threads}
+}
+
+// Maximum number of verified credentials kept by a LoginCache. Expired entries are evicted
+// when it's full, and all of them if none has expired.
+const loginCacheSize = 10000
+
+// LoginCache remembers credentials that were verified recently, so that principals
+// authenticating on every request don't pay for a password hash each time. Entries are keyed
+// by an HMAC of the username, stored hash and password under a key that only lives in memory,
+// so the cache holds no passwords and changing a password invalidates its entries.
+type LoginCache struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	key      []byte
+	verified map[string]time.Time
+}
+
+func NewLoginCache(ttl time.Duration) (*LoginCache, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &LoginCache{ttl: ttl, key: key, verified: map[string]time.Time{}}, nil
+}
+
+func (c *LoginCache) entry(username, hash, password string) string {
+	mac := hmac.New(sha256.New, c.key)
+	for _, part := range []string{username, hash, password} {
+		_ = binary.Write(mac, binary.BigEndian, uint32(len(part)))
+		mac.Write([]byte(part))
+	}
+	return string(mac.Sum(nil))
+}
+
+func (c *LoginCache) contains(username, hash, password string) bool {
+	entry := c.entry(username, hash, password)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt, ok := c.verified[entry]
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (c *LoginCache) add(username, hash, password string) {
+	entry := c.entry(username, hash, password)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.verified) >= loginCacheSize {
+		for cached, expiresAt := range c.verified {
+			if !now.Before(expiresAt) {
+				delete(c.verified, cached)
+			}
+		}
+		if len(c.verified) >= loginCacheSize {
+			c.verified = map[string]time.Time{}
+		}
+	}
+	c.verified[entry] = now.Add(c.ttl)
+}
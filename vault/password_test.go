@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgon2Hasher(t *testing.T) {
+	hasher, err := NewArgon2Hasher(1, 8*1024, 1)
+	assert.NoError(t, err)
+
+	t.Run("can hash and verify", func(t *testing.T) {
+		hash, err := hasher.Hash("hunter2")
+		assert.NoError(t, err)
+		assert.True(t, isPasswordHash(hash))
+		assert.NotContains(t, hash, "hunter2")
+
+		ok, err := hasher.Verify("hunter2", hash)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = hasher.Verify("hunter3", hash)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("identical passwords produce different hashes", func(t *testing.T) {
+		first, _ := hasher.Hash("hunter2")
+		second, _ := hasher.Hash("hunter2")
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("hashes with another cost need rehashing", func(t *testing.T) {
+		hash, _ := hasher.Hash("hunter2")
+		assert.False(t, hasher.NeedsRehash(hash))
+
+		stronger, _ := NewArgon2Hasher(2, 8*1024, 1)
+		assert.True(t, stronger.NeedsRehash(hash))
+
+		// Hashes remain verifiable after the cost changes
+		ok, err := stronger.Verify("hunter2", hash)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("rejects malformed hashes", func(t *testing.T) {
+		_, err := hasher.Verify("hunter2", "$argon2id$v=19$m=8192,t=1$salt$hash")
+		assert.Error(t, err)
+		assert.True(t, hasher.NeedsRehash("not a hash"))
+
+		for _, cost := range []string{"m=8192,t=0,p=1", "m=8192,t=1,p=0", "m=4294967295,t=1,p=1", "m=8192,t=1000000,p=1"} {
+			_, err := hasher.Verify("hunter2", "$argon2id$v=19$"+cost+"$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA")
+			assert.Error(t, err, cost)
+		}
+		_, err = hasher.Verify("hunter2", "$argon2id$v=19$m=8192,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$")
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		_, err := NewArgon2Hasher(0, 8*1024, 1)
+		assert.Error(t, err)
+		_, err = NewArgon2Hasher(1, maxArgon2Memory+1, 1)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoginCache(t *testing.T) {
+	cache, err := NewLoginCache(time.Minute)
+	assert.NoError(t, err)
+
+	cache.add("admin", "$argon2id$hash", "hunter2")
+	assert.True(t, cache.contains("admin", "$argon2id$hash", "hunter2"))
+	assert.False(t, cache.contains("admin", "$argon2id$hash", "hunter3"))
+	assert.False(t, cache.contains("root", "$argon2id$hash", "hunter2"))
+	// Changing the password invalidates the cached credentials
+	assert.False(t, cache.contains("admin", "$argon2id$other", "hunter2"))
+	// Fields can't be shifted into one another
+	cache.add("ad", "min", "hunter2")
+	assert.False(t, cache.contains("adm", "in", "hunter2"))
+
+	expired, _ := NewLoginCache(-time.Minute)
+	expired.add("admin", "$argon2id$hash", "hunter2")
+	assert.False(t, expired.contains("admin", "$argon2id$hash", "hunter2"))
+}
@@ -1,10 +1,14 @@
 package vault
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/mail"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nyaruka/phonenumbers"
@@ -18,14 +22,47 @@ const (
 	StringType           PTypeName = "string"
 	EmailType            PTypeName = "email"
 	CreditCardNumberType PTypeName = "cc_number"
+	CreditCardCVVType    PTypeName = "cc_cvv"
+	CreditCardExpiryType PTypeName = "cc_expiry"
 	RegexType            PTypeName = "regex"
+	IBANType             PTypeName = "iban"
+	BICType              PTypeName = "bic"
+	ABARoutingType       PTypeName = "aba_routing"
+	SortCodeType         PTypeName = "sort_code"
+	AccountNumberType    PTypeName = "account_number"
+	SSNType              PTypeName = "ssn"
+	NINOType             PTypeName = "nino"
+	PassportNumberType   PTypeName = "passport_number"
+	AddressType          PTypeName = "address"
 	IntegerType          PTypeName = "integer"
 	DateType             PTypeName = "date"
+	JSONType             PTypeName = "json"
+	FileType             PTypeName = "file"
 )
 
+// Every ptype supports the plain and masked formats, the other formats are registered with
+// the ptype's definition.
 const (
 	MASKED_FORMAT = "masked"
 	PLAIN_FORMAT  = "plain"
+
+	LAST4_FORMAT           = "last4"
+	BIN_FORMAT             = "bin"
+	BRAND_FORMAT           = "brand"
+	COUNTRY_FORMAT         = "country"
+	CITY_FORMAT            = "city"
+	POSTCODE_PREFIX_FORMAT = "postcode_prefix"
+	E164_FORMAT            = "e164"
+	NATIONAL_FORMAT        = "national"
+	INTERNATIONAL_FORMAT   = "international"
+	COUNTRY_CODE_FORMAT    = "country_code"
+	DOMAIN_FORMAT          = "domain"
+	HASH_FORMAT            = "hash"
+	YEAR_FORMAT            = "year"
+	MONTH_YEAR_FORMAT      = "month_year"
+	AGE_FORMAT             = "age"
+	INITIALS_FORMAT        = "initials"
+	FIRST_FORMAT           = "first"
 )
 
 type PType interface {
@@ -35,12 +72,18 @@ type PType interface {
 	Validate() error
 }
 
+// WriteValidator is implemented by ptypes with rules that only apply when a value is written,
+// such as card expiry dates that must not be in the past, so stored values remain readable.
+type WriteValidator interface {
+	ValidateWrite() error
+}
+
 type String struct {
 	val string
 }
 
 func (s String) Get(format string) (string, error) {
-	return getFormat(s, format)
+	return GetFormat(s, StringType, format)
 }
 
 func (s String) GetPlain() string {
@@ -60,7 +103,7 @@ type Name struct {
 }
 
 func (n Name) Get(format string) (string, error) {
-	return getFormat(n, format)
+	return GetFormat(n, NameType, format)
 }
 
 func (n Name) GetPlain() string {
@@ -68,11 +111,12 @@ func (n Name) GetPlain() string {
 }
 
 func (n Name) GetMasked() string {
-	names := strings.Split(n.val, " ")
+	names := strings.Fields(n.val)
 	maskedNames := []string{}
 
 	for _, name := range names {
-		maskedNames = append(maskedNames, string(name[0])+allStars(name[1:]))
+		runes := []rune(name)
+		maskedNames = append(maskedNames, string(runes[0])+strings.Repeat("*", len(runes)-1))
 	}
 	return strings.Join(maskedNames, " ")
 }
@@ -86,7 +130,7 @@ type PhoneNumber struct {
 }
 
 func (pn PhoneNumber) Get(format string) (string, error) {
-	return getFormat(pn, format)
+	return GetFormat(pn, PhoneNumberType, format)
 }
 
 func (pn PhoneNumber) GetPlain() string {
@@ -115,7 +159,7 @@ type Email struct {
 }
 
 func (em Email) Get(format string) (string, error) {
-	return getFormat(em, format)
+	return GetFormat(em, EmailType, format)
 }
 
 func (em Email) GetPlain() string {
@@ -137,7 +181,22 @@ type CreditCardNumber struct {
 }
 
 func (c CreditCardNumber) Get(format string) (string, error) {
-	return getFormat(c, format)
+	return GetFormat(c, CreditCardNumberType, format)
+}
+
+// Brand returns the card network of the number, detected from its leading digits.
+func (c CreditCardNumber) Brand() string {
+	prefix, _ := strconv.Atoi(c.cardNumber[:4])
+	switch {
+	case prefix/1000 == 4:
+		return "Visa"
+	case prefix/100 >= 51 && prefix/100 <= 55, prefix >= 2221 && prefix <= 2720:
+		return "Mastercard"
+	case prefix/100 == 34, prefix/100 == 37:
+		return "Amex"
+	default:
+		return "Unknown"
+	}
 }
 
 func (c CreditCardNumber) GetPlain() string {
@@ -145,7 +204,10 @@ func (c CreditCardNumber) GetPlain() string {
 }
 
 func (c CreditCardNumber) GetMasked() string {
-	return strings.Repeat("*", len(c.cardNumber)-4) + c.cardNumber[len(c.cardNumber)-4:]
+	if len(c.cardNumber) <= 4 {
+		return allStars(c.cardNumber)
+	}
+	return allStars(c.cardNumber[:len(c.cardNumber)-4]) + c.cardNumber[len(c.cardNumber)-4:]
 }
 
 func (c CreditCardNumber) Validate() error {
@@ -175,12 +237,447 @@ func (c CreditCardNumber) Validate() error {
 	return nil
 }
 
+type CreditCardCVV struct {
+	val string
+}
+
+func (c CreditCardCVV) Get(format string) (string, error) {
+	return GetFormat(c, CreditCardCVVType, format)
+}
+
+func (c CreditCardCVV) GetPlain() string {
+	return c.val
+}
+
+func (c CreditCardCVV) GetMasked() string {
+	return allStars(c.val)
+}
+
+func (c CreditCardCVV) Validate() error {
+	if len(c.val) < 3 || len(c.val) > 4 || !allDigits(c.val) {
+		return &ValueError{Msg: "Invalid card security code, must be 3 or 4 digits"}
+	}
+	return nil
+}
+
+// CreditCardExpiry is the expiry date of a card, formatted as MM/YY. Cards expire at the end
+// of the month.
+type CreditCardExpiry struct {
+	val time.Time
+}
+
+func (c CreditCardExpiry) Get(format string) (string, error) {
+	return GetFormat(c, CreditCardExpiryType, format)
+}
+
+func (c CreditCardExpiry) GetPlain() string {
+	return c.val.Format("01/06")
+}
+
+func (c CreditCardExpiry) GetMasked() string {
+	return "**/**"
+}
+
+func (c CreditCardExpiry) Validate() error {
+	return nil
+}
+
+func (c CreditCardExpiry) ValidateWrite() error {
+	if !time.Now().Before(c.val.AddDate(0, 1, 0)) {
+		return &ValueError{Msg: "Invalid card expiry date, must not be in the past"}
+	}
+	return nil
+}
+
+// ibanLengths is the length of the IBANs of each country, from the SWIFT IBAN registry.
+var ibanLengths = map[string]int{
+	"AD": 24, "AE": 23, "AL": 28, "AT": 20, "AZ": 28, "BA": 20, "BE": 16, "BG": 22, "BH": 22,
+	"BI": 27, "BR": 29, "BY": 28, "CH": 21, "CR": 22, "CY": 28, "CZ": 24, "DE": 22, "DJ": 27,
+	"DK": 18, "DO": 28, "EE": 20, "EG": 29, "ES": 24, "FI": 18, "FK": 18, "FO": 18, "FR": 27,
+	"GB": 22, "GE": 22, "GI": 23, "GL": 18, "GR": 27, "GT": 28, "HR": 21, "HU": 28, "IE": 22,
+	"IL": 23, "IQ": 23, "IS": 26, "IT": 27, "JO": 30, "KW": 30, "KZ": 20, "LB": 28, "LC": 32,
+	"LI": 21, "LT": 20, "LU": 20, "LV": 21, "LY": 25, "MC": 27, "MD": 24, "ME": 22, "MK": 19,
+	"MN": 20, "MR": 27, "MT": 31, "MU": 30, "NI": 28, "NL": 18, "NO": 15, "OM": 23, "PK": 24,
+	"PL": 28, "PS": 29, "PT": 25, "QA": 29, "RO": 24, "RS": 22, "RU": 33, "SA": 24, "SC": 31,
+	"SD": 18, "SE": 24, "SI": 19, "SK": 24, "SM": 27, "SO": 23, "ST": 25, "SV": 28, "TL": 23,
+	"TN": 24, "TR": 26, "UA": 29, "VA": 22, "VG": 24, "XK": 20, "YE": 30,
+}
+
+// IBAN is an international bank account number. It is stored without spaces.
+type IBAN struct {
+	val string
+}
+
+func (i IBAN) Get(format string) (string, error) {
+	return GetFormat(i, IBANType, format)
+}
+
+func (i IBAN) GetPlain() string {
+	return i.val
+}
+
+func (i IBAN) GetMasked() string {
+	return i.val[:2] + allStars(i.val[2:len(i.val)-4]) + i.val[len(i.val)-4:]
+}
+
+func (i IBAN) Validate() error {
+	if len(i.val) < 4 {
+		return &ValueError{Msg: "Invalid IBAN, too short"}
+	}
+	length, ok := ibanLengths[i.val[:2]]
+	if !ok {
+		return &ValueError{Msg: fmt.Sprintf("Invalid IBAN, country %s is not supported", i.val[:2])}
+	}
+	if len(i.val) != length {
+		return &ValueError{Msg: fmt.Sprintf("Invalid IBAN, must be %d characters for country %s", length, i.val[:2])}
+	}
+
+	// The number formed by moving the country code and check digits to the end, with letters
+	// replaced by 10 to 35, must be 1 mod 97.
+	remainder := 0
+	for _, char := range i.val[4:] + i.val[:4] {
+		switch {
+		case char >= '0' && char <= '9':
+			remainder = (remainder*10 + int(char-'0')) % 97
+		case char >= 'A' && char <= 'Z':
+			remainder = (remainder*100 + int(char-'A') + 10) % 97
+		default:
+			return &ValueError{Msg: "Invalid IBAN, must only contain letters and digits"}
+		}
+	}
+	if remainder != 1 {
+		return &ValueError{Msg: "Invalid IBAN, failed checksum"}
+	}
+	return nil
+}
+
+// bicPattern matches business identifier codes: a bank code, country code, location code and
+// optional branch code.
+var bicPattern = regexp.MustCompile(`^[A-Z]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+
+// BIC is a business identifier code (SWIFT code) of a bank.
+type BIC struct {
+	val string
+}
+
+func (b BIC) Get(format string) (string, error) {
+	return GetFormat(b, BICType, format)
+}
+
+func (b BIC) GetPlain() string {
+	return b.val
+}
+
+func (b BIC) GetMasked() string {
+	return allStars(b.val[:4]) + b.val[4:6] + allStars(b.val[6:])
+}
+
+func (b BIC) Validate() error {
+	if !bicPattern.MatchString(b.val) {
+		return &ValueError{Msg: "Invalid BIC, must be 8 or 11 characters"}
+	}
+	return nil
+}
+
+// ABARouting is a US bank routing number.
+type ABARouting struct {
+	val string
+}
+
+func (a ABARouting) Get(format string) (string, error) {
+	return GetFormat(a, ABARoutingType, format)
+}
+
+func (a ABARouting) GetPlain() string {
+	return a.val
+}
+
+func (a ABARouting) GetMasked() string {
+	return allStars(a.val[:len(a.val)-4]) + a.val[len(a.val)-4:]
+}
+
+func (a ABARouting) Validate() error {
+	if len(a.val) != 9 || !allDigits(a.val) {
+		return &ValueError{Msg: "Invalid routing number, must be 9 digits"}
+	}
+	weights := []int{3, 7, 1}
+	sum := 0
+	for i, digit := range a.val {
+		sum += int(digit-'0') * weights[i%3]
+	}
+	if sum%10 != 0 {
+		return &ValueError{Msg: "Invalid routing number, failed checksum"}
+	}
+	return nil
+}
+
+// SortCode is a UK bank sort code. It is stored as six digits and formatted as 12-34-56.
+type SortCode struct {
+	val string
+}
+
+func (s SortCode) Get(format string) (string, error) {
+	return GetFormat(s, SortCodeType, format)
+}
+
+func (s SortCode) GetPlain() string {
+	return s.val[:2] + "-" + s.val[2:4] + "-" + s.val[4:]
+}
+
+func (s SortCode) GetMasked() string {
+	return "**-**-" + s.val[4:]
+}
+
+func (s SortCode) Validate() error {
+	if len(s.val) != 6 || !allDigits(s.val) {
+		return &ValueError{Msg: "Invalid sort code, must be 6 digits"}
+	}
+	return nil
+}
+
+// AccountNumber is a domestic bank account number.
+type AccountNumber struct {
+	val string
+}
+
+func (a AccountNumber) Get(format string) (string, error) {
+	return GetFormat(a, AccountNumberType, format)
+}
+
+func (a AccountNumber) GetPlain() string {
+	return a.val
+}
+
+func (a AccountNumber) GetMasked() string {
+	return allStars(a.val[:len(a.val)-4]) + a.val[len(a.val)-4:]
+}
+
+func (a AccountNumber) Validate() error {
+	if len(a.val) < 4 || len(a.val) > 17 || !allDigits(a.val) {
+		return &ValueError{Msg: "Invalid account number, must be between 4 and 17 digits"}
+	}
+	return nil
+}
+
+// SSN is a US social security number. It is stored as nine digits and formatted as
+// 123-45-6789.
+type SSN struct {
+	val string
+}
+
+func (s SSN) Get(format string) (string, error) {
+	return GetFormat(s, SSNType, format)
+}
+
+func (s SSN) GetPlain() string {
+	return s.val[:3] + "-" + s.val[3:5] + "-" + s.val[5:]
+}
+
+func (s SSN) GetMasked() string {
+	return "***-**-" + s.val[5:]
+}
+
+func (s SSN) Validate() error {
+	if len(s.val) != 9 || !allDigits(s.val) {
+		return &ValueError{Msg: "Invalid SSN, must be 9 digits"}
+	}
+	area, group, serial := s.val[:3], s.val[3:5], s.val[5:]
+	if area == "000" || area == "666" || area[0] == '9' {
+		return &ValueError{Msg: "Invalid SSN, area number is not assigned"}
+	}
+	if group == "00" || serial == "0000" {
+		return &ValueError{Msg: "Invalid SSN, group and serial numbers can't be zero"}
+	}
+	return nil
+}
+
+// ninoPattern matches UK National Insurance numbers: a prefix of two letters, some of which
+// are never issued, six digits and a suffix from A to D.
+var ninoPattern = regexp.MustCompile(`^[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z][0-9]{6}[A-D]$`)
+
+// ninoInvalidPrefixes are prefixes that are never issued although their letters are.
+var ninoInvalidPrefixes = map[string]bool{"BG": true, "GB": true, "KN": true, "NK": true, "NT": true, "TN": true, "ZZ": true}
+
+// NINO is a UK National Insurance number. It is stored without spaces.
+type NINO struct {
+	val string
+}
+
+func (n NINO) Get(format string) (string, error) {
+	return GetFormat(n, NINOType, format)
+}
+
+func (n NINO) GetPlain() string {
+	return n.val
+}
+
+func (n NINO) GetMasked() string {
+	return allStars(n.val[:5]) + n.val[5:]
+}
+
+func (n NINO) Validate() error {
+	if !ninoPattern.MatchString(n.val) || ninoInvalidPrefixes[n.val[:2]] {
+		return &ValueError{Msg: "Invalid National Insurance number"}
+	}
+	return nil
+}
+
+// PassportNumber is the number of a passport, which is 6 to 9 letters and digits in
+// machine-readable passports.
+type PassportNumber struct {
+	val string
+}
+
+func (p PassportNumber) Get(format string) (string, error) {
+	return GetFormat(p, PassportNumberType, format)
+}
+
+func (p PassportNumber) GetPlain() string {
+	return p.val
+}
+
+func (p PassportNumber) GetMasked() string {
+	return allStars(p.val[:len(p.val)-4]) + p.val[len(p.val)-4:]
+}
+
+func (p PassportNumber) Validate() error {
+	if len(p.val) < 6 || len(p.val) > 9 {
+		return &ValueError{Msg: "Invalid passport number, must be between 6 and 9 characters"}
+	}
+	for _, char := range p.val {
+		if (char < 'A' || char > 'Z') && (char < '0' || char > '9') {
+			return &ValueError{Msg: "Invalid passport number, must only contain letters and digits"}
+		}
+	}
+	return nil
+}
+
+// Address is a structured postal address, given as a JSON object. Its plain format is the
+// normalized JSON object. Addresses written as free text, like those stored before addresses
+// were structured, are kept as their lines with an unknown city and country.
+type Address struct {
+	Lines    []string `json:"lines"`
+	City     string   `json:"city"`
+	Region   string   `json:"region,omitempty"`
+	Postcode string   `json:"postcode,omitempty"`
+	Country  string   `json:"country"` // ISO 3166-1 alpha-2 code
+
+	unstructured bool
+}
+
+func (a Address) Get(format string) (string, error) {
+	return GetFormat(a, AddressType, format)
+}
+
+// postcodePrefix returns the part of the postcode identifying an area rather than a street:
+// the outward code of postcodes with a space, such as UK postcodes, otherwise the first three
+// characters, such as US ZIP3 codes.
+func (a Address) postcodePrefix() string {
+	if prefix, _, found := strings.Cut(a.Postcode, " "); found {
+		return prefix
+	}
+	if len(a.Postcode) > 3 {
+		return a.Postcode[:3]
+	}
+	return a.Postcode
+}
+
+func (a Address) GetPlain() string {
+	if a.unstructured {
+		return strings.Join(a.Lines, "\n")
+	}
+	return a.json()
+}
+
+func (a Address) GetMasked() string {
+	if a.unstructured {
+		lines := make([]string, len(a.Lines))
+		for i, line := range a.Lines {
+			lines[i] = allStars(line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	masked := a
+	masked.Lines = make([]string, len(a.Lines))
+	for i, line := range a.Lines {
+		masked.Lines[i] = allStars(line)
+	}
+	prefix := a.postcodePrefix()
+	masked.Postcode = prefix + strings.Map(func(char rune) rune {
+		if char == ' ' {
+			return char
+		}
+		return '*'
+	}, a.Postcode[len(prefix):])
+	return masked.json()
+}
+
+func (a Address) json() string {
+	value, _ := json.Marshal(a)
+	return string(value)
+}
+
+func (a Address) Validate() error {
+	if len(a.Lines) == 0 {
+		return &ValueError{Msg: "Invalid address, must have at least one line"}
+	}
+	if a.unstructured {
+		return nil
+	}
+	if a.City == "" {
+		return &ValueError{Msg: "Invalid address, city is required"}
+	}
+	if len(a.Country) != 2 || a.Country[0] < 'A' || a.Country[0] > 'Z' || a.Country[1] < 'A' || a.Country[1] > 'Z' {
+		return &ValueError{Msg: "Invalid address, country must be an ISO 3166-1 alpha-2 code"}
+	}
+	return nil
+}
+
+// parseAddress parses and normalizes the JSON object of an address: whitespace is collapsed,
+// empty lines are dropped and the postcode and country are upper cased. Values that aren't
+// JSON objects are free text addresses, whose lines are normalized the same way.
+func parseAddress(value string) (Address, error) {
+	var address Address
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		address.Lines = normalizeLines(strings.Split(value, "\n"))
+		address.unstructured = true
+		return address, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&address); err != nil {
+		return address, &ValueError{Msg: fmt.Sprintf("Invalid address, must be a JSON object: %s", err)}
+	}
+
+	address.Lines = normalizeLines(address.Lines)
+	address.City = normalizeSpaces(address.City)
+	address.Region = normalizeSpaces(address.Region)
+	address.Postcode = strings.ToUpper(normalizeSpaces(address.Postcode))
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	return address, nil
+}
+
+// normalizeLines collapses the whitespace of lines and drops the empty ones.
+func normalizeLines(lines []string) []string {
+	normalized := []string{}
+	for _, line := range lines {
+		if line = normalizeSpaces(line); line != "" {
+			normalized = append(normalized, line)
+		}
+	}
+	return normalized
+}
+
+func normalizeSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
 type Integer struct {
 	val int
 }
 
 func (i Integer) Get(format string) (string, error) {
-	return getFormat(i, format)
+	return GetFormat(i, IntegerType, format)
 }
 
 func (i Integer) GetPlain() string {
@@ -200,7 +697,7 @@ type Date struct {
 }
 
 func (d Date) Get(format string) (string, error) {
-	return getFormat(d, format)
+	return GetFormat(d, DateType, format)
 }
 
 func (d Date) GetPlain() string {
@@ -215,86 +712,145 @@ func (d Date) Validate() error {
 	return nil
 }
 
+// Regex is a value matching the pattern of its field. Its masked format is the mask template
+// of the field expanded with the pattern's capture groups, e.g. "**-$2" reveals group 2 only,
+// or all stars if the field has no mask.
+type Regex struct {
+	val     string
+	pattern *regexp.Regexp
+	mask    string
+}
+
+func (r Regex) Get(format string) (string, error) {
+	return GetFormat(r, RegexType, format)
+}
+
+func (r Regex) GetPlain() string {
+	return r.val
+}
+
+func (r Regex) GetMasked() string {
+	if r.mask == "" {
+		return allStars(r.val)
+	}
+	match := r.pattern.FindStringSubmatchIndex(r.val)
+	return string(r.pattern.ExpandString(nil, r.mask, r.val, match))
+}
+
+func (r Regex) Validate() error {
+	if !r.pattern.MatchString(r.val) {
+		return &ValueError{Msg: "value does not match the pattern of the field"}
+	}
+	return nil
+}
+
+// compiledPatterns holds the patterns of regex fields compiled by compilePattern, by pattern, so
+// that they are compiled once rather than for every value.
+var compiledPatterns sync.Map
+
+// compilePattern compiles the pattern of a regex field, which must match whole values.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := compiledPatterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, &ValueError{Msg: fmt.Sprintf("invalid pattern: %s", err)}
+	}
+	compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// maskReference matches the capture group references of a mask template: $1, ${1}, $name or
+// ${name}, and the escaped dollar $$.
+var maskReference = regexp.MustCompile(`\$(\$|\{(\w+)\}|(\w+))`)
+
+// validateMask checks that every capture group referenced by a mask template exists in pattern.
+// Group 0, the whole value, can't be referenced.
+func validateMask(pattern *regexp.Regexp, mask string) error {
+	for _, reference := range maskReference.FindAllStringSubmatch(mask, -1) {
+		if reference[1] == "$" {
+			continue
+		}
+		group := reference[2] + reference[3]
+		if index, err := strconv.Atoi(group); err == nil {
+			if index == 0 {
+				return &ValueError{Msg: "mask can't reference capture group 0, which is the whole value"}
+			}
+			if index <= pattern.NumSubexp() {
+				continue
+			}
+		} else if pattern.SubexpIndex(group) >= 0 {
+			continue
+		}
+		return &ValueError{Msg: fmt.Sprintf("mask references capture group %s which the pattern doesn't have", group)}
+	}
+	return nil
+}
+
+func allDigits(s string) bool {
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func allStars(s string) string {
 	return strings.Repeat("*", len(s))
 }
 
-func getFormat(p PType, format string) (string, error) {
+// lastN returns the last n characters of a value, or all of it if it's shorter.
+func lastN(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// GetFormat returns a value of a ptype in a format: one of the formats registered for the
+// ptype, or its plain or masked value. Ptypes implement PType.Get with it.
+func GetFormat(p PType, pType PTypeName, format string) (string, error) {
+	if pTypeFormat, ok := lookupPType(pType).Formats[format]; ok {
+		return pTypeFormat.Get(p)
+	}
 	switch format {
 	case PLAIN_FORMAT:
 		return p.GetPlain(), nil
 	case MASKED_FORMAT:
 		return p.GetMasked(), nil
-	default:
-		return "", &NotSupportedError{Msg: fmt.Sprintf("Format %s is not supported", format)}
 	}
+	return "", &NotSupportedError{Msg: fmt.Sprintf("Format %s is not supported by %s", format, pType)}
 }
 
-func GetPType(pType PTypeName, value string) (PType, error) {
-	switch pType {
-	case StringType:
-		newString := String{value}
-		if err := newString.Validate(); err != nil {
-			return nil, err
-		}
-		return newString, nil
-	case PhoneNumberType:
-		parsedPhoneNumber, err := phonenumbers.Parse(value, "")
+// GetFieldPType parses a value of a field. Unlike GetPType it supports ptypes that are
+// configured per field, such as the pattern of regex fields and the paths of json fields.
+func GetFieldPType(field Field, value string) (PType, error) {
+	switch PTypeName(field.Type) {
+	case RegexType:
+		pattern, err := compilePattern(field.Pattern)
 		if err != nil {
 			return nil, err
 		}
-		phoneNumber := PhoneNumber{parsedPhoneNumber}
-		if err := phoneNumber.Validate(); err != nil {
+		newRegex := Regex{value, pattern, field.Mask}
+		if err := newRegex.Validate(); err != nil {
 			return nil, err
 		}
-		return phoneNumber, nil
-	case NameType:
-		newName := Name{value}
-		if err := newName.Validate(); err != nil {
-			return nil, err
+		return newRegex, nil
+	case JSONType:
+		if document, ok := ParseJSONValue(value); ok {
+			value = document
 		}
-		return newName, nil
-	case EmailType:
-		emailAddress, err := mail.ParseAddress(value)
-		if err != nil {
+		newDocument := JSONDocument{value, field.Paths}
+		if err := newDocument.Validate(); err != nil {
 			return nil, err
 		}
-		email := Email{*emailAddress}
-		if err := email.Validate(); err != nil {
-			return nil, err
-		}
-		return email, nil
-	case CreditCardNumberType:
-		newCCNumber := CreditCardNumber{value}
-		if err := newCCNumber.Validate(); err != nil {
-			return nil, err
-		}
-		return newCCNumber, nil
-	case IntegerType:
-		intValue, err := strconv.Atoi(value)
-		if err != nil {
-			return nil, err
-		}
-		newInteger := Integer{intValue}
-		if err := newInteger.Validate(); err != nil {
-			return nil, err
-		}
-		return newInteger, nil
-	case DateType:
-		dateValue, err := time.Parse("2006-01-02", value)
-		if err != nil {
-			return nil, err
-		}
-		newDate := Date{dateValue}
-		if err := newDate.Validate(); err != nil {
-			return nil, err
-		}
-		return newDate, nil
+		return newDocument, nil
 	default:
-		newString := String{value}
-		if err := newString.Validate(); err != nil {
-			return nil, err
-		}
-		return newString, nil
+		return GetPType(PTypeName(field.Type), value)
 	}
 }
@@ -0,0 +1,138 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// PTypeFormat is a format values of a ptype can be returned in. Formats are requested as
+// <field>.<format>, both in the formats of GetRecord and in policy resources.
+type PTypeFormat struct {
+	Description string
+	Get         func(value PType) (string, error)
+}
+
+func newFormat(description string, get func(value PType) string) PTypeFormat {
+	return PTypeFormat{description, func(value PType) (string, error) { return get(value), nil }}
+}
+
+var (
+	plainFormat  = PTypeFormat{Description: "The value"}
+	maskedFormat = PTypeFormat{Description: "The value with its identifying parts masked"}
+	last4Format  = newFormat("The last 4 characters", func(value PType) string {
+		return lastN(value.GetPlain(), 4)
+	})
+)
+
+// builtinFormats are the formats of the built in ptypes, besides plain and masked.
+var builtinFormats = map[PTypeName]map[string]PTypeFormat{
+	StringType: {},
+	NameType: {
+		INITIALS_FORMAT: newFormat("The initials of each name, e.g. J.C.", func(value PType) string {
+			initials := ""
+			for _, name := range strings.Fields(value.GetPlain()) {
+				initials += string([]rune(name)[0]) + "."
+			}
+			return initials
+		}),
+		FIRST_FORMAT: newFormat("The first name", func(value PType) string {
+			names := strings.Fields(value.GetPlain())
+			if len(names) == 0 {
+				return ""
+			}
+			return names[0]
+		}),
+	},
+	PhoneNumberType: {
+		E164_FORMAT: newFormat("The number in E.164 notation, e.g. +447911123456", func(value PType) string {
+			return phonenumbers.Format(value.(PhoneNumber).val, phonenumbers.E164)
+		}),
+		NATIONAL_FORMAT: newFormat("The number in national notation, e.g. 07911 123456", func(value PType) string {
+			return phonenumbers.Format(value.(PhoneNumber).val, phonenumbers.NATIONAL)
+		}),
+		INTERNATIONAL_FORMAT: newFormat("The number in international notation, e.g. +44 7911 123456", func(value PType) string {
+			return phonenumbers.Format(value.(PhoneNumber).val, phonenumbers.INTERNATIONAL)
+		}),
+		COUNTRY_CODE_FORMAT: newFormat("The country calling code, e.g. 44", func(value PType) string {
+			return strconv.Itoa(int(value.(PhoneNumber).val.GetCountryCode()))
+		}),
+	},
+	EmailType: {
+		DOMAIN_FORMAT: newFormat("The domain of the address", func(value PType) string {
+			return strings.Split(value.GetPlain(), "@")[1]
+		}),
+		HASH_FORMAT: newFormat("The hex SHA-256 hash of the lower case address, as used for audience matching", func(value PType) string {
+			hash := sha256.Sum256([]byte(strings.ToLower(value.GetPlain())))
+			return hex.EncodeToString(hash[:])
+		}),
+	},
+	CreditCardNumberType: {
+		LAST4_FORMAT: last4Format,
+		BIN_FORMAT: newFormat("The bank identification number, the first 6 digits", func(value PType) string {
+			return value.GetPlain()[:6]
+		}),
+		BRAND_FORMAT: newFormat("The card network: Visa, Mastercard, Amex or Unknown", func(value PType) string {
+			return value.(CreditCardNumber).Brand()
+		}),
+	},
+	CreditCardCVVType:    {},
+	CreditCardExpiryType: {},
+	IBANType: {
+		COUNTRY_FORMAT: newFormat("The country code of the account", func(value PType) string {
+			return value.GetPlain()[:2]
+		}),
+	},
+	BICType: {
+		COUNTRY_FORMAT: newFormat("The country code of the bank", func(value PType) string {
+			return value.GetPlain()[4:6]
+		}),
+	},
+	ABARoutingType:    {},
+	SortCodeType:      {},
+	AccountNumberType: {},
+	SSNType:           {LAST4_FORMAT: last4Format},
+	NINOType:          {LAST4_FORMAT: last4Format},
+	PassportNumberType: {
+		LAST4_FORMAT: last4Format,
+	},
+	AddressType: {
+		COUNTRY_FORMAT: newFormat("The country code of the address", func(value PType) string {
+			return value.(Address).Country
+		}),
+		CITY_FORMAT: newFormat("The city of the address", func(value PType) string {
+			return value.(Address).City
+		}),
+		POSTCODE_PREFIX_FORMAT: newFormat("The area part of the postcode, e.g. SW1A for SW1A 2AA", func(value PType) string {
+			return value.(Address).postcodePrefix()
+		}),
+	},
+	IntegerType: {},
+	DateType: {
+		YEAR_FORMAT: newFormat("The year of the date", func(value PType) string {
+			return value.(Date).val.Format("2006")
+		}),
+		MONTH_YEAR_FORMAT: newFormat("The month and year of the date, e.g. 1970-01", func(value PType) string {
+			return value.(Date).val.Format("2006-01")
+		}),
+		AGE_FORMAT: newFormat("The number of whole years since the date", func(value PType) string {
+			return strconv.Itoa(age(value.(Date).val, time.Now()))
+		}),
+	},
+	RegexType: {},
+	JSONType:  {},
+	FileType:  {},
+}
+
+// age returns the number of whole years between a date and now.
+func age(date time.Time, now time.Time) int {
+	years := now.Year() - date.Year()
+	if now.Month() < date.Month() || (now.Month() == date.Month() && now.Day() < date.Day()) {
+		years--
+	}
+	return years
+}
@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestPhoneFormats(t *testing.T) {
+	pn, _ := GetPType(PhoneNumberType, "+44 07911 123456")
+
+	for format, expected := range map[string]string{
+		E164_FORMAT:          "+447911123456",
+		NATIONAL_FORMAT:      "07911 123456",
+		INTERNATIONAL_FORMAT: "+44 7911 123456",
+		COUNTRY_CODE_FORMAT:  "44",
+	} {
+		value, err := pn.Get(format)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, value, expected)
+	}
+}
+
+func TestEmailFormats(t *testing.T) {
+	email, _ := GetPType(EmailType, "John@Crawford.com")
+
+	domain, _ := email.Get(DOMAIN_FORMAT)
+	assert.Equal(t, domain, "Crawford.com")
+
+	hash, _ := email.Get(HASH_FORMAT)
+	lowerCase, _ := GetPType(EmailType, "john@crawford.com")
+	lowerCaseHash, _ := lowerCase.Get(HASH_FORMAT)
+	assert.Equal(t, hash, lowerCaseHash)
+	assert.Equal(t, hash, "d4f67e4ffc3a28415427e0fad19ce4a3944c23521345b93f0a0818fb8eb9265c")
+}
+
+func TestDateFormats(t *testing.T) {
+	date, _ := GetPType(DateType, "1990-07-15")
+
+	year, _ := date.Get(YEAR_FORMAT)
+	assert.Equal(t, year, "1990")
+	monthYear, _ := date.Get(MONTH_YEAR_FORMAT)
+	assert.Equal(t, monthYear, "1990-07")
+
+	birth := time.Date(1990, 7, 15, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, age(birth, time.Date(2020, 7, 14, 0, 0, 0, 0, time.UTC)), 29)
+	assert.Equal(t, age(birth, time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC)), 30)
+	assert.Equal(t, age(birth, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)), 30)
+}
+
+func TestNameFormats(t *testing.T) {
+	name, _ := GetPType(NameType, "John Fitzgerald Crawford")
+
+	initials, _ := name.Get(INITIALS_FORMAT)
+	assert.Equal(t, initials, "J.F.C.")
+	first, _ := name.Get(FIRST_FORMAT)
+	assert.Equal(t, first, "John")
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	name, _ := GetPType(NameType, "John Crawford")
+
+	_, err := name.Get(DOMAIN_FORMAT)
+	var notSupportedErr *NotSupportedError
+	assert.Equal(t, errors.As(err, &notSupportedErr), true)
+}
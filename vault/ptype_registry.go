@@ -0,0 +1,301 @@
+package vault
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// PTypeDefinition describes a ptype: how its values are parsed and the formats they can be
+// returned in. Modules embedding the vault register their own with RegisterPType.
+type PTypeDefinition struct {
+	Name        PTypeName
+	Description string
+	// Normalize rewrites a value to the form it's stored in before it's validated, e.g. by
+	// removing separators. Optional.
+	Normalize func(value string) string
+	// Validate checks a normalized value before it's parsed. Optional.
+	Validate func(value string) error
+	// New parses a normalized value. Optional, by default values are kept as is and masked
+	// entirely unless a masked format is registered.
+	New func(value string) (PType, error)
+	// Formats are the formats values can be returned in besides plain and masked. A masked
+	// format replaces the ptype's GetMasked.
+	Formats map[string]PTypeFormat
+}
+
+// PTypeInfo describes a ptype and the formats it supports.
+type PTypeInfo struct {
+	Name        PTypeName         `json:"name"`
+	Description string            `json:"description"`
+	Formats     map[string]string `json:"formats"` // Format name -> description
+}
+
+// Ptype and format names end up in policy resources as <field>.<format>.
+var pTypeNamePattern = regexp.MustCompile("^[a-z0-9_]{1,64}$")
+
+var (
+	registeredPTypesLock sync.RWMutex
+	registeredPTypes     = builtinPTypes()
+)
+
+func builtinPTypes() map[PTypeName]PTypeDefinition {
+	definitions := map[PTypeName]PTypeDefinition{
+		StringType: {
+			Description: "Any text, masked entirely",
+			New:         func(value string) (PType, error) { return String{value}, nil },
+		},
+		NameType: {
+			Description: "A person's name",
+			New:         func(value string) (PType, error) { return Name{value}, nil },
+		},
+		PhoneNumberType: {
+			Description: "A phone number in international notation",
+			New: func(value string) (PType, error) {
+				parsedPhoneNumber, err := phonenumbers.Parse(value, "")
+				if err != nil {
+					return nil, err
+				}
+				return PhoneNumber{parsedPhoneNumber}, nil
+			},
+		},
+		EmailType: {
+			Description: "An email address",
+			New: func(value string) (PType, error) {
+				emailAddress, err := mail.ParseAddress(value)
+				if err != nil {
+					return nil, err
+				}
+				return Email{*emailAddress}, nil
+			},
+		},
+		CreditCardNumberType: {
+			Description: "A payment card number",
+			New:         func(value string) (PType, error) { return CreditCardNumber{value}, nil },
+		},
+		CreditCardCVVType: {
+			Description: "A payment card security code",
+			New:         func(value string) (PType, error) { return CreditCardCVV{value}, nil },
+		},
+		CreditCardExpiryType: {
+			Description: "A payment card expiry date formatted as MM/YY",
+			New: func(value string) (PType, error) {
+				expiryValue, err := time.Parse("01/06", value)
+				if err != nil {
+					return nil, &ValueError{Msg: "Invalid card expiry date, must be formatted as MM/YY"}
+				}
+				return CreditCardExpiry{expiryValue}, nil
+			},
+		},
+		IBANType: {
+			Description: "An international bank account number",
+			Normalize:   func(value string) string { return strings.ToUpper(strings.ReplaceAll(value, " ", "")) },
+			New:         func(value string) (PType, error) { return IBAN{value}, nil },
+		},
+		BICType: {
+			Description: "A bank identifier code",
+			Normalize:   strings.ToUpper,
+			New:         func(value string) (PType, error) { return BIC{value}, nil },
+		},
+		ABARoutingType: {
+			Description: "An ABA routing number",
+			New:         func(value string) (PType, error) { return ABARouting{value}, nil },
+		},
+		SortCodeType: {
+			Description: "A UK bank sort code",
+			Normalize:   func(value string) string { return strings.ReplaceAll(value, "-", "") },
+			New:         func(value string) (PType, error) { return SortCode{value}, nil },
+		},
+		AccountNumberType: {
+			Description: "A bank account number",
+			Normalize:   func(value string) string { return strings.ReplaceAll(value, " ", "") },
+			New:         func(value string) (PType, error) { return AccountNumber{value}, nil },
+		},
+		SSNType: {
+			Description: "A US social security number",
+			Normalize:   strings.NewReplacer("-", "", " ", "").Replace,
+			New:         func(value string) (PType, error) { return SSN{value}, nil },
+		},
+		NINOType: {
+			Description: "A UK national insurance number",
+			Normalize:   func(value string) string { return strings.ToUpper(strings.ReplaceAll(value, " ", "")) },
+			New:         func(value string) (PType, error) { return NINO{value}, nil },
+		},
+		PassportNumberType: {
+			Description: "A passport number",
+			Normalize:   func(value string) string { return strings.ToUpper(strings.ReplaceAll(value, " ", "")) },
+			New:         func(value string) (PType, error) { return PassportNumber{value}, nil },
+		},
+		AddressType: {
+			Description: "A postal address, as a JSON object of lines, city, region, postcode and country, or as free text",
+			New: func(value string) (PType, error) {
+				return parseAddress(value)
+			},
+		},
+		IntegerType: {
+			Description: "An integer",
+			New: func(value string) (PType, error) {
+				intValue, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, err
+				}
+				return Integer{intValue}, nil
+			},
+		},
+		DateType: {
+			Description: "A date formatted as YYYY-MM-DD",
+			New: func(value string) (PType, error) {
+				dateValue, err := time.Parse("2006-01-02", value)
+				if err != nil {
+					return nil, err
+				}
+				return Date{dateValue}, nil
+			},
+		},
+		RegexType: {
+			Description: "Text matching the pattern of its field, masked with the field's mask template",
+			New: func(value string) (PType, error) {
+				return nil, &ValueError{Msg: "regex values can only be parsed with the pattern of their field"}
+			},
+		},
+		JSONType: {
+			Description: "A JSON object or array, whose sensitive paths are declared by its field",
+			New: func(value string) (PType, error) {
+				return JSONDocument{val: value}, nil
+			},
+		},
+		FileType: {
+			Description: "A file uploaded to /collections/{name}/records/{id}/files/{field}, returned as its name, content type and size",
+			New: func(value string) (PType, error) {
+				return parseFile(value)
+			},
+		},
+	}
+	for name, definition := range definitions {
+		definition.Name = name
+		definition.Formats = builtinFormats[name]
+		definitions[name] = definition
+	}
+	return definitions
+}
+
+// RegisterPType adds a ptype that collection fields can then be declared with. It's meant to
+// be called when the embedding module starts, before collections using the ptype are read.
+func RegisterPType(definition PTypeDefinition) error {
+	if !pTypeNamePattern.MatchString(string(definition.Name)) {
+		return &ValueError{Msg: fmt.Sprintf("Invalid ptype name %q, must be lower case letters, digits and underscores", definition.Name)}
+	}
+	for format, pTypeFormat := range definition.Formats {
+		if !pTypeNamePattern.MatchString(format) || format == PLAIN_FORMAT || isFPEFormat(format) {
+			return &ValueError{Msg: fmt.Sprintf("Invalid format name %q of ptype %s", format, definition.Name)}
+		}
+		if pTypeFormat.Get == nil {
+			return &ValueError{Msg: fmt.Sprintf("Format %s of ptype %s has no Get function", format, definition.Name)}
+		}
+	}
+
+	registeredPTypesLock.Lock()
+	defer registeredPTypesLock.Unlock()
+	if _, ok := registeredPTypes[definition.Name]; ok {
+		return &ConflictError{string(definition.Name)}
+	}
+	registeredPTypes[definition.Name] = definition
+	return nil
+}
+
+// lookupPType returns the definition of a ptype. Values of unknown ptypes, which collections
+// created before their field types were validated can have, are treated as strings.
+func lookupPType(pType PTypeName) PTypeDefinition {
+	registeredPTypesLock.RLock()
+	defer registeredPTypesLock.RUnlock()
+	if definition, ok := registeredPTypes[pType]; ok {
+		return definition
+	}
+	return registeredPTypes[StringType]
+}
+
+// IsPTypeRegistered reports whether collection fields can be declared with a ptype.
+func IsPTypeRegistered(pType PTypeName) bool {
+	registeredPTypesLock.RLock()
+	defer registeredPTypesLock.RUnlock()
+	_, ok := registeredPTypes[pType]
+	return ok
+}
+
+// GetPType parses a value of a ptype.
+func GetPType(pType PTypeName, value string) (PType, error) {
+	definition := lookupPType(pType)
+	if definition.Normalize != nil {
+		value = definition.Normalize(value)
+	}
+	if definition.Validate != nil {
+		if err := definition.Validate(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var newPType PType = customPType{definition.Name, value}
+	if definition.New != nil {
+		var err error
+		if newPType, err = definition.New(value); err != nil {
+			return nil, err
+		}
+	}
+	if err := newPType.Validate(); err != nil {
+		return nil, err
+	}
+	return newPType, nil
+}
+
+// PTypes lists the ptypes and the formats each of them supports.
+func PTypes() []PTypeInfo {
+	registeredPTypesLock.RLock()
+	defer registeredPTypesLock.RUnlock()
+
+	pTypes := make([]PTypeInfo, 0, len(registeredPTypes))
+	for name, definition := range registeredPTypes {
+		info := PTypeInfo{Name: name, Description: definition.Description, Formats: map[string]string{
+			PLAIN_FORMAT:  plainFormat.Description,
+			MASKED_FORMAT: maskedFormat.Description,
+		}}
+		for format, pTypeFormat := range definition.Formats {
+			info.Formats[format] = pTypeFormat.Description
+		}
+		if _, ok := fpeSchemes[name]; ok {
+			info.Formats[FPE_FORMAT] = "The value encrypted to a value of the same format"
+			info.Formats[FPE_LAST4_FORMAT] = "The value encrypted to a value of the same format, keeping its last 4 digits"
+		}
+		pTypes = append(pTypes, info)
+	}
+	sort.Slice(pTypes, func(i, j int) bool { return pTypes[i].Name < pTypes[j].Name })
+	return pTypes
+}
+
+// customPType holds the values of registered ptypes without a constructor.
+type customPType struct {
+	pType PTypeName
+	val   string
+}
+
+func (c customPType) Get(format string) (string, error) {
+	return GetFormat(c, c.pType, format)
+}
+
+func (c customPType) GetPlain() string {
+	return c.val
+}
+
+func (c customPType) GetMasked() string {
+	return "*"
+}
+
+func (c customPType) Validate() error {
+	return nil
+}
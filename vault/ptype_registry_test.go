@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestRegisterPType(t *testing.T) {
+	err := RegisterPType(PTypeDefinition{
+		Name:        "employee_id",
+		Description: "An employee ID such as EMP-001234",
+		Normalize:   strings.ToUpper,
+		Validate: func(value string) error {
+			if !strings.HasPrefix(value, "EMP-") {
+				return &ValueError{Msg: fmt.Sprintf("Invalid employee ID %s", value)}
+			}
+			return nil
+		},
+		Formats: map[string]PTypeFormat{
+			MASKED_FORMAT: {"The ID without its number", func(value PType) (string, error) { return "EMP-******", nil }},
+			"number":      {"The number of the ID", func(value PType) (string, error) { return value.GetPlain()[4:], nil }},
+		},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, IsPTypeRegistered("employee_id"), true)
+
+	employeeId, err := GetPType("employee_id", "emp-001234")
+	assert.Equal(t, err, nil)
+	plain, _ := employeeId.Get(PLAIN_FORMAT)
+	assert.Equal(t, plain, "EMP-001234")
+	masked, _ := employeeId.Get(MASKED_FORMAT)
+	assert.Equal(t, masked, "EMP-******")
+	number, _ := employeeId.Get("number")
+	assert.Equal(t, number, "001234")
+
+	_, err = GetPType("employee_id", "001234")
+	var valueErr *ValueError
+	assert.Equal(t, errors.As(err, &valueErr), true)
+
+	var conflictErr *ConflictError
+	err = RegisterPType(PTypeDefinition{Name: "employee_id"})
+	assert.Equal(t, errors.As(err, &conflictErr), true)
+	err = RegisterPType(PTypeDefinition{Name: EmailType})
+	assert.Equal(t, errors.As(err, &conflictErr), true)
+}
+
+func TestRegisterInvalidPType(t *testing.T) {
+	var valueErr *ValueError
+	for _, definition := range []PTypeDefinition{
+		{Name: ""},
+		{Name: "medical.record"},
+		{Name: "medical_record", Formats: map[string]PTypeFormat{"plain": {"The value", func(value PType) (string, error) { return "", nil }}}},
+		{Name: "medical_record", Formats: map[string]PTypeFormat{"fpe": {"Encrypted", func(value PType) (string, error) { return "", nil }}}},
+		{Name: "medical_record", Formats: map[string]PTypeFormat{"hospital": {Description: "The hospital"}}},
+	} {
+		err := RegisterPType(definition)
+		assert.Equal(t, errors.As(err, &valueErr), true)
+	}
+	assert.Equal(t, IsPTypeRegistered("medical_record"), false)
+}
+
+func TestUnknownPTypesAreStrings(t *testing.T) {
+	value, err := GetPType("legacy_type", "value")
+	assert.Equal(t, err, nil)
+	masked, _ := value.Get(MASKED_FORMAT)
+	assert.Equal(t, masked, "*")
+}
+
+func TestPTypes(t *testing.T) {
+	pTypes := PTypes()
+	listed := map[PTypeName]PTypeInfo{}
+	for i, info := range pTypes {
+		if i > 0 {
+			assert.Equal(t, pTypes[i-1].Name < info.Name, true)
+		}
+		assert.NotEqual(t, info.Formats[PLAIN_FORMAT], "")
+		assert.NotEqual(t, info.Formats[MASKED_FORMAT], "")
+		listed[info.Name] = info
+	}
+
+	for name := range builtinPTypes() {
+		assert.NotEqual(t, listed[name].Description, "")
+	}
+	assert.NotEqual(t, listed[PhoneNumberType].Formats[NATIONAL_FORMAT], "")
+	assert.NotEqual(t, listed[PhoneNumberType].Formats[FPE_FORMAT], "")
+}
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/go-playground/assert/v2"
@@ -71,3 +72,338 @@ func TestInvalidEmailPType(t *testing.T) {
 	_, err := GetPType(EmailType, value)
 	assert.NotEqual(t, err, nil)
 }
+
+func TestRegexPType(t *testing.T) {
+	field := Field{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "**-$2"}
+
+	account, err := GetFieldPType(field, "AB-123456")
+	assert.Equal(t, err, nil)
+
+	plain, _ := account.Get("plain")
+	masked, _ := account.Get("masked")
+
+	assert.Equal(t, plain, "AB-123456")
+	assert.Equal(t, masked, "**-123456")
+
+	field.Mask = ""
+	account, _ = GetFieldPType(field, "AB-123456")
+	masked, _ = account.Get("masked")
+	assert.Equal(t, masked, "*********")
+
+	// Patterns are compiled once
+	pattern, _ := compilePattern(field.Pattern)
+	assert.Equal(t, account.(Regex).pattern == pattern, true)
+}
+
+func TestInvalidRegexPType(t *testing.T) {
+	field := Field{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`}
+
+	// Patterns must match the whole value
+	_, err := GetFieldPType(field, "XAB-123456")
+	assert.NotEqual(t, err, nil)
+	_, err = GetFieldPType(field, "AB-1234567")
+	assert.NotEqual(t, err, nil)
+
+	// The pattern of the field is required
+	_, err = GetPType(RegexType, "AB-123456")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestFieldPattern(t *testing.T) {
+	valid := []Field{
+		{Type: "regex", Pattern: `POL-\d+`},
+		{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "${1}-******"},
+		{Type: "regex", Pattern: `(?P<prefix>[A-Z]+)-(?P<number>\d+)`, Mask: "$$-$number"},
+		{Type: "string"},
+	}
+	for _, field := range valid {
+		assert.Equal(t, field.validatePattern(), nil)
+	}
+
+	invalid := []Field{
+		{Type: "regex"},
+		{Type: "regex", Pattern: `([A-Z]{2}`},
+		{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "**-$3"},
+		{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "$1x"},
+		{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "$0"},
+		{Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "${0}"},
+		{Type: "string", Pattern: `\d+`},
+	}
+	for _, field := range invalid {
+		assert.NotEqual(t, field.validatePattern(), nil)
+	}
+}
+
+func TestCreditCardFormats(t *testing.T) {
+	for value, brand := range map[string]string{
+		"4242424242424242": "Visa",
+		"5555555555554444": "Mastercard",
+		"2223003122003222": "Mastercard",
+		"378282246310005":  "Amex",
+		"6011111111111117": "Unknown",
+	} {
+		cc, err := GetPType(CreditCardNumberType, value)
+		assert.Equal(t, err, nil)
+
+		returnedBrand, _ := cc.Get("brand")
+		last4, _ := cc.Get("last4")
+		bin, _ := cc.Get("bin")
+
+		assert.Equal(t, returnedBrand, brand)
+		assert.Equal(t, last4, value[len(value)-4:])
+		assert.Equal(t, bin, value[:6])
+	}
+}
+
+func TestCreditCardCVVPType(t *testing.T) {
+	for _, value := range []string{"123", "1234"} {
+		cvv, err := GetPType(CreditCardCVVType, value)
+		assert.Equal(t, err, nil)
+
+		masked, _ := cvv.Get("masked")
+		assert.Equal(t, masked, allStars(value))
+	}
+
+	for _, value := range []string{"12", "12345", "12a"} {
+		_, err := GetPType(CreditCardCVVType, value)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestCreditCardExpiryPType(t *testing.T) {
+	expiry, err := GetPType(CreditCardExpiryType, "09/45")
+	assert.Equal(t, err, nil)
+
+	plain, _ := expiry.Get("plain")
+	masked, _ := expiry.Get("masked")
+
+	assert.Equal(t, plain, "09/45")
+	assert.Equal(t, masked, "**/**")
+	_, _, err = validateFieldValue("expiry", Field{Type: "cc_expiry"}, "09/45")
+	assert.Equal(t, err, nil)
+
+	// Expired cards can be read back but not written
+	expired, err := GetPType(CreditCardExpiryType, "09/20")
+	assert.Equal(t, err, nil)
+	plain, _ = expired.Get("plain")
+	assert.Equal(t, plain, "09/20")
+	_, failures, _ := validateFieldValue("expiry", Field{Type: "cc_expiry"}, "09/20")
+	assert.Equal(t, len(failures), 1)
+
+	for _, value := range []string{"13/45", "9/45", "09/2045", "0945"} {
+		_, err := GetPType(CreditCardExpiryType, value)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestIBANPType(t *testing.T) {
+	value := "GB82 WEST 1234 5698 7654 32"
+
+	iban, err := GetPType(IBANType, value)
+	assert.Equal(t, err, nil)
+
+	plain, _ := iban.Get("plain")
+	masked, _ := iban.Get("masked")
+	country, _ := iban.Get("country")
+
+	assert.Equal(t, plain, "GB82WEST12345698765432")
+	assert.Equal(t, masked, "GB****************5432")
+	assert.Equal(t, country, "GB")
+
+	_, err = GetPType(IBANType, "de89370400440532013000")
+	assert.Equal(t, err, nil)
+
+	// Values are written normalized
+	normalized, _, err := validateFieldValue("iban", Field{Type: "iban"}, value)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, normalized, "GB82WEST12345698765432")
+}
+
+func TestInvalidIBANPType(t *testing.T) {
+	for _, value := range []string{
+		"GB82WEST12345698765431", // Checksum
+		"GB82WEST1234569876543",  // Length for the country
+		"ZZ82WEST12345698765432", // Country
+		"GB82-WEST-1234-5698-76",
+		"GB",
+	} {
+		_, err := GetPType(IBANType, value)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestBICPType(t *testing.T) {
+	for value, masked := range map[string]string{
+		"DEUTDEFF":    "****DE**",
+		"NWBKGB2L500": "****GB*****",
+	} {
+		bic, err := GetPType(BICType, value)
+		assert.Equal(t, err, nil)
+
+		returnedMasked, _ := bic.Get("masked")
+		country, _ := bic.Get("country")
+		assert.Equal(t, returnedMasked, masked)
+		assert.Equal(t, country, value[4:6])
+	}
+
+	for _, value := range []string{"DEUTDEF", "DEUTDEFF50", "1EUTDEFF"} {
+		_, err := GetPType(BICType, value)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestABARoutingPType(t *testing.T) {
+	routing, err := GetPType(ABARoutingType, "021000021")
+	assert.Equal(t, err, nil)
+
+	masked, _ := routing.Get("masked")
+	assert.Equal(t, masked, "*****0021")
+
+	for _, value := range []string{"021000022", "02100002", "02100002a"} {
+		_, err := GetPType(ABARoutingType, value)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestSortCodePType(t *testing.T) {
+	sortCode, err := GetPType(SortCodeType, "12-34-56")
+	assert.Equal(t, err, nil)
+
+	plain, _ := sortCode.Get("plain")
+	masked, _ := sortCode.Get("masked")
+	assert.Equal(t, plain, "12-34-56")
+	assert.Equal(t, masked, "**-**-56")
+
+	_, err = GetPType(SortCodeType, "12345")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestAccountNumberPType(t *testing.T) {
+	accountNumber, err := GetPType(AccountNumberType, "31926819")
+	assert.Equal(t, err, nil)
+
+	masked, _ := accountNumber.Get("masked")
+	assert.Equal(t, masked, "****6819")
+
+	for _, value := range []string{"123", "123456789012345678", "1234abcd"} {
+		_, err := GetPType(AccountNumberType, value)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestSSNPType(t *testing.T) {
+	ssn, err := GetPType(SSNType, "123 45 6789")
+	assert.Equal(t, err, nil)
+
+	plain, _ := ssn.Get("plain")
+	masked, _ := ssn.Get("masked")
+	last4, _ := ssn.Get("last4")
+
+	assert.Equal(t, plain, "123-45-6789")
+	assert.Equal(t, masked, "***-**-6789")
+	assert.Equal(t, last4, "6789")
+
+	for _, value := range []string{"000-45-6789", "666-45-6789", "912-45-6789", "123-00-6789", "123-45-0000", "123-45-678"} {
+		_, err := GetPType(SSNType, value)
+		var ve *ValueError
+		assert.Equal(t, errors.As(err, &ve), true)
+	}
+}
+
+func TestNINOPType(t *testing.T) {
+	nino, err := GetPType(NINOType, "ab 12 34 56 c")
+	assert.Equal(t, err, nil)
+
+	plain, _ := nino.Get("plain")
+	masked, _ := nino.Get("masked")
+	last4, _ := nino.Get("last4")
+
+	assert.Equal(t, plain, "AB123456C")
+	assert.Equal(t, masked, "*****456C")
+	assert.Equal(t, last4, "456C")
+
+	for _, value := range []string{"DA123456C", "AO123456C", "GB123456C", "AB123456E", "AB12345C"} {
+		_, err := GetPType(NINOType, value)
+		var ve *ValueError
+		assert.Equal(t, errors.As(err, &ve), true)
+	}
+}
+
+func TestPassportNumberPType(t *testing.T) {
+	passport, err := GetPType(PassportNumberType, "c01x00t47")
+	assert.Equal(t, err, nil)
+
+	plain, _ := passport.Get("plain")
+	masked, _ := passport.Get("masked")
+	last4, _ := passport.Get("last4")
+
+	assert.Equal(t, plain, "C01X00T47")
+	assert.Equal(t, masked, "*****0T47")
+	assert.Equal(t, last4, "0T47")
+
+	for _, value := range []string{"C01X0", "C01X00T470", "C01X-0T47"} {
+		_, err := GetPType(PassportNumberType, value)
+		var ve *ValueError
+		assert.Equal(t, errors.As(err, &ve), true)
+	}
+}
+
+func TestAddressPType(t *testing.T) {
+	value := `{"lines": ["10  Downing Street", ""], "city": "London", "postcode": "sw1a 2aa", "country": "gb"}`
+
+	address, err := GetPType(AddressType, value)
+	assert.Equal(t, err, nil)
+
+	plain, _ := address.Get("plain")
+	masked, _ := address.Get("masked")
+	country, _ := address.Get("country")
+	city, _ := address.Get("city")
+	postcodePrefix, _ := address.Get("postcode_prefix")
+
+	assert.Equal(t, plain, `{"lines":["10 Downing Street"],"city":"London","postcode":"SW1A 2AA","country":"GB"}`)
+	assert.Equal(t, masked, `{"lines":["*****************"],"city":"London","postcode":"SW1A ***","country":"GB"}`)
+	assert.Equal(t, country, "GB")
+	assert.Equal(t, city, "London")
+	assert.Equal(t, postcodePrefix, "SW1A")
+
+	zip, _ := GetPType(AddressType, `{"lines": ["1 Market St"], "city": "San Francisco", "region": "CA", "postcode": "94105", "country": "US"}`)
+	postcodePrefix, _ = zip.Get("postcode_prefix")
+	assert.Equal(t, postcodePrefix, "941")
+
+	// Free text addresses are kept as their lines
+	text, err := GetPType(AddressType, "10  Downing Street\nLondon SW1A 2AA\n")
+	assert.Equal(t, err, nil)
+	plain, _ = text.Get("plain")
+	masked, _ = text.Get("masked")
+	country, _ = text.Get("country")
+	assert.Equal(t, plain, "10 Downing Street\nLondon SW1A 2AA")
+	assert.Equal(t, masked, "*****************\n***************")
+	assert.Equal(t, country, "")
+}
+
+func TestInvalidAddressPType(t *testing.T) {
+	for _, value := range []string{
+		" \n ",
+		`{"lines": [], "city": "London", "country": "GB"}`,
+		`{"lines": ["10 Downing Street"], "country": "GB"}`,
+		`{"lines": ["10 Downing Street"], "city": "London", "country": "GBR"}`,
+		`{"lines": ["10 Downing Street"], "city": "London", "country": "GB", "phone": "+44"}`,
+	} {
+		_, err := GetPType(AddressType, value)
+		var ve *ValueError
+		assert.Equal(t, errors.As(err, &ve), true)
+	}
+}
+
+func TestMaskingShortValues(t *testing.T) {
+	name := Name{"  Zoë   Ångström "}
+	assert.Equal(t, name.GetMasked(), "Z** Å*******")
+	assert.Equal(t, Name{""}.GetMasked(), "")
+	first, _ := Name{" "}.Get(FIRST_FORMAT)
+	assert.Equal(t, first, "")
+
+	card := CreditCardNumber{"123"}
+	assert.Equal(t, card.GetMasked(), "***")
+	last4, _ := card.Get(LAST4_FORMAT)
+	assert.Equal(t, last4, "123")
+}
@@ -0,0 +1,146 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Every stored record carries a MAC over its id, field ciphertexts, blind indexes and metadata
+// in this column, so that rows edited directly in the database are detected when read.
+const record_mac_field = "record_mac"
+
+// CollectionVerification reports the records of a collection whose MAC doesn't match their
+// stored values, and the records written before MACs were introduced.
+type CollectionVerification struct {
+	Collection string   `json:"collection"`
+	Verified   int      `json:"verified"`
+	Tampered   []string `json:"tampered"`
+	Unsigned   []string `json:"unsigned"`
+}
+
+// timestampLayouts are the formats timestamps are written in by the vault and read back from
+// the database in.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+}
+
+// normalizeTimestamp formats a timestamp the same way whether it was just written or read back
+// from the database, which returns it in its own format and time zone.
+func normalizeTimestamp(value string) string {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return value
+}
+
+// recordMACMessage returns the message a record's MAC is computed over. Its columns are taken
+// from the collection schema so that columns missing from the record are covered as empty.
+func recordMACMessage(col *Collection, record Record) (string, error) {
+	values := map[string]string{
+		"id":         record["id"],
+		"created_at": normalizeTimestamp(record["created_at"]),
+		"updated_at": normalizeTimestamp(record["updated_at"]),
+	}
+	for fieldName, field := range col.Fields {
+		values[fieldName] = record[fieldName]
+		if field.IsIndexed && fieldName != subject_id_field {
+			values[blindIndexColumn(fieldName)] = record[blindIndexColumn(fieldName)]
+		}
+	}
+
+	// The prefix can't start a blind index message, which starts with a collection name.
+	message, err := json.Marshal(struct {
+		Collection string            `json:"collection"`
+		Values     map[string]string `json:"values"`
+	}{col.Name, values})
+	if err != nil {
+		return "", err
+	}
+	return "record:" + string(message), nil
+}
+
+func (vault Vault) recordMAC(col *Collection, record Record) (string, error) {
+	message, err := recordMACMessage(col, record)
+	if err != nil {
+		return "", err
+	}
+	return vault.Signer.Sign(message)
+}
+
+// verifyRecord checks the MAC of a stored record. Records written before MACs were introduced
+// have none and are signed by the next rekey job, after which their collection is strict and
+// records without a MAC fail verification.
+func (vault Vault) verifyRecord(col *Collection, record Record) error {
+	if record[record_mac_field] == "" {
+		if col.Strict {
+			return &IntegrityError{Msg: fmt.Sprintf("record %s of collection %s has no MAC", record["id"], col.Name)}
+		}
+		return nil
+	}
+	message, err := recordMACMessage(col, record)
+	if err != nil {
+		return err
+	}
+	valid, err := vault.Signer.Verify(message, record[record_mac_field])
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return &IntegrityError{Msg: fmt.Sprintf("record %s of collection %s failed integrity verification", record["id"], col.Name)}
+	}
+	return nil
+}
+
+// VerifyCollection checks the MAC of every record of a collection.
+func (vault Vault) VerifyCollection(ctx context.Context, principal Principal, collectionName string) (*CollectionVerification, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, fmt.Sprintf("%s/%s/verify", COLLECTIONS_PPATH, collectionName)}); err != nil {
+		return nil, err
+	}
+
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	recordIds, err := vault.Db.GetRecords(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &CollectionVerification{Collection: collectionName, Tampered: []string{}, Unsigned: []string{}}
+	for _, recordId := range recordIds {
+		record, err := vault.Db.GetRecord(ctx, collectionName, recordId)
+		if err != nil {
+			var nf *NotFoundError
+			if errors.As(err, &nf) {
+				// Deleted since the scan started
+				continue
+			}
+			return nil, err
+		}
+		if record[record_mac_field] == "" && !col.Strict {
+			verification.Unsigned = append(verification.Unsigned, recordId)
+			continue
+		}
+		if err := vault.verifyRecord(col, record); err != nil {
+			var ie *IntegrityError
+			if !errors.As(err, &ie) {
+				return nil, err
+			}
+			verification.Tampered = append(verification.Tampered, recordId)
+			continue
+		}
+		verification.Verified++
+	}
+
+	if len(verification.Tampered) > 0 {
+		vault.Logger.Warn(fmt.Sprintf("%d records of collection %s failed integrity verification", len(verification.Tampered), collectionName))
+	}
+	return verification, nil
+}
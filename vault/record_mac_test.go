@@ -0,0 +1,142 @@
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTimestamp(t *testing.T) {
+	expected := "2023-10-16T12:51:33Z"
+	for _, value := range []string{
+		"2023-10-16T12:51:33Z",
+		"2023-10-16T14:51:33+02:00",
+		"2023-10-16 12:51:33+00",
+		"2023-10-16 18:21:33+05:30",
+	} {
+		assert.Equal(t, expected, normalizeTimestamp(value))
+	}
+	assert.Equal(t, "", normalizeTimestamp(""))
+}
+
+func TestRecordMAC(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	col := Collection{Name: "customers", Fields: map[string]Field{
+		"name":  {Type: "name", IsIndexed: false},
+		"email": {Type: "email", IsIndexed: true},
+	}}
+	_ = vault.CreateCollection(ctx, rootPrincipal, &col)
+	accounts := Collection{Name: "accounts", Parent: "customers", Fields: map[string]Field{
+		"iban": {Type: "string", IsIndexed: false},
+	}}
+	_ = vault.CreateCollection(ctx, rootPrincipal, &accounts)
+
+	t.Run("records are signed when created and updated", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": "john@crawford.com"})
+		assert.NoError(t, err)
+		stored, _ := db.GetRecord(ctx, col.Name, recordId)
+		assert.NotEmpty(t, stored[record_mac_field])
+
+		err = vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"name": "Jane Crawford", "email": "jane@crawford.com"})
+		assert.NoError(t, err)
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"name": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Jane Crawford", record["name"])
+	})
+
+	t.Run("records edited in the database fail to be read", func(t *testing.T) {
+		johnId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": "john@crawford.com"})
+		janeId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "Jane Crawford", "email": "jane@crawford.com"})
+		accountId, _ := vault.CreateRecord(ctx, rootPrincipal, accounts.Name, Record{"iban": "GB33BUKB20201555555555", "subject_id": johnId})
+
+		db.(*SqlStore).db.Exec("UPDATE collection_accounts SET subject_id = ? WHERE id = ?", janeId, accountId)
+		_, err := vault.GetRecord(ctx, rootPrincipal, accounts.Name, accountId, map[string]string{"iban": "plain"})
+		var ie *IntegrityError
+		assert.ErrorAs(t, err, &ie)
+
+		db.(*SqlStore).db.Exec("UPDATE collection_customers SET created_at = '2020-01-01' WHERE id = ?", janeId)
+		_, err = vault.GetRecord(ctx, rootPrincipal, col.Name, janeId, map[string]string{"name": "plain"})
+		assert.ErrorAs(t, err, &ie)
+
+		err = vault.UpdateRecord(ctx, rootPrincipal, col.Name, janeId, Record{"name": "Jane Doe", "email": "jane@doe.com"})
+		assert.ErrorAs(t, err, &ie)
+	})
+
+	t.Run("concurrent updates of a record are both kept", func(t *testing.T) {
+		recordId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": "john@crawford.com"})
+
+		// The email is updated between the read and the write of the name
+		interleaved := vault
+		interleaved.Db = &interleavedDB{VaultDB: db, interleave: func() {
+			assert.NoError(t, vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"email": "jane@crawford.com"}))
+		}}
+		assert.NoError(t, interleaved.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"name": "Jane Crawford"}))
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"name": "plain", "email": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Jane Crawford", record["name"])
+		assert.Equal(t, "jane@crawford.com", record["email"])
+	})
+
+	t.Run("the subject of a record can't be changed", func(t *testing.T) {
+		johnId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": "john@crawford.com"})
+		janeId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "Jane Crawford", "email": "jane@crawford.com"})
+		accountId, _ := vault.CreateRecord(ctx, rootPrincipal, accounts.Name, Record{"iban": "GB33BUKB20201555555555", "subject_id": johnId})
+
+		err := vault.UpdateRecord(ctx, rootPrincipal, accounts.Name, accountId, Record{"iban": "GB94BARC10201530093459", "subject_id": janeId})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+
+		assert.NoError(t, vault.UpdateRecord(ctx, rootPrincipal, accounts.Name, accountId, Record{"iban": "GB94BARC10201530093459", "subject_id": johnId}))
+	})
+
+	t.Run("can verify a collection", func(t *testing.T) {
+		vault, db, _ := initVault(t)
+		_ = vault.CreateCollection(ctx, rootPrincipal, &Collection{Name: "customers", Fields: map[string]Field{
+			"name": {Type: "name", IsIndexed: false},
+		}})
+		validId, _ := vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "John Crawford"})
+		tamperedId, _ := vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "Jane Crawford"})
+		unsignedId, _ := vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "Joe Crawford"})
+
+		valid, _ := db.GetRecord(ctx, "customers", validId)
+		db.(*SqlStore).db.Exec("UPDATE collection_customers SET name = ? WHERE id = ?", valid["name"], tamperedId)
+		db.(*SqlStore).db.Exec("UPDATE collection_customers SET record_mac = NULL WHERE id = ?", unsignedId)
+
+		// Records of strict collections are all signed, one without a MAC was tampered with
+		verification, err := vault.VerifyCollection(ctx, rootPrincipal, "customers")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, verification.Verified)
+		assert.ElementsMatch(t, []string{tamperedId, unsignedId}, verification.Tampered)
+		assert.Empty(t, verification.Unsigned)
+		_, err = vault.GetRecord(ctx, rootPrincipal, "customers", unsignedId, map[string]string{"name": "plain"})
+		var ie *IntegrityError
+		assert.ErrorAs(t, err, &ie)
+
+		// Until the rekey job signs them, records of older collections may not have one
+		db.(*SqlStore).db.Exec("UPDATE collections_metadata SET strict = false")
+		verification, err = vault.VerifyCollection(ctx, rootPrincipal, "customers")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, verification.Verified)
+		assert.Equal(t, []string{tamperedId}, verification.Tampered)
+		assert.Equal(t, []string{unsignedId}, verification.Unsigned)
+	})
+}
+
+// interleavedDB runs interleave once before the first conditional update of a record, like a
+// concurrent writer would.
+type interleavedDB struct {
+	VaultDB
+	interleave func()
+}
+
+func (d *interleavedDB) UpdateRecordIfUnchanged(ctx context.Context, collectionName string, recordID string, current Record, record Record) error {
+	if interleave := d.interleave; interleave != nil {
+		d.interleave = nil
+		interleave()
+	}
+	return d.VaultDB.UpdateRecordIfUnchanged(ctx, collectionName, recordID, current, record)
+}
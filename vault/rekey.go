@@ -0,0 +1,412 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type RekeyJobStatus string
+
+const (
+	RekeyJobRunning   RekeyJobStatus = "running"
+	RekeyJobCompleted RekeyJobStatus = "completed"
+	RekeyJobFailed    RekeyJobStatus = "failed"
+)
+
+type RekeyCollectionProgress struct {
+	Name      string `json:"name"`
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Rekeyed   int    `json:"rekeyed"`
+}
+
+// RekeyJob tracks the re-encryption of every collection: data keys are rewrapped with the
+// active key of the keyring and records not yet encrypted with their subject's key, or their
+// collection's data key, are re-encrypted. Collections are then made strict, see boundPrivatiser.
+type RekeyJob struct {
+	Id          string                     `json:"id"`
+	Status      RekeyJobStatus             `json:"status"`
+	KeyVersion  int                        `json:"key_version"`
+	Collections []*RekeyCollectionProgress `json:"collections"`
+	Error       string                     `json:"error,omitempty"`
+	StartedAt   time.Time                  `json:"started_at"`
+	FinishedAt  *time.Time                 `json:"finished_at,omitempty"`
+}
+
+func (k *Keyring) job(id string) (*RekeyJob, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	job, ok := k.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.snapshot(), true
+}
+
+func (k *Keyring) updateJob(id string, update func(job *RekeyJob)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	update(k.jobs[id])
+}
+
+func (job *RekeyJob) snapshot() *RekeyJob {
+	copied := *job
+	copied.Collections = make([]*RekeyCollectionProgress, len(job.Collections))
+	for i, progress := range job.Collections {
+		progressCopy := *progress
+		copied.Collections[i] = &progressCopy
+	}
+	return &copied
+}
+
+func (vault Vault) keyring() (*Keyring, error) {
+	priv := vault.Priv
+	if seal, ok := priv.(*Seal); ok {
+		var err error
+		if priv, _, err = seal.unsealed(); err != nil {
+			return nil, err
+		}
+	}
+	keyring, ok := priv.(*Keyring)
+	if !ok {
+		return nil, &NotSupportedError{Msg: "key rotation requires the vault to be configured with a keyring"}
+	}
+	return keyring, nil
+}
+
+func (vault Vault) GetKeyringStatus(
+	ctx context.Context,
+	principal Principal,
+) (*KeyringStatus, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, KEYRING_PPATH}); err != nil {
+		return nil, err
+	}
+	keyring, err := vault.keyring()
+	if err != nil {
+		return nil, err
+	}
+	status := keyring.Status()
+	return &status, nil
+}
+
+// RotateKey makes a new key the active one. Existing ciphertexts stay readable with the
+// key they were written with until they are re-encrypted by StartRekey.
+func (vault Vault) RotateKey(
+	ctx context.Context,
+	principal Principal,
+) (*KeyringStatus, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, KEYRING_PPATH}); err != nil {
+		return nil, err
+	}
+	keyring, err := vault.keyring()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keyring.Rotate(ctx); err != nil {
+		return nil, err
+	}
+	status := keyring.Status()
+	return &status, nil
+}
+
+// StartRekey starts a background job rewrapping the data key of every collection with the
+// active key and migrating records written before data keys or subject keys were introduced.
+// Progress is reported per collection through GetRekeyJob.
+func (vault Vault) StartRekey(
+	ctx context.Context,
+	principal Principal,
+) (*RekeyJob, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, KEYRING_PPATH}); err != nil {
+		return nil, err
+	}
+	keyring, err := vault.keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	collectionNames, err := vault.Db.GetCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Subjects are rekeyed before the collections referencing them, so that the subject keys
+	// created for older subjects are used for their children.
+	if collectionNames, err = vault.sortBySubjectDepth(ctx, collectionNames); err != nil {
+		return nil, err
+	}
+
+	job := &RekeyJob{
+		Id:         GenerateId("job"),
+		Status:     RekeyJobRunning,
+		KeyVersion: keyring.Status().ActiveVersion,
+		StartedAt:  time.Now(),
+	}
+	for _, name := range collectionNames {
+		job.Collections = append(job.Collections, &RekeyCollectionProgress{Name: name})
+	}
+
+	keyring.mu.Lock()
+	for _, existing := range keyring.jobs {
+		if existing.Status == RekeyJobRunning {
+			keyring.mu.Unlock()
+			return nil, &ConflictError{fmt.Sprintf("rekey job %s is already running", existing.Id)}
+		}
+	}
+	keyring.jobs[job.Id] = job
+	snapshot := job.snapshot()
+	keyring.mu.Unlock()
+
+	// The job outlives the request that started it.
+	go vault.runRekey(context.Background(), keyring, job.Id, collectionNames)
+
+	return snapshot, nil
+}
+
+// sortBySubjectDepth orders collections by the number of ancestors they have.
+func (vault Vault) sortBySubjectDepth(ctx context.Context, collectionNames []string) ([]string, error) {
+	parents := make(map[string]string, len(collectionNames))
+	for _, name := range collectionNames {
+		col, err := vault.Db.GetCollection(ctx, name)
+		if err != nil {
+			var nf *NotFoundError
+			if errors.As(err, &nf) {
+				continue
+			}
+			return nil, err
+		}
+		parents[name] = col.Parent
+	}
+	depth := func(name string) int {
+		d := 0
+		for parent := parents[name]; parent != "" && d < len(parents); parent = parents[parent] {
+			d++
+		}
+		return d
+	}
+
+	sorted := append([]string(nil), collectionNames...)
+	sort.SliceStable(sorted, func(i, j int) bool { return depth(sorted[i]) < depth(sorted[j]) })
+	return sorted, nil
+}
+
+func (vault Vault) GetRekeyJob(
+	ctx context.Context,
+	principal Principal,
+	jobId string,
+) (*RekeyJob, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, KEYRING_PPATH}); err != nil {
+		return nil, err
+	}
+	keyring, err := vault.keyring()
+	if err != nil {
+		return nil, err
+	}
+	job, ok := keyring.job(jobId)
+	if !ok {
+		return nil, &NotFoundError{"rekey job", jobId}
+	}
+	return job, nil
+}
+
+func (vault Vault) runRekey(ctx context.Context, keyring *Keyring, jobId string, collectionNames []string) {
+	var jobErr error
+	for i, name := range collectionNames {
+		if jobErr = vault.rekeyCollection(ctx, keyring, jobId, i, name); jobErr != nil {
+			break
+		}
+	}
+
+	keyring.updateJob(jobId, func(job *RekeyJob) {
+		finishedAt := time.Now()
+		job.FinishedAt = &finishedAt
+		job.Status = RekeyJobCompleted
+		if jobErr != nil {
+			job.Status = RekeyJobFailed
+			job.Error = jobErr.Error()
+		}
+	})
+	if jobErr != nil {
+		vault.Logger.Error(fmt.Sprintf("Rekey job %s failed: %s", jobId, jobErr.Error()))
+	} else {
+		vault.Logger.Info(fmt.Sprintf("Rekey job %s completed", jobId))
+	}
+}
+
+func (vault Vault) rekeyCollection(ctx context.Context, keyring *Keyring, jobId string, index int, collectionName string) error {
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			// Deleted since the job started
+			return nil
+		}
+		return err
+	}
+	if err := vault.rekeyDataKey(ctx, keyring, col); err != nil {
+		return err
+	}
+	priv, err := vault.collectionPrivatiser(col)
+	if err != nil {
+		return err
+	}
+
+	recordIds, err := vault.Db.GetRecords(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+	keyring.updateJob(jobId, func(job *RekeyJob) { job.Collections[index].Total = len(recordIds) })
+
+	tampered := false
+	for _, recordId := range recordIds {
+		rekeyed, err := vault.rekeyRecord(ctx, priv, col, recordId)
+		if err != nil {
+			var ie *IntegrityError
+			if !errors.As(err, &ie) {
+				return fmt.Errorf("failed to rekey record %s of collection %s: %w", recordId, collectionName, err)
+			}
+			// Rekeying would sign the tampered values, the record is left as is
+			vault.Logger.Warn(err.Error())
+			tampered = true
+		}
+		keyring.updateJob(jobId, func(job *RekeyJob) {
+			job.Collections[index].Processed++
+			if rekeyed {
+				job.Collections[index].Rekeyed++
+			}
+		})
+	}
+
+	// Every record is now bound, as are the records written since the job started
+	if !col.Strict && !tampered {
+		return vault.Db.SetCollectionStrict(ctx, collectionName)
+	}
+	return nil
+}
+
+// rekeyDataKey wraps the data key of a collection with the active key, bound to the collection,
+// generating one for collections created before data keys were introduced. Records encrypted
+// with the data key don't need to be re-encrypted when the keyring is rotated.
+func (vault Vault) rekeyDataKey(ctx context.Context, keyring *Keyring, col *Collection) error {
+	var dataKey string
+	var err error
+	switch {
+	case col.DataKey == "":
+		dataKey, err = vault.generateDataKey(col.Name)
+	case !keyring.IsActive(col.DataKey) || !keyring.isBound(col.DataKey):
+		var encodedKey string
+		associatedData := keyAssociatedData("data key", col.Name)
+		encodedKey, err = decryptWithAD(vault.Priv, col.DataKey, associatedData)
+		if err == nil {
+			dataKey, err = encryptWithAD(vault.Priv, encodedKey, associatedData)
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := vault.Db.UpdateCollectionDataKey(ctx, col.Name, col.DataKey, dataKey); err != nil {
+		return err
+	}
+	col.DataKey = dataKey
+	return nil
+}
+
+// rekeyRecord re-encrypts the fields of a record that weren't written with the key of its
+// subject, or the collection's data key if the subject has none, bound to the record and
+// field, and recomputes its blind indexes and MAC. It reports whether the record was rewritten,
+// and returns an IntegrityError for tampered records.
+func (vault Vault) rekeyRecord(ctx context.Context, priv Privatiser, col *Collection, recordId string) (bool, error) {
+	current, err := vault.Db.GetRecord(ctx, col.Name, recordId)
+	if err != nil {
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if col.Parent == "" {
+		// Subjects created before subject keys were introduced get one
+		if _, err := vault.Db.GetSubjectKey(ctx, recordId); err != nil {
+			var nf *NotFoundError
+			if !errors.As(err, &nf) {
+				return false, err
+			}
+			if err := vault.createSubjectKey(ctx, col, priv, recordId); err != nil {
+				return false, err
+			}
+		}
+	}
+	recordPriv, err := vault.recordPrivatiser(ctx, col, priv, current)
+	if err != nil {
+		var se *ShreddedError
+		if errors.As(err, &se) {
+			// Unreadable, the record is left to be deleted with its subject
+			return false, nil
+		}
+		return false, err
+	}
+	if err := vault.verifyRecord(col, current); err != nil {
+		return false, err
+	}
+	keyPriv, ok := recordPriv.(interface{ isBound(string) bool })
+
+	// Records written before MACs were introduced get one
+	stale := current[record_mac_field] == ""
+	for fieldName, field := range col.Fields {
+		if fieldName == subject_id_field || current[fieldName] == "" {
+			// Null values have nothing to rekey
+			continue
+		}
+		if !ok || !keyPriv.isBound(current[fieldName]) {
+			stale = true
+		}
+		if field.IsIndexed && current[blindIndexColumn(fieldName)] == "" {
+			stale = true
+		}
+	}
+	if !stale {
+		return false, nil
+	}
+
+	rekeyedRecord := make(Record)
+	for fieldName := range col.Fields {
+		if fieldName == subject_id_field {
+			rekeyedRecord[fieldName] = current[fieldName]
+			continue
+		}
+		plainValue, err := vault.decryptField(recordPriv, col, recordId, fieldName, current[fieldName])
+		if err != nil {
+			return false, err
+		}
+		if err := vault.encryptField(recordPriv, col, recordId, fieldName, plainValue, rekeyedRecord); err != nil {
+			return false, err
+		}
+	}
+
+	signedRecord := make(Record)
+	for fieldName, value := range current {
+		signedRecord[fieldName] = value
+	}
+	for fieldName, value := range rekeyedRecord {
+		signedRecord[fieldName] = value
+	}
+	if rekeyedRecord[record_mac_field], err = vault.recordMAC(col, signedRecord); err != nil {
+		return false, err
+	}
+
+	err = vault.Db.UpdateRecordIfUnchanged(ctx, col.Name, recordId, current, rekeyedRecord)
+	if err != nil {
+		var ce *ConflictError
+		if errors.As(err, &ce) {
+			// Rewritten concurrently, which already used the right key.
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
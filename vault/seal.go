@@ -0,0 +1,373 @@
+package vault
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Known plaintext wrapped with the master key when the seal is initialised, used to check that
+// the unseal keys reconstruct the right master key.
+const sealCheckValue = "subrose/vault seal check"
+
+const sealMasterKeySize = 32
+
+// Keys wrapped by the master key of a seal are formatted as "sm1:<base64 payload>".
+const sealWrapPrefix = "sm1:"
+
+type SealConfig struct {
+	Shares       int
+	Threshold    int
+	Verification string
+	CreatedAt    time.Time
+}
+
+type SealStatus struct {
+	Initialized bool `json:"initialized"`
+	Sealed      bool `json:"sealed"`
+	Shares      int  `json:"shares"`
+	Threshold   int  `json:"threshold"`
+	Progress    int  `json:"progress"`
+}
+
+// UnsealFunc builds the privatiser and signer of the vault once the master key is known.
+type UnsealFunc func(ctx context.Context, provider KeyProvider) (Privatiser, Signer, error)
+
+// Seal is a Privatiser and Signer that only works once unsealed. The master key is never
+// stored; it is split into Shamir key shares when the seal is initialised and reconstructed
+// in memory when enough shares are submitted to Unseal.
+type Seal struct {
+	db        VaultDB
+	onUnseal  UnsealFunc
+	mu        sync.RWMutex
+	priv      Privatiser
+	signer    Signer
+	shares    [][]byte
+	unsealing bool
+}
+
+func NewSeal(db VaultDB, onUnseal UnsealFunc) *Seal {
+	return &Seal{db: db, onUnseal: onUnseal}
+}
+
+// Init generates the master key and returns it split into key shares, threshold of which are
+// needed to unseal. The seal can only be initialised once.
+func (s *Seal) Init(ctx context.Context, shares int, threshold int) ([][]byte, error) {
+	masterKey := make([]byte, sealMasterKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	keyShares, err := SplitSecret(masterKey, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := newSealKeyProvider(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	check, err := provider.Wrap(ctx, []byte(sealCheckValue))
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.CreateSealConfig(ctx, &SealConfig{
+		Shares:       shares,
+		Threshold:    threshold,
+		Verification: check,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyShares, nil
+}
+
+func (s *Seal) Status(ctx context.Context) (*SealStatus, error) {
+	config, err := s.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status(config), nil
+}
+
+func (s *Seal) status(config *SealConfig) *SealStatus {
+	status := &SealStatus{Sealed: s.priv == nil, Progress: len(s.shares)}
+	if config != nil {
+		status.Initialized = true
+		status.Shares = config.Shares
+		status.Threshold = config.Threshold
+	}
+	return status
+}
+
+func (s *Seal) config(ctx context.Context) (*SealConfig, error) {
+	config, err := s.db.GetSealConfig(ctx)
+	if err != nil {
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// Unseal submits a key share. Once threshold shares have been submitted the master key is
+// reconstructed and checked; submitted shares are discarded whether or not it is valid.
+func (s *Seal) Unseal(ctx context.Context, share []byte) (*SealStatus, error) {
+	config, err := s.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, &ValueError{Msg: "vault is not initialised"}
+	}
+
+	provider, err := s.submit(ctx, config, share)
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return s.statusOf(config), nil
+	}
+
+	// The vault's keys are loaded without holding the lock, which only guards the seal's state.
+	priv, signer, err := s.onUnseal(ctx, provider)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.unsealing {
+		// Sealed in the meantime
+		return s.status(config), nil
+	}
+	s.unsealing = false
+	if err != nil {
+		return nil, err
+	}
+	s.priv, s.signer = priv, signer
+	return s.status(config), nil
+}
+
+// submit adds a key share, returning the key provider of the master key once threshold shares
+// have been submitted.
+func (s *Seal) submit(ctx context.Context, config *SealConfig, share []byte) (KeyProvider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.priv != nil {
+		return nil, nil
+	}
+	if s.unsealing {
+		return nil, &ConflictError{"unseal in progress"}
+	}
+	for _, submitted := range s.shares {
+		if subtle.ConstantTimeCompare(submitted, share) == 1 {
+			return nil, &ValueError{Msg: "key share has already been submitted"}
+		}
+	}
+	s.shares = append(s.shares, share)
+	if len(s.shares) < config.Threshold {
+		return nil, nil
+	}
+
+	masterKey, err := CombineShares(s.shares)
+	s.shares = nil
+	if err != nil {
+		return nil, err
+	}
+	provider, err := newSealKeyProvider(masterKey)
+	for i := range masterKey {
+		masterKey[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+	check, err := provider.Unwrap(ctx, config.Verification)
+	if err != nil || string(check) != sealCheckValue {
+		return nil, &ValueError{Msg: "invalid key shares"}
+	}
+	s.unsealing = true
+	return provider, nil
+}
+
+func (s *Seal) statusOf(config *SealConfig) *SealStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status(config)
+}
+
+// Seal wipes the in-memory keys. The vault needs to be unsealed again to be used.
+func (s *Seal) Seal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.priv, s.signer, s.shares, s.unsealing = nil, nil, nil, false
+}
+
+func (s *Seal) IsSealed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.priv == nil
+}
+
+func (s *Seal) unsealed() (Privatiser, Signer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.priv == nil {
+		return nil, nil, &SealedError{}
+	}
+	return s.priv, s.signer, nil
+}
+
+func (s *Seal) Encrypt(text string) (string, error) {
+	priv, _, err := s.unsealed()
+	if err != nil {
+		return "", err
+	}
+	return priv.Encrypt(text)
+}
+
+func (s *Seal) Decrypt(encodedText string) (string, error) {
+	priv, _, err := s.unsealed()
+	if err != nil {
+		return "", err
+	}
+	return priv.Decrypt(encodedText)
+}
+
+func (s *Seal) EncryptWithAD(text string, associatedData []byte) (string, error) {
+	priv, _, err := s.unsealed()
+	if err != nil {
+		return "", err
+	}
+	return encryptWithAD(priv, text, associatedData)
+}
+
+func (s *Seal) DecryptWithAD(encodedText string, associatedData []byte) (string, error) {
+	priv, _, err := s.unsealed()
+	if err != nil {
+		return "", err
+	}
+	return decryptWithAD(priv, encodedText, associatedData)
+}
+
+func (s *Seal) Sign(message string) (string, error) {
+	_, signer, err := s.unsealed()
+	if err != nil {
+		return "", err
+	}
+	return signer.Sign(message)
+}
+
+func (s *Seal) Verify(message, signature string) (bool, error) {
+	_, signer, err := s.unsealed()
+	if err != nil {
+		return false, err
+	}
+	return signer.Verify(message, signature)
+}
+
+// sealKeyProvider wraps keys with the reconstructed master key of a seal.
+type sealKeyProvider struct {
+	aead cipher.AEAD
+}
+
+func newSealKeyProvider(masterKey []byte) (*sealKeyProvider, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &sealKeyProvider{aead}, nil
+}
+
+func (p *sealKeyProvider) Wrap(ctx context.Context, key []byte) (string, error) {
+	sealed, err := sealGCM(p.aead, key)
+	if err != nil {
+		return "", err
+	}
+	return sealWrapPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (p *sealKeyProvider) Unwrap(ctx context.Context, wrappedKey string) ([]byte, error) {
+	if !strings.HasPrefix(wrappedKey, sealWrapPrefix) {
+		return nil, errors.New("key was not wrapped by this seal")
+	}
+	data, err := base64.StdEncoding.DecodeString(wrappedKey[len(sealWrapPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	return openGCM(p.aead, data)
+}
+
+func (vault Vault) seal() (*Seal, error) {
+	seal, ok := vault.Priv.(*Seal)
+	if !ok {
+		return nil, &NotSupportedError{Msg: "the vault is not configured with a seal"}
+	}
+	return seal, nil
+}
+
+// IsSealed reports whether the vault is waiting to be unsealed. Vaults without a seal are
+// never sealed.
+func (vault Vault) IsSealed() bool {
+	seal, err := vault.seal()
+	return err == nil && seal.IsSealed()
+}
+
+func (vault Vault) InitSeal(ctx context.Context, shares int, threshold int) ([]string, error) {
+	seal, err := vault.seal()
+	if err != nil {
+		return nil, err
+	}
+	keyShares, err := seal.Init(ctx, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+	encodedShares := make([]string, len(keyShares))
+	for i, share := range keyShares {
+		encodedShares[i] = base64.StdEncoding.EncodeToString(share)
+	}
+	return encodedShares, nil
+}
+
+func (vault Vault) Unseal(ctx context.Context, encodedShare string) (*SealStatus, error) {
+	seal, err := vault.seal()
+	if err != nil {
+		return nil, err
+	}
+	share, err := base64.StdEncoding.DecodeString(encodedShare)
+	if err != nil {
+		return nil, &ValueError{Msg: "key share must be base64 encoded"}
+	}
+	return seal.Unseal(ctx, share)
+}
+
+func (vault Vault) GetSealStatus(ctx context.Context) (*SealStatus, error) {
+	seal, err := vault.seal()
+	if err != nil {
+		return &SealStatus{Initialized: true, Sealed: false}, nil
+	}
+	return seal.Status(ctx)
+}
+
+// Seal wipes the master key and all keys derived from it from memory.
+func (vault Vault) Seal(ctx context.Context, principal Principal) error {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, SEAL_PPATH}); err != nil {
+		return err
+	}
+	seal, err := vault.seal()
+	if err != nil {
+		return err
+	}
+	seal.Seal()
+	if vault.Keyrings != nil {
+		vault.Keyrings.clear()
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeal(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	signer, _ := NewHMACSigner([]byte("testkey"))
+	seal := NewSeal(db, func(ctx context.Context, provider KeyProvider) (Privatiser, Signer, error) {
+		keyring, err := NewKeyring(ctx, db, "data", provider, nil)
+		return keyring, signer, err
+	})
+	vault.Priv, vault.Signer = seal, seal
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+	t.Run("is sealed until initialised and unsealed", func(t *testing.T) {
+		status, err := vault.GetSealStatus(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &SealStatus{Initialized: false, Sealed: true}, status)
+
+		_, err = vault.Priv.Encrypt("hello")
+		var se *SealedError
+		assert.ErrorAs(t, err, &se)
+	})
+
+	keys, err := vault.InitSeal(ctx, 3, 2)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 3)
+
+	t.Run("cannot be initialised twice", func(t *testing.T) {
+		_, err := vault.InitSeal(ctx, 3, 2)
+		var ce *ConflictError
+		assert.ErrorAs(t, err, &ce)
+	})
+
+	t.Run("rejects invalid key shares", func(t *testing.T) {
+		otherKeys, _ := SplitSecret([]byte("0123456789abcdef0123456789abcdef"), 3, 2)
+		_, _ = vault.Unseal(ctx, keys[0])
+		_, err := seal.Unseal(ctx, otherKeys[1])
+		assert.Error(t, err)
+		assert.True(t, vault.IsSealed())
+	})
+
+	t.Run("can unseal with threshold key shares", func(t *testing.T) {
+		status, err := vault.Unseal(ctx, keys[2])
+		assert.NoError(t, err)
+		assert.Equal(t, 1, status.Progress)
+		assert.True(t, status.Sealed)
+
+		status, err = vault.Unseal(ctx, keys[0])
+		assert.NoError(t, err)
+		assert.False(t, status.Sealed)
+
+		encrypted, err := vault.Priv.Encrypt("hello")
+		assert.NoError(t, err)
+		decrypted, _ := vault.Priv.Decrypt(encrypted)
+		assert.Equal(t, "hello", decrypted)
+	})
+
+	t.Run("can be sealed again", func(t *testing.T) {
+		assert.NoError(t, vault.Seal(ctx, rootPrincipal))
+		assert.True(t, vault.IsSealed())
+
+		_, err := vault.GetKeyringStatus(ctx, rootPrincipal)
+		var se *SealedError
+		assert.ErrorAs(t, err, &se)
+	})
+}
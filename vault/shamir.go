@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+)
+
+// Shamir's secret sharing over GF(2^8). Each byte of the secret is the constant term of a
+// random polynomial of degree threshold-1; a share holds the evaluation of every polynomial
+// at a distinct non-zero x, which is appended as the last byte of the share.
+
+// gf256Exp and gf256Log are exponent and logarithm tables for the generator 3 under the AES
+// reduction polynomial x^8 + x^4 + x^3 + x + 1.
+var gf256Exp, gf256Log = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		log[x] = byte(i)
+		// Multiply by the generator: x*3 = x*2 ^ x
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x = x2 ^ x
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gf256Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+int(gf256Log[b])]
+}
+
+func gf256Div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+255-int(gf256Log[b])]
+}
+
+// SplitSecret splits secret into the given number of shares, any threshold of which can
+// reconstruct it with CombineShares.
+func SplitSecret(secret []byte, shares int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret cannot be empty")
+	}
+	if threshold < 1 || shares < threshold || shares > 255 {
+		return nil, &ValueError{Msg: "shares must be between threshold and 255, and threshold at least 1"}
+	}
+
+	result := make([][]byte, shares)
+	for i := range result {
+		result[i] = make([]byte, len(secret)+1)
+		result[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, secretByte := range secret {
+		coefficients[0] = secretByte
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range result {
+			x := byte(i + 1)
+			// Horner's method
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gf256Mul(y, x) ^ coefficients[c]
+			}
+			result[i][b] = y
+		}
+	}
+	return result, nil
+}
+
+// CombineShares reconstructs a secret from shares using Lagrange interpolation at x = 0. Too
+// few shares produce a wrong secret rather than an error, so callers must verify the result.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("at least one share is required")
+	}
+	shareLen := len(shares[0])
+	if shareLen < 2 {
+		return nil, &ValueError{Msg: "invalid share"}
+	}
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != shareLen {
+			return nil, &ValueError{Msg: "shares must all be the same length"}
+		}
+		xs[i] = share[shareLen-1]
+		if xs[i] == 0 {
+			return nil, &ValueError{Msg: "invalid share"}
+		}
+		for j := 0; j < i; j++ {
+			if subtle.ConstantTimeByteEq(xs[i], xs[j]) == 1 {
+				return nil, &ValueError{Msg: "duplicate share"}
+			}
+		}
+	}
+
+	secret := make([]byte, shareLen-1)
+	for i, share := range shares {
+		// Lagrange basis polynomial of share i evaluated at 0
+		basis := byte(1)
+		for j := range shares {
+			if i == j {
+				continue
+			}
+			basis = gf256Mul(basis, gf256Div(xs[j], xs[i]^xs[j]))
+		}
+		for b := range secret {
+			secret[b] ^= gf256Mul(share[b], basis)
+		}
+	}
+	return secret, nil
+}
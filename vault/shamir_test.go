@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShamir(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	t.Run("any threshold shares reconstruct the secret", func(t *testing.T) {
+		shares, err := SplitSecret(secret, 5, 3)
+		assert.NoError(t, err)
+		assert.Len(t, shares, 5)
+
+		for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+			var selected [][]byte
+			for _, i := range subset {
+				selected = append(selected, shares[i])
+			}
+			combined, err := CombineShares(selected)
+			assert.NoError(t, err)
+			assert.Equal(t, secret, combined)
+		}
+	})
+
+	t.Run("fewer than threshold shares do not reconstruct the secret", func(t *testing.T) {
+		shares, _ := SplitSecret(secret, 5, 3)
+		combined, err := CombineShares(shares[:2])
+		assert.NoError(t, err)
+		assert.NotEqual(t, secret, combined)
+	})
+
+	t.Run("rejects duplicate shares", func(t *testing.T) {
+		shares, _ := SplitSecret(secret, 3, 2)
+		_, err := CombineShares([][]byte{shares[0], shares[0]})
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		_, err := SplitSecret(secret, 2, 3)
+		assert.Error(t, err)
+		_, err = SplitSecret(secret, 256, 3)
+		assert.Error(t, err)
+		_, err = SplitSecret(secret, 3, 0)
+		assert.Error(t, err)
+	})
+}
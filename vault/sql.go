@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -78,6 +79,42 @@ func (dbToken) TableName() string {
 	return "tokens"
 }
 
+type dbKeyringKey struct {
+	Keyring    string `gorm:"primaryKey"`
+	Version    int    `gorm:"primaryKey;autoIncrement:false"`
+	WrappedKey string
+	CreatedAt  time.Time
+}
+
+func (dbKeyringKey) TableName() string {
+	return "keyring_keys"
+}
+
+type dbSubjectKey struct {
+	SubjectId  string `gorm:"primaryKey"`
+	Collection string `gorm:"index"`
+	WrappedKey string
+	CreatedAt  time.Time
+	ShreddedAt *time.Time
+}
+
+func (dbSubjectKey) TableName() string {
+	return "subject_keys"
+}
+
+// dbSealConfig has a single row, its id is always 1.
+type dbSealConfig struct {
+	Id           int `gorm:"primaryKey;autoIncrement:false"`
+	Shares       int
+	Threshold    int
+	Verification string
+	CreatedAt    time.Time
+}
+
+func (dbSealConfig) TableName() string {
+	return "seal_config"
+}
+
 type dbPrincipalPolicy struct {
 	PrincipalId string `gorm:"primaryKey;autoIncrement:false;column:principal_id"`
 	PolicyId    string `gorm:"primaryKey;autoIncrement:false;column:policy_id"`
@@ -113,13 +150,19 @@ func (f FieldSchemaMap) Value() (driver.Value, error) {
 }
 
 type dbCollectionMetadata struct {
-	Id          string `gorm:"primaryKey"`
-	Name        string `gorm:"unique"`
-	Description string
-	Parent      string
-	FieldSchema FieldSchemaMap `gorm:"type:json"` // Ensures JSON storage
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	Id           string `gorm:"primaryKey"`
+	Name         string `gorm:"unique"`
+	Description  string
+	Parent       string
+	FieldSchema  FieldSchemaMap `gorm:"type:json"` // Ensures JSON storage
+	DataKey      string
+	WriteOnly    bool
+	PublicKey    string
+	PrivateKey   string
+	Strict       bool
+	IndexVersion int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 func (dbCollectionMetadata) TableName() string {
@@ -128,11 +171,63 @@ func (dbCollectionMetadata) TableName() string {
 
 func (st *SqlStore) CreateSchemas() error {
 	// Use GORM's automigrate to create tables
-	err := st.db.AutoMigrate(&dbPrincipal{}, &dbPolicy{}, &dbPrincipalPolicy{}, &dbToken{}, &dbCollectionMetadata{})
+	err := st.db.AutoMigrate(&dbPrincipal{}, &dbPolicy{}, &dbPrincipalPolicy{}, &dbToken{}, &dbCollectionMetadata{}, &dbKeyringKey{}, &dbSealConfig{}, &dbSubjectKey{})
 	if err != nil {
 		return err
 	}
 
+	if err := st.migrateBlindIndexes(); err != nil {
+		return err
+	}
+	return st.migrateRecordMACs()
+}
+
+func blindIndexQuery(tableName string, fieldName string) string {
+	column := blindIndexColumn(fieldName)
+	return `CREATE INDEX IF NOT EXISTS ` + tableName + `_` + column + `_index ON ` + tableName + ` (` + column + `);`
+}
+
+// migrateBlindIndexes adds blind index columns to collections created before indexed fields
+// were searched by blind index. The indexes of existing rows are computed by
+// Vault.BackfillBlindIndexes once the vault's keys are loaded.
+func (st *SqlStore) migrateBlindIndexes() error {
+	var collectionMetadatas []dbCollectionMetadata
+	if err := st.db.Find(&collectionMetadatas).Error; err != nil {
+		return err
+	}
+
+	for _, collectionMetadata := range collectionMetadatas {
+		tableName := "collection_" + collectionMetadata.Name
+		for fieldName, field := range collectionMetadata.FieldSchema {
+			if !field.IsIndexed || fieldName == subject_id_field {
+				continue
+			}
+			query := `ALTER TABLE ` + tableName + ` ADD COLUMN IF NOT EXISTS ` + blindIndexColumn(fieldName) + ` TEXT;`
+			query += blindIndexQuery(tableName, fieldName)
+			if err := st.db.Exec(query).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateRecordMACs adds the MAC column to collections created before records were signed.
+// Existing rows are signed by the next rekey job.
+func (st *SqlStore) migrateRecordMACs() error {
+	var collectionMetadatas []dbCollectionMetadata
+	if err := st.db.Find(&collectionMetadatas).Error; err != nil {
+		return err
+	}
+
+	for _, collectionMetadata := range collectionMetadatas {
+		query := `ALTER TABLE collection_` + collectionMetadata.Name + ` ADD COLUMN IF NOT EXISTS ` + record_mac_field + ` TEXT;`
+		if err := st.db.Exec(query).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -148,11 +243,17 @@ func (st *SqlStore) CreateCollection(ctx context.Context, c *Collection) error {
 	}
 
 	collectionMetadata := dbCollectionMetadata{
-		Id:          c.Id,
-		Name:        c.Name,
-		Description: c.Description,
-		Parent:      c.Parent,
-		FieldSchema: c.Fields,
+		Id:           c.Id,
+		Name:         c.Name,
+		Description:  c.Description,
+		Parent:       c.Parent,
+		FieldSchema:  c.Fields,
+		DataKey:      c.DataKey,
+		WriteOnly:    c.WriteOnly,
+		PublicKey:    c.PublicKey,
+		PrivateKey:   c.PrivateKey,
+		Strict:       c.Strict,
+		IndexVersion: c.IndexVersion,
 	}
 
 	result := tx.Create(&collectionMetadata)
@@ -189,6 +290,14 @@ func (st *SqlStore) CreateCollection(ctx context.Context, c *Collection) error {
 			return &ValueError{Msg: fmt.Sprintf("field name '%s' is not alphanumeric", fieldName)}
 		}
 
+		if strings.HasSuffix(fieldName, blind_index_suffix) {
+			return &ValueError{Msg: fmt.Sprintf("field name '%s' uses the reserved suffix '%s'", fieldName, blind_index_suffix)}
+		}
+
+		if fieldName == record_mac_field {
+			return &ValueError{Msg: fmt.Sprintf("field name '%s' is reserved", fieldName)}
+		}
+
 		if fieldName == subject_id_field {
 			// Already handled above
 			continue
@@ -196,11 +305,12 @@ func (st *SqlStore) CreateCollection(ctx context.Context, c *Collection) error {
 
 		query += `, ` + fieldName + ` TEXT`
 		if c.Fields[fieldName].IsIndexed {
-			indexQueries += `CREATE INDEX IF NOT EXISTS ` + fieldName + `_index ON ` + tableName + ` (` + fieldName + `);`
+			query += `, ` + blindIndexColumn(fieldName) + ` TEXT`
+			indexQueries += blindIndexQuery(tableName, fieldName)
 		}
 
 	}
-	query += `, created_at TIMESTAMP WITH TIME ZONE, updated_at TIMESTAMP WITH TIME ZONE)`
+	query += `, created_at TIMESTAMP WITH TIME ZONE, updated_at TIMESTAMP WITH TIME ZONE, ` + record_mac_field + ` TEXT)`
 	query += `;` + indexQueries
 
 	result = tx.Exec(query)
@@ -247,13 +357,19 @@ func (st SqlStore) GetCollection(ctx context.Context, name string) (*Collection,
 	}
 
 	return &Collection{
-		Id:          dbCollectionMetadata.Id,
-		Name:        dbCollectionMetadata.Name,
-		Description: dbCollectionMetadata.Description,
-		Parent:      dbCollectionMetadata.Parent,
-		Fields:      dbCollectionMetadata.FieldSchema,
-		CreatedAt:   dbCollectionMetadata.CreatedAt,
-		UpdatedAt:   dbCollectionMetadata.UpdatedAt,
+		Id:           dbCollectionMetadata.Id,
+		Name:         dbCollectionMetadata.Name,
+		Description:  dbCollectionMetadata.Description,
+		Parent:       dbCollectionMetadata.Parent,
+		Fields:       dbCollectionMetadata.FieldSchema,
+		DataKey:      dbCollectionMetadata.DataKey,
+		WriteOnly:    dbCollectionMetadata.WriteOnly,
+		PublicKey:    dbCollectionMetadata.PublicKey,
+		PrivateKey:   dbCollectionMetadata.PrivateKey,
+		Strict:       dbCollectionMetadata.Strict,
+		IndexVersion: dbCollectionMetadata.IndexVersion,
+		CreatedAt:    dbCollectionMetadata.CreatedAt,
+		UpdatedAt:    dbCollectionMetadata.UpdatedAt,
 	}, nil
 }
 
@@ -270,6 +386,63 @@ func (st SqlStore) GetCollections(ctx context.Context) ([]string, error) {
 	return collectionNames, nil
 }
 
+// UpdateCollectionDataKey replaces the wrapped data key of a collection, provided it is still current.
+func (st SqlStore) UpdateCollectionDataKey(ctx context.Context, name string, current string, dataKey string) error {
+	query := st.db.Model(&dbCollectionMetadata{}).Where("name = ?", name)
+	if current == "" {
+		// Collections created before data keys were introduced have none
+		query = query.Where("data_key = '' OR data_key IS NULL")
+	} else {
+		query = query.Where("data_key = ?", current)
+	}
+	result := query.Update("data_key", dataKey)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ConflictError{name}
+	}
+	return nil
+}
+
+// UpdateCollectionPrivateKey replaces the wrapped private key of a write-only collection, provided
+// it is still current.
+func (st SqlStore) UpdateCollectionPrivateKey(ctx context.Context, name string, current string, privateKey string) error {
+	result := st.db.Model(&dbCollectionMetadata{}).Where("name = ? AND private_key = ?", name, current).Update("private_key", privateKey)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ConflictError{name}
+	}
+	return nil
+}
+
+// SetCollectionStrict marks a collection whose records have all been migrated as strict.
+func (st SqlStore) SetCollectionStrict(ctx context.Context, name string) error {
+	result := st.db.Model(&dbCollectionMetadata{}).Where("name = ?", name).Update("strict", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &NotFoundError{"collection", name}
+	}
+	return nil
+}
+
+// SetCollectionIndexVersion records the version of the blind indexes of a collection's records
+// once they have all been recomputed.
+func (st SqlStore) SetCollectionIndexVersion(ctx context.Context, name string, version int) error {
+	result := st.db.Model(&dbCollectionMetadata{}).Where("name = ?", name).Update("index_version", version)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &NotFoundError{"collection", name}
+	}
+	return nil
+}
+
 func (st SqlStore) DeleteCollection(ctx context.Context, name string) error {
 	if !validateInput(name) {
 		return &ValueError{Msg: fmt.Sprintf("Invalid collection name %s", name)}
@@ -293,6 +466,12 @@ func (st SqlStore) DeleteCollection(ctx context.Context, name string) error {
 		return &NotFoundError{"collection", name}
 	}
 
+	// Keys of the collection's subjects are wrapped by its data key, which is gone
+	result = tx.Where("collection = ?", name).Delete(&dbSubjectKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+
 	// Drop collection table
 	result = tx.Exec(`DROP TABLE IF EXISTS collection_` + name)
 	if result.Error != nil {
@@ -342,6 +521,39 @@ func (st SqlStore) GetRecords(ctx context.Context, collectionName string) ([]str
 	return recordIds, nil
 }
 
+// GetUnindexedRecords returns the ids of the records with a value in an indexed field but no
+// blind index for it, which were written before indexed fields were searched by blind index.
+func (st SqlStore) GetUnindexedRecords(ctx context.Context, collectionName string) ([]string, error) {
+	if !validateInput(collectionName) {
+		return nil, &ValueError{Msg: fmt.Sprintf("Invalid collection name %s", collectionName)}
+	}
+
+	fields, err := getCollectionFields(ctx, st.db, collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []string
+	for fieldName, field := range fields {
+		if !field.IsIndexed || fieldName == subject_id_field {
+			continue
+		}
+		column := blindIndexColumn(fieldName)
+		conditions = append(conditions, `(`+fieldName+` <> '' AND (`+column+` IS NULL OR `+column+` = ''))`)
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	var recordIds []string
+	result := st.db.Table(fmt.Sprintf("collection_%s", collectionName)).Where(strings.Join(conditions, " OR ")).Pluck("id", &recordIds)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return recordIds, nil
+}
+
 func (st SqlStore) GetRecord(ctx context.Context, collectionName string, recordID string) (Record, error) {
 	if !validateInput(collectionName) {
 		return nil, &ValueError{Msg: fmt.Sprintf("Invalid collection name %s", collectionName)}
@@ -405,8 +617,20 @@ func (st SqlStore) SearchRecords(ctx context.Context, collectionName string, fil
 		}
 	}
 
+	indexFilters := make(map[string]interface{})
+	for fieldName, value := range filters {
+		if fieldName == subject_id_field {
+			indexFilters[fieldName] = value
+			continue
+		}
+		if !collectionFields[fieldName].IsIndexed {
+			return nil, &ValueError{Msg: fmt.Sprintf("Field %s is not indexed and can't be searched", fieldName)}
+		}
+		indexFilters[blindIndexColumn(fieldName)] = value
+	}
+
 	var recordIds []string
-	result := st.db.Table(fmt.Sprintf("collection_%s", collectionName)).Where(filters).Pluck("id", &recordIds)
+	result := st.db.Table(fmt.Sprintf("collection_%s", collectionName)).Where(indexFilters).Pluck("id", &recordIds)
 	if result.Error != nil {
 		// TODO: better error handling here, we should check fields and collection existence
 		return nil, result.Error
@@ -426,20 +650,21 @@ func (st SqlStore) UpdateRecord(ctx context.Context, collectionName string, reco
 		return err
 	}
 
-	newRecord := make(map[string]interface{})
-	newRecord["id"] = recordID
-	for fieldName := range fields {
-		if fieldValue, ok := record[fieldName]; !ok {
-			return &ValueError{Msg: fmt.Sprintf("Field %s is missing from the record", fieldName)}
-		} else {
-			newRecord[fieldName] = fieldValue
+	// Only the fields in the record are updated, the others keep their values
+	columns := map[string]bool{record_mac_field: true}
+	for fieldName, field := range fields {
+		columns[fieldName] = true
+		if field.IsIndexed && fieldName != subject_id_field {
+			columns[blindIndexColumn(fieldName)] = true
 		}
 	}
 
-	for fieldName := range record {
-		if _, ok := fields[fieldName]; !ok {
+	newRecord := make(map[string]interface{})
+	for fieldName, fieldValue := range record {
+		if !columns[fieldName] {
 			return &ValueError{Msg: fmt.Sprintf("Field %s is not existent in the schema", fieldName)}
 		}
+		newRecord[fieldName] = fieldValue
 	}
 
 	result := st.db.Table(fmt.Sprintf("collection_%s", collectionName)).Where("id = ?", recordID).Updates(newRecord)
@@ -453,6 +678,42 @@ func (st SqlStore) UpdateRecord(ctx context.Context, collectionName string, reco
 	return nil
 }
 
+// UpdateRecordIfUnchanged replaces the stored values of a record, provided its fields still hold
+// the values in current. A ConflictError is returned if the record was modified in the meantime.
+func (st SqlStore) UpdateRecordIfUnchanged(ctx context.Context, collectionName string, recordID string, current Record, record Record) error {
+	if !validateInput(collectionName) {
+		return &ValueError{Msg: fmt.Sprintf("Invalid collection name %s", collectionName)}
+	}
+
+	query := st.db.Table(fmt.Sprintf("collection_%s", collectionName)).Where("id = ?", recordID)
+	newRecord := make(map[string]interface{})
+	for fieldName, value := range record {
+		if !validateInput(fieldName) {
+			return &ValueError{Msg: fmt.Sprintf("Invalid field name %s", fieldName)}
+		}
+		newRecord[fieldName] = value
+		currentValue, ok := current[fieldName]
+		switch {
+		case !ok:
+		case currentValue == "":
+			// Columns added by migrations are NULL until the row is rewritten
+			query = query.Where(`(` + fieldName + ` IS NULL OR ` + fieldName + ` = '')`)
+		default:
+			query = query.Where(map[string]interface{}{fieldName: currentValue})
+		}
+	}
+
+	result := query.Updates(newRecord)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ConflictError{recordID}
+	}
+
+	return nil
+}
+
 func (st SqlStore) DeleteRecord(ctx context.Context, collectionName string, recordID string) error {
 	if !validateInput(collectionName) {
 		return &ValueError{Msg: fmt.Sprintf("Invalid collection name %s", collectionName)}
@@ -544,6 +805,18 @@ func (st SqlStore) CreatePrincipal(ctx context.Context, principal *Principal) er
 	return nil
 }
 
+// UpdatePrincipalPassword replaces the stored password of a principal, provided it is still current.
+func (st SqlStore) UpdatePrincipalPassword(ctx context.Context, username string, current string, password string) error {
+	result := st.db.Model(&dbPrincipal{}).Where("username = ? AND password = ?", username, current).Update("password", password)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ConflictError{username}
+	}
+	return nil
+}
+
 func (st SqlStore) DeletePrincipal(ctx context.Context, id string) error {
 	tx := st.db.Begin()
 
@@ -657,6 +930,14 @@ func (st SqlStore) CreatePolicy(ctx context.Context, p *Policy) error {
 	return nil
 }
 
+// AddPolicyAction allows an action in a policy, unless it already does.
+func (st SqlStore) AddPolicyAction(ctx context.Context, policyId string, action PolicyAction) error {
+	result := st.db.Model(&dbPolicy{}).
+		Where("id = ? AND NOT (? = ANY(actions))", policyId, string(action)).
+		Update("actions", gorm.Expr("array_append(actions, ?)", string(action)))
+	return result.Error
+}
+
 func (st SqlStore) DeletePolicy(ctx context.Context, policyID string) error {
 	tx := st.db.Begin()
 
@@ -710,6 +991,177 @@ func (st SqlStore) GetTokenValue(ctx context.Context, tokenId string) (string, e
 	return dbToken.Value, err
 }
 
+func (st SqlStore) GetKeyringKeys(ctx context.Context, keyring string) ([]*KeyringKey, error) {
+	var dbKeys []dbKeyringKey
+	if err := st.db.Where("keyring = ?", keyring).Order("version").Find(&dbKeys).Error; err != nil {
+		return nil, err
+	}
+
+	keys := make([]*KeyringKey, len(dbKeys))
+	for i, dbKey := range dbKeys {
+		keys[i] = &KeyringKey{
+			Keyring:    dbKey.Keyring,
+			Version:    dbKey.Version,
+			WrappedKey: dbKey.WrappedKey,
+			CreatedAt:  dbKey.CreatedAt,
+		}
+	}
+	return keys, nil
+}
+
+func (st SqlStore) CreateKeyringKey(ctx context.Context, key *KeyringKey) error {
+	dbKey := dbKeyringKey{
+		Keyring:    key.Keyring,
+		Version:    key.Version,
+		WrappedKey: key.WrappedKey,
+		CreatedAt:  key.CreatedAt,
+	}
+	if err := st.db.Create(&dbKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &ConflictError{fmt.Sprintf("%s key version %d", key.Keyring, key.Version)}
+		}
+		return err
+	}
+	return nil
+}
+
+func (st SqlStore) GetKeyrings(ctx context.Context) ([]string, error) {
+	var keyrings []string
+	if err := st.db.Model(&dbKeyringKey{}).Distinct().Order("keyring").Pluck("keyring", &keyrings).Error; err != nil {
+		return nil, err
+	}
+	return keyrings, nil
+}
+
+// UpdateKeyringKey replaces the wrapped key of a keyring key version, provided it is still current.
+func (st SqlStore) UpdateKeyringKey(ctx context.Context, key *KeyringKey, current string) error {
+	result := st.db.Model(&dbKeyringKey{}).
+		Where("keyring = ? AND version = ? AND wrapped_key = ?", key.Keyring, key.Version, current).
+		Update("wrapped_key", key.WrappedKey)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ConflictError{fmt.Sprintf("%s key version %d", key.Keyring, key.Version)}
+	}
+	return nil
+}
+
+func (st SqlStore) GetSubjectKey(ctx context.Context, subjectId string) (*SubjectKey, error) {
+	var dbKey dbSubjectKey
+	if err := st.db.Where("subject_id = ?", subjectId).First(&dbKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &NotFoundError{"subject key", subjectId}
+		}
+		return nil, err
+	}
+	return &SubjectKey{
+		SubjectId:  dbKey.SubjectId,
+		Collection: dbKey.Collection,
+		WrappedKey: dbKey.WrappedKey,
+		CreatedAt:  dbKey.CreatedAt,
+		ShreddedAt: dbKey.ShreddedAt,
+	}, nil
+}
+
+func (st SqlStore) CreateSubjectKey(ctx context.Context, key *SubjectKey) error {
+	dbKey := dbSubjectKey{
+		SubjectId:  key.SubjectId,
+		Collection: key.Collection,
+		WrappedKey: key.WrappedKey,
+		CreatedAt:  key.CreatedAt,
+	}
+	if err := st.db.Create(&dbKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &ConflictError{fmt.Sprintf("subject key %s", key.SubjectId)}
+		}
+		return err
+	}
+	return nil
+}
+
+// GetSubjectKeys returns the keys of the subjects of a collection, including shredded ones.
+func (st SqlStore) GetSubjectKeys(ctx context.Context, collectionName string) ([]*SubjectKey, error) {
+	var dbKeys []dbSubjectKey
+	if err := st.db.Where("collection = ?", collectionName).Find(&dbKeys).Error; err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SubjectKey, len(dbKeys))
+	for i, dbKey := range dbKeys {
+		keys[i] = &SubjectKey{
+			SubjectId:  dbKey.SubjectId,
+			Collection: dbKey.Collection,
+			WrappedKey: dbKey.WrappedKey,
+			CreatedAt:  dbKey.CreatedAt,
+			ShreddedAt: dbKey.ShreddedAt,
+		}
+	}
+	return keys, nil
+}
+
+// ClearSubjectKey erases the wrapped key of a subject once it has been moved to a
+// SubjectKeyStore, provided it is still current.
+func (st SqlStore) ClearSubjectKey(ctx context.Context, subjectId string, current string) error {
+	result := st.db.Model(&dbSubjectKey{}).
+		Where("subject_id = ? AND wrapped_key = ?", subjectId, current).
+		Update("wrapped_key", "")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ConflictError{fmt.Sprintf("subject key %s", subjectId)}
+	}
+	return nil
+}
+
+// ShredSubjectKey erases the key of a subject, keeping the row as a record of when it was shredded.
+func (st SqlStore) ShredSubjectKey(ctx context.Context, subjectId string, shreddedAt time.Time) error {
+	result := st.db.Model(&dbSubjectKey{}).
+		Where("subject_id = ? AND shredded_at IS NULL", subjectId).
+		Updates(map[string]interface{}{"wrapped_key": "", "shredded_at": shreddedAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &NotFoundError{"subject key", subjectId}
+	}
+	return nil
+}
+
+func (st SqlStore) GetSealConfig(ctx context.Context) (*SealConfig, error) {
+	var dbConfig dbSealConfig
+	if err := st.db.First(&dbConfig, 1).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &NotFoundError{"seal", "config"}
+		}
+		return nil, err
+	}
+	return &SealConfig{
+		Shares:       dbConfig.Shares,
+		Threshold:    dbConfig.Threshold,
+		Verification: dbConfig.Verification,
+		CreatedAt:    dbConfig.CreatedAt,
+	}, nil
+}
+
+func (st SqlStore) CreateSealConfig(ctx context.Context, config *SealConfig) error {
+	dbConfig := dbSealConfig{
+		Id:           1,
+		Shares:       config.Shares,
+		Threshold:    config.Threshold,
+		Verification: config.Verification,
+		CreatedAt:    config.CreatedAt,
+	}
+	if err := st.db.Create(&dbConfig).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return &ConflictError{"vault is already initialised"}
+		}
+		return err
+	}
+	return nil
+}
+
 func (st SqlStore) Flush(ctx context.Context) error {
 	tables, err := st.db.Migrator().GetTables()
 	for _, table := range tables {
@@ -0,0 +1,330 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Ciphertexts encrypted with a subject key are formatted as "v4:<base64 payload>", or
+// "v6:<base64 payload>" when they are bound to associated data.
+const (
+	subjectKeyCiphertextPrefix      = "v4:"
+	boundSubjectKeyCiphertextPrefix = "v6:"
+)
+
+// SubjectKey is the key a subject, a record of a collection without a parent, and every record
+// referencing it through subject_id are encrypted with. It is wrapped by the data key of the
+// subject's collection and kept in the vault's SubjectKeyStore, outside of the database, whose
+// row only records when it was created and shredded. Shredding a subject destroys its key, after
+// which its records can't be decrypted from any copy of the database.
+type SubjectKey struct {
+	SubjectId  string
+	Collection string
+	WrappedKey string
+	CreatedAt  time.Time
+	ShreddedAt *time.Time
+}
+
+// DestructionCertificate records that a subject was shredded. It is signed by the vault so that
+// it can't be altered once issued. Subjects created before subject keys were introduced have no
+// key to destroy: their records are deleted, but copies of them can still be decrypted, which
+// the certificate states with KeyDestroyed.
+type DestructionCertificate struct {
+	Id           string     `json:"id"`
+	Collection   string     `json:"collection"`
+	SubjectId    string     `json:"subject_id"`
+	ShreddedBy   string     `json:"shredded_by"`
+	KeyDestroyed bool       `json:"key_destroyed"`
+	KeyCreatedAt *time.Time `json:"key_created_at,omitempty"`
+	ShreddedAt   time.Time  `json:"shredded_at"`
+	Signature    string     `json:"signature"`
+}
+
+func (c DestructionCertificate) message() string {
+	keyCreatedAt := ""
+	if c.KeyCreatedAt != nil {
+		keyCreatedAt = c.KeyCreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf(
+		"cert:%s/%s/%s/%s/%t/%s/%s",
+		c.Id,
+		c.Collection,
+		c.SubjectId,
+		c.ShreddedBy,
+		c.KeyDestroyed,
+		keyCreatedAt,
+		c.ShreddedAt.UTC().Format(time.RFC3339Nano),
+	)
+}
+
+// createSubjectKey generates the key of a subject, wrapped with the privatiser of its collection.
+func (vault Vault) createSubjectKey(ctx context.Context, col *Collection, colPriv Privatiser, subjectId string) error {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrappedKey, err := encryptWithAD(colPriv, base64.StdEncoding.EncodeToString(key), keyAssociatedData("subject key", subjectId))
+	if err != nil {
+		return err
+	}
+	if vault.SubjectKeys != nil {
+		if err := vault.SubjectKeys.Put(ctx, subjectId, wrappedKey); err != nil {
+			return err
+		}
+		wrappedKey = ""
+	}
+	return vault.Db.CreateSubjectKey(ctx, &SubjectKey{
+		SubjectId:  subjectId,
+		Collection: col.Name,
+		WrappedKey: wrappedKey,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// wrappedSubjectKey returns the wrapped key of a subject, which is kept in the subject key store
+// unless it was created without one.
+func (vault Vault) wrappedSubjectKey(ctx context.Context, subjectKey *SubjectKey) (string, error) {
+	if subjectKey.WrappedKey != "" {
+		return subjectKey.WrappedKey, nil
+	}
+	if vault.SubjectKeys == nil {
+		return "", errors.New("the vault has no subject key store")
+	}
+	wrappedKey, err := vault.SubjectKeys.Get(ctx, subjectKey.SubjectId)
+	if err != nil {
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			// Destroyed by a shred that didn't complete
+			return "", &ShreddedError{subjectKey.SubjectId}
+		}
+		return "", err
+	}
+	return wrappedKey, nil
+}
+
+// MoveSubjectKeys moves the subject keys created before the vault had a subject key store out of
+// the database, so that they can't be recovered from its backups once their subject is shredded.
+// It returns the number of keys moved.
+func (vault Vault) MoveSubjectKeys(ctx context.Context) (int, error) {
+	if vault.SubjectKeys == nil {
+		return 0, nil
+	}
+	collectionNames, err := vault.Db.GetCollections(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, collectionName := range collectionNames {
+		subjectKeys, err := vault.Db.GetSubjectKeys(ctx, collectionName)
+		if err != nil {
+			return moved, err
+		}
+		for _, subjectKey := range subjectKeys {
+			if subjectKey.WrappedKey == "" || subjectKey.ShreddedAt != nil {
+				continue
+			}
+			if err := vault.SubjectKeys.Put(ctx, subjectKey.SubjectId, subjectKey.WrappedKey); err != nil {
+				return moved, err
+			}
+			if err := vault.Db.ClearSubjectKey(ctx, subjectKey.SubjectId, subjectKey.WrappedKey); err != nil {
+				var ce *ConflictError
+				if !errors.As(err, &ce) {
+					return moved, err
+				}
+				// Moved by another instance, or shredded in the meantime
+				if current, err := vault.Db.GetSubjectKey(ctx, subjectKey.SubjectId); err == nil && current.ShreddedAt != nil {
+					if err := vault.SubjectKeys.Delete(ctx, subjectKey.SubjectId); err != nil {
+						return moved, err
+					}
+				}
+				continue
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// subjectOf returns the collection and id of the subject a record belongs to: the record
+// itself for collections without a parent, otherwise the subject of the record it references.
+func (vault Vault) subjectOf(ctx context.Context, col *Collection, record Record) (*Collection, string, error) {
+	subjectId := record["id"]
+	for col.Parent != "" {
+		parent, err := vault.Db.GetCollection(ctx, col.Parent)
+		if err != nil {
+			return nil, "", err
+		}
+		subjectId = record[subject_id_field]
+		if parent.Parent != "" {
+			if record, err = vault.Db.GetRecord(ctx, parent.Name, subjectId); err != nil {
+				return nil, "", err
+			}
+		}
+		col = parent
+	}
+	return col, subjectId, nil
+}
+
+// recordPrivatiser returns the privatiser for a record of a collection, which encrypts with the
+// key of the record's subject, and to the collection's public key for write-only collections.
+// Only bound values are decrypted in strict collections.
+func (vault Vault) recordPrivatiser(ctx context.Context, col *Collection, colPriv Privatiser, record Record) (Privatiser, error) {
+	priv, err := vault.subjectPrivatiser(ctx, col, colPriv, record)
+	if err != nil {
+		return nil, err
+	}
+	if col.Strict {
+		priv = boundPrivatiser{priv}
+	}
+	if !col.WriteOnly {
+		return priv, nil
+	}
+	return vault.writeOnlyPrivatiser(ctx, col, colPriv, priv)
+}
+
+// subjectPrivatiser returns the privatiser encrypting with the key of a record's subject.
+// Records of subjects created before subject keys were introduced are encrypted with colPriv,
+// the collection's privatiser, which is also the fallback.
+func (vault Vault) subjectPrivatiser(ctx context.Context, col *Collection, colPriv Privatiser, record Record) (Privatiser, error) {
+	key, err := vault.subjectKey(ctx, col, colPriv, record)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return colPriv, nil
+	}
+	return newKeyPrivatiser(key, colPriv, subjectKeyCiphertextPrefix, boundSubjectKeyCiphertextPrefix)
+}
+
+// subjectKey returns the key of a record's subject, or nil if the subject was created before
+// subject keys were introduced.
+func (vault Vault) subjectKey(ctx context.Context, col *Collection, colPriv Privatiser, record Record) ([]byte, error) {
+	subjectCol, subjectId, err := vault.subjectOf(ctx, col, record)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectKey, err := vault.Db.GetSubjectKey(ctx, subjectId)
+	if err != nil {
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if subjectKey.ShreddedAt != nil {
+		return nil, &ShreddedError{subjectId}
+	}
+
+	subjectColPriv := colPriv
+	if subjectCol.Name != col.Name {
+		if subjectColPriv, err = vault.collectionPrivatiser(subjectCol); err != nil {
+			return nil, err
+		}
+	}
+	wrappedKey, err := vault.wrappedSubjectKey(ctx, subjectKey)
+	if err != nil {
+		return nil, err
+	}
+	encodedKey, err := decryptWithAD(subjectColPriv, wrappedKey, keyAssociatedData("subject key", subjectId))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encodedKey)
+}
+
+// ShredSubject destroys the key of a subject and deletes it along with the records referencing
+// it. The key is destroyed first: even if deleting the records fails, or copies of them survive
+// in backups and replicas, they can no longer be decrypted. Subjects without a key are only
+// deleted.
+func (vault Vault) ShredSubject(
+	ctx context.Context,
+	principal Principal,
+	collectionName string,
+	subjectId string,
+) (*DestructionCertificate, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, fmt.Sprintf("%s/%s%s/%s", COLLECTIONS_PPATH, collectionName, RECORDS_PPATH, subjectId)}); err != nil {
+		return nil, err
+	}
+
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	if col.Parent != "" {
+		return nil, &ValueError{Msg: fmt.Sprintf("collection %s has a parent, its records are shredded with their subject in %s", collectionName, col.Parent)}
+	}
+
+	subjectKey, err := vault.Db.GetSubjectKey(ctx, subjectId)
+	var nf *NotFoundError
+	switch {
+	case errors.As(err, &nf):
+		// Subjects created before subject keys were introduced don't have one, they are
+		// only deleted
+		if _, err := vault.Db.GetRecord(ctx, collectionName, subjectId); err != nil {
+			return nil, err
+		}
+		subjectKey = nil
+	case err != nil:
+		return nil, err
+	case subjectKey.Collection != collectionName:
+		return nil, &NotFoundError{"subject", subjectId}
+	case subjectKey.ShreddedAt != nil:
+		return nil, &ShreddedError{subjectId}
+	}
+
+	// Files can only be found with the subject key, so before it's destroyed. Failing to find
+	// them leaves unreadable blobs behind, which doesn't stop the subject from being shredded.
+	files, err := vault.subjectFiles(ctx, col, subjectId)
+	if err != nil {
+		vault.Logger.Warn(fmt.Sprintf("Error finding the files of subject %s: %s", subjectId, err))
+	}
+
+	shreddedAt := time.Now()
+	if subjectKey != nil {
+		if vault.SubjectKeys != nil {
+			if err := vault.SubjectKeys.Delete(ctx, subjectId); err != nil {
+				return nil, err
+			}
+		}
+		if err := vault.Db.ShredSubjectKey(ctx, subjectId, shreddedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	// Records referencing the subject are deleted by cascade
+	if err := vault.Db.DeleteRecord(ctx, collectionName, subjectId); err != nil {
+		if !errors.As(err, &nf) {
+			return nil, err
+		}
+	}
+	for _, file := range files {
+		vault.deleteBlob(ctx, file)
+	}
+
+	certificate := &DestructionCertificate{
+		Id:           GenerateId("cert"),
+		Collection:   collectionName,
+		SubjectId:    subjectId,
+		ShreddedBy:   principal.Username,
+		KeyDestroyed: subjectKey != nil,
+		ShreddedAt:   shreddedAt,
+	}
+	if subjectKey != nil {
+		certificate.KeyCreatedAt = &subjectKey.CreatedAt
+	}
+	if certificate.Signature, err = vault.Signer.Sign(certificate.message()); err != nil {
+		return nil, err
+	}
+	if subjectKey == nil {
+		vault.Logger.Warn(fmt.Sprintf("Subject %s of collection %s was deleted by %s, it had no key to destroy", subjectId, collectionName, principal.Username))
+	} else {
+		vault.Logger.Info(fmt.Sprintf("Subject %s of collection %s was shredded by %s", subjectId, collectionName, principal.Username))
+	}
+	return certificate, nil
+}
@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// SubjectKeyStore keeps the wrapped keys of subjects outside of the database, so that copies of
+// the database taken before a subject was shredded can't be decrypted once its key is deleted
+// from the store. The store must not be included in the database's backups.
+type SubjectKeyStore interface {
+	Put(ctx context.Context, subjectId string, wrappedKey string) error
+	Get(ctx context.Context, subjectId string) (string, error)
+	Delete(ctx context.Context, subjectId string) error
+}
+
+// Subject ids are record ids, they are checked anyway as they become file names.
+var subjectIdPattern = regexp.MustCompile("^[A-Za-z0-9]+_[A-Za-z0-9]+$")
+
+// LocalSubjectKeyStore keeps subject keys as files in a directory of the local filesystem.
+type LocalSubjectKeyStore struct {
+	dir string
+}
+
+func NewLocalSubjectKeyStore(dir string) (*LocalSubjectKeyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalSubjectKeyStore{dir}, nil
+}
+
+func (s *LocalSubjectKeyStore) path(subjectId string) (string, error) {
+	if !subjectIdPattern.MatchString(subjectId) {
+		return "", &ValueError{Msg: fmt.Sprintf("invalid subject id %s", subjectId)}
+	}
+	return filepath.Join(s.dir, subjectId), nil
+}
+
+// Put writes a key to a temporary file first, so partially written keys are never visible.
+func (s *LocalSubjectKeyStore) Put(ctx context.Context, subjectId string, wrappedKey string) error {
+	path, err := s.path(subjectId)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := tmp.WriteString(wrappedKey); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalSubjectKeyStore) Get(ctx context.Context, subjectId string) (string, error) {
+	path, err := s.path(subjectId)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", &NotFoundError{"subject key", subjectId}
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(wrappedKey), nil
+}
+
+// Delete overwrites a key before removing its file, so that it doesn't linger in the blocks
+// freed by the filesystem.
+func (s *LocalSubjectKeyStore) Delete(ctx context.Context, subjectId string) error {
+	path, err := s.path(subjectId)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		_, err = file.WriteAt(make([]byte, info.Size()), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
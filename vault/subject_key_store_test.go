@@ -0,0 +1,37 @@
+package vault
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalSubjectKeyStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalSubjectKeyStore(dir)
+	assert.NoError(t, err)
+	subjectId := GenerateId("rec")
+
+	assert.NoError(t, store.Put(ctx, subjectId, "v5:wrapped key"))
+	wrappedKey, err := store.Get(ctx, subjectId)
+	assert.NoError(t, err)
+	assert.Equal(t, "v5:wrapped key", wrappedKey)
+
+	assert.NoError(t, store.Delete(ctx, subjectId))
+	_, err = store.Get(ctx, subjectId)
+	var notFoundErr *NotFoundError
+	assert.ErrorAs(t, err, &notFoundErr)
+	assert.NoError(t, store.Delete(ctx, subjectId))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	for _, id := range []string{"", "../rec_1", "rec_1/../../etc", ".key-1"} {
+		var valueErr *ValueError
+		assert.ErrorAs(t, store.Put(ctx, id, "v5:wrapped key"), &valueErr, id)
+	}
+}
@@ -0,0 +1,165 @@
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShredSubject(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	readerPrincipal := Principal{Username: "reader", Policies: []string{"read-all-customers"}}
+
+	customers := Collection{Name: "customers", Fields: map[string]Field{
+		"name": {Type: "name", IsIndexed: false},
+	}}
+	accounts := Collection{Name: "accounts", Parent: "customers", Fields: map[string]Field{
+		"iban": {Type: "string", IsIndexed: false},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &customers))
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &accounts))
+
+	createSubject := func(name string) (string, string) {
+		customerId, err := vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": name})
+		assert.NoError(t, err)
+		accountId, err := vault.CreateRecord(ctx, rootPrincipal, "accounts", Record{"iban": "GB33BUKB20201555555555", "subject_id": customerId})
+		assert.NoError(t, err)
+		return customerId, accountId
+	}
+
+	t.Run("subjects get their own key", func(t *testing.T) {
+		johnId, _ := createSubject("John Crawford")
+		janeId, _ := createSubject("Jane Crawford")
+
+		johnKey, err := db.GetSubjectKey(ctx, johnId)
+		assert.NoError(t, err)
+		janeKey, err := db.GetSubjectKey(ctx, janeId)
+		assert.NoError(t, err)
+		assert.NotEqual(t, johnKey.WrappedKey, janeKey.WrappedKey)
+
+		col, _ := db.GetCollection(ctx, "customers")
+		colPriv, _ := vault.collectionPrivatiser(col)
+		janePriv, _ := vault.recordPrivatiser(ctx, col, colPriv, Record{"id": janeId})
+		stored, _ := db.GetRecord(ctx, "customers", johnId)
+		_, err = janePriv.Decrypt(stored["name"])
+		assert.Error(t, err)
+	})
+
+	t.Run("can shred a subject and its children", func(t *testing.T) {
+		customerId, accountId := createSubject("John Crawford")
+		storedCustomer, _ := db.GetRecord(ctx, "customers", customerId)
+		storedAccount, _ := db.GetRecord(ctx, "accounts", accountId)
+
+		certificate, err := vault.ShredSubject(ctx, rootPrincipal, "customers", customerId)
+		assert.NoError(t, err)
+		assert.Equal(t, customerId, certificate.SubjectId)
+		assert.Equal(t, "root", certificate.ShreddedBy)
+		assert.True(t, certificate.KeyDestroyed)
+		valid, err := vault.Signer.Verify(certificate.message(), certificate.Signature)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		_, err = vault.GetRecord(ctx, rootPrincipal, "accounts", accountId, map[string]string{"iban": "plain"})
+		var nf *NotFoundError
+		assert.ErrorAs(t, err, &nf)
+
+		// Copies of the records, e.g. in backups, can't be decrypted anymore
+		for _, restore := range []struct {
+			collection string
+			record     Record
+		}{{"customers", storedCustomer}, {"accounts", storedAccount}} {
+			col, _ := db.GetCollection(ctx, restore.collection)
+			colPriv, _ := vault.collectionPrivatiser(col)
+			_, err = vault.recordPrivatiser(ctx, col, colPriv, restore.record)
+			var se *ShreddedError
+			assert.ErrorAs(t, err, &se)
+		}
+
+		_, err = vault.ShredSubject(ctx, rootPrincipal, "customers", customerId)
+		var se *ShreddedError
+		assert.ErrorAs(t, err, &se)
+	})
+
+	t.Run("subjects without a key are deleted", func(t *testing.T) {
+		// Like subjects created before subject keys were introduced
+		customerId, accountId := createSubject("John Crawford")
+		db.(*SqlStore).db.Exec("DELETE FROM subject_keys WHERE subject_id = ?", customerId)
+
+		certificate, err := vault.ShredSubject(ctx, rootPrincipal, "customers", customerId)
+		assert.NoError(t, err)
+		assert.False(t, certificate.KeyDestroyed)
+		assert.Nil(t, certificate.KeyCreatedAt)
+		valid, err := vault.Signer.Verify(certificate.message(), certificate.Signature)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		_, err = db.GetRecord(ctx, "accounts", accountId)
+		var nf *NotFoundError
+		assert.ErrorAs(t, err, &nf)
+		_, err = vault.ShredSubject(ctx, rootPrincipal, "customers", customerId)
+		assert.ErrorAs(t, err, &nf)
+	})
+
+	t.Run("can only shred subjects", func(t *testing.T) {
+		_, accountId := createSubject("John Crawford")
+		_, err := vault.ShredSubject(ctx, rootPrincipal, "accounts", accountId)
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("cannot shred without write access", func(t *testing.T) {
+		customerId, _ := createSubject("John Crawford")
+		_, err := vault.ShredSubject(ctx, readerPrincipal, "customers", customerId)
+		var fe *ForbiddenError
+		assert.ErrorAs(t, err, &fe)
+	})
+
+	t.Run("invalid records don't get a key", func(t *testing.T) {
+		before, err := db.GetSubjectKeys(ctx, "customers")
+		assert.NoError(t, err)
+		_, err = vault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "John Crawford", "unknown": "value"})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+		after, _ := db.GetSubjectKeys(ctx, "customers")
+		assert.Len(t, after, len(before))
+	})
+
+	t.Run("subject keys are kept out of the database", func(t *testing.T) {
+		storeVault := vault
+		storeVault.SubjectKeys, _ = NewLocalSubjectKeyStore(t.TempDir())
+
+		// Keys created before the store are moved to it
+		legacyId, _ := createSubject("Jane Crawford")
+		moved, err := storeVault.MoveSubjectKeys(ctx)
+		assert.NoError(t, err)
+		assert.Positive(t, moved)
+
+		customerId, err := storeVault.CreateRecord(ctx, rootPrincipal, "customers", Record{"name": "John Crawford"})
+		assert.NoError(t, err)
+		for _, subjectId := range []string{legacyId, customerId} {
+			subjectKey, err := db.GetSubjectKey(ctx, subjectId)
+			assert.NoError(t, err)
+			assert.Empty(t, subjectKey.WrappedKey)
+			record, err := storeVault.GetRecord(ctx, rootPrincipal, "customers", subjectId, map[string]string{"name": "plain"})
+			assert.NoError(t, err)
+			assert.NotEmpty(t, record["name"])
+		}
+
+		// Restoring a backup of the database doesn't restore the key
+		stored, _ := db.GetRecord(ctx, "customers", customerId)
+		_, err = storeVault.ShredSubject(ctx, rootPrincipal, "customers", customerId)
+		assert.NoError(t, err)
+		_, err = storeVault.SubjectKeys.Get(ctx, customerId)
+		var nf *NotFoundError
+		assert.ErrorAs(t, err, &nf)
+		db.(*SqlStore).db.Exec("UPDATE subject_keys SET shredded_at = NULL WHERE subject_id = ?", customerId)
+		col, _ := db.GetCollection(ctx, "customers")
+		colPriv, _ := storeVault.collectionPrivatiser(col)
+		_, err = storeVault.recordPrivatiser(ctx, col, colPriv, stored)
+		var se *ShreddedError
+		assert.ErrorAs(t, err, &se)
+	})
+}
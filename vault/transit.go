@@ -0,0 +1,280 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Transit keys are stored as keyrings named "transit/<key name>".
+const transitKeyringPrefix = "transit/"
+
+// TransitKey is a named key the vault encrypts and signs data with on behalf of clients, for
+// data that isn't stored in a collection. Clients never see the key material.
+type TransitKey struct {
+	Name string `json:"name" validate:"required,vaultResourceNames"`
+}
+
+// privatiserKeyProvider wraps the keys of a keyring with the privatiser of the vault, bound to
+// the keyring's name, so they are only usable while it is unsealed.
+type privatiserKeyProvider struct {
+	priv    Privatiser
+	keyring string
+}
+
+func (p privatiserKeyProvider) Wrap(ctx context.Context, key []byte) (string, error) {
+	return encryptWithAD(p.priv, base64.StdEncoding.EncodeToString(key), keyAssociatedData("keyring", p.keyring))
+}
+
+func (p privatiserKeyProvider) Unwrap(ctx context.Context, wrappedKey string) ([]byte, error) {
+	encodedKey, err := decryptWithAD(p.priv, wrappedKey, keyAssociatedData("keyring", p.keyring))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encodedKey)
+}
+
+// privatiserKeyring loads a keyring wrapped by the vault privatiser through the keyring cache.
+// Unless create is set, keyrings without keys are not found rather than created.
+func (vault Vault) privatiserKeyring(ctx context.Context, name string, create bool) (*Keyring, error) {
+	if vault.IsSealed() {
+		return nil, &SealedError{}
+	}
+	if vault.Keyrings != nil {
+		if keyring, ok := vault.Keyrings.get(name); ok {
+			return keyring, nil
+		}
+	}
+
+	if !create {
+		storedKeys, err := vault.Db.GetKeyringKeys(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(storedKeys) == 0 {
+			return nil, &NotFoundError{"keyring", name}
+		}
+	}
+	keyring, err := NewKeyring(ctx, vault.Db, name, privatiserKeyProvider{vault.Priv, name}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if vault.Keyrings != nil {
+		vault.Keyrings.add(name, keyring)
+	}
+	return keyring, nil
+}
+
+// BindKeyringKeys rewraps the keys of the keyrings wrapped by the vault privatiser before they
+// were bound to their keyring. It returns the number of keys rewrapped.
+func (vault Vault) BindKeyringKeys(ctx context.Context) (int, error) {
+	keyrings, err := vault.Db.GetKeyrings(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	for _, keyring := range keyrings {
+		if !isPrivatiserKeyring(keyring) {
+			continue
+		}
+		provider := privatiserKeyProvider{vault.Priv, keyring}
+		storedKeys, err := vault.Db.GetKeyringKeys(ctx, keyring)
+		if err != nil {
+			return rewrapped, err
+		}
+		for _, storedKey := range storedKeys {
+			if strings.HasPrefix(storedKey.WrappedKey, boundKeyringCiphertextPrefix) {
+				continue
+			}
+			key, err := provider.Unwrap(ctx, storedKey.WrappedKey)
+			if err != nil {
+				return rewrapped, fmt.Errorf("failed to unwrap key %d of keyring %s: %w", storedKey.Version, keyring, err)
+			}
+			current := storedKey.WrappedKey
+			if storedKey.WrappedKey, err = provider.Wrap(ctx, key); err != nil {
+				return rewrapped, err
+			}
+			if !strings.HasPrefix(storedKey.WrappedKey, boundKeyringCiphertextPrefix) {
+				// The vault privatiser isn't a keyring and can't bind keys
+				return rewrapped, nil
+			}
+			if err := vault.Db.UpdateKeyringKey(ctx, storedKey, current); err != nil {
+				var ce *ConflictError
+				if errors.As(err, &ce) {
+					// Rewrapped concurrently
+					continue
+				}
+				return rewrapped, err
+			}
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
+
+// isPrivatiserKeyring reports whether the keys of a keyring are wrapped by the privatiser of the
+// vault rather than by its key provider.
+func isPrivatiserKeyring(name string) bool {
+	return strings.HasPrefix(name, transitKeyringPrefix) || name == privateKeyringName
+}
+
+// transitResource returns the policy resource of a transit key, "/transit/keys/<name>", or of
+// an operation on it, e.g. "/transit/keys/<name>/encrypt".
+func transitResource(name string, operation string) string {
+	if operation == "" {
+		return fmt.Sprintf("%s/%s", TRANSIT_PPATH, name)
+	}
+	return fmt.Sprintf("%s/%s/%s", TRANSIT_PPATH, name, operation)
+}
+
+// transitKeyring loads the keyring of an existing transit key.
+func (vault Vault) transitKeyring(ctx context.Context, name string) (*Keyring, error) {
+	keyring, err := vault.privatiserKeyring(ctx, transitKeyringPrefix+name, false)
+	var nf *NotFoundError
+	if errors.As(err, &nf) {
+		return nil, &NotFoundError{"transit key", name}
+	}
+	return keyring, err
+}
+
+func (vault Vault) CreateTransitKey(
+	ctx context.Context,
+	principal Principal,
+	key *TransitKey,
+) (*KeyringStatus, error) {
+	if err := vault.Validate(key); err != nil {
+		return nil, err
+	}
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, transitResource(key.Name, "")}); err != nil {
+		return nil, err
+	}
+
+	storedKeys, err := vault.Db.GetKeyringKeys(ctx, transitKeyringPrefix+key.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(storedKeys) > 0 {
+		return nil, &ConflictError{fmt.Sprintf("transit key %s", key.Name)}
+	}
+	keyring, err := vault.privatiserKeyring(ctx, transitKeyringPrefix+key.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	return transitKeyStatus(key.Name, keyring), nil
+}
+
+func (vault Vault) GetTransitKey(ctx context.Context, principal Principal, name string) (*KeyringStatus, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, transitResource(name, "")}); err != nil {
+		return nil, err
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return transitKeyStatus(name, keyring), nil
+}
+
+// RotateTransitKey makes a new version of a transit key active. Data protected with previous
+// versions can still be decrypted and verified, and moved to the new version with
+// TransitRewrap.
+func (vault Vault) RotateTransitKey(ctx context.Context, principal Principal, name string) (*KeyringStatus, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, transitResource(name, "")}); err != nil {
+		return nil, err
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keyring.Rotate(ctx); err != nil {
+		return nil, err
+	}
+	if vault.Keyrings != nil {
+		// Reloaded with the new version on next use, other instances pick it up once their
+		// cached keyring expires.
+		vault.Keyrings.remove(transitKeyringPrefix + name)
+	}
+	vault.Logger.Info(fmt.Sprintf("Transit key %s was rotated by %s", name, principal.Username))
+	return transitKeyStatus(name, keyring), nil
+}
+
+func transitKeyStatus(name string, keyring *Keyring) *KeyringStatus {
+	status := keyring.Status()
+	status.Name = name
+	return &status
+}
+
+func (vault Vault) TransitEncrypt(ctx context.Context, principal Principal, name string, plainText string) (string, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, transitResource(name, "encrypt")}); err != nil {
+		return "", err
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return keyring.Encrypt(plainText)
+}
+
+func (vault Vault) TransitDecrypt(ctx context.Context, principal Principal, name string, cipherText string) (string, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, transitResource(name, "decrypt")}); err != nil {
+		return "", err
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := parseKeyringCiphertext(cipherText); err != nil {
+		return "", &ValueError{Msg: err.Error()}
+	}
+	return keyring.Decrypt(cipherText)
+}
+
+// TransitRewrap re-encrypts a ciphertext with the active version of a transit key without
+// returning the plaintext.
+func (vault Vault) TransitRewrap(ctx context.Context, principal Principal, name string, cipherText string) (string, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, transitResource(name, "rewrap")}); err != nil {
+		return "", err
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := parseKeyringCiphertext(cipherText); err != nil {
+		return "", &ValueError{Msg: err.Error()}
+	}
+	plainText, err := keyring.Decrypt(cipherText)
+	if err != nil {
+		return "", err
+	}
+	return keyring.Encrypt(plainText)
+}
+
+func (vault Vault) TransitSign(ctx context.Context, principal Principal, name string, message string) (string, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, transitResource(name, "sign")}); err != nil {
+		return "", err
+	}
+	if message == "" {
+		return "", &ValueError{Msg: "message cannot be empty"}
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return keyring.Sign(message)
+}
+
+func (vault Vault) TransitVerify(ctx context.Context, principal Principal, name string, message string, signature string) (bool, error) {
+	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionRead, transitResource(name, "verify")}); err != nil {
+		return false, err
+	}
+	if message == "" {
+		return false, &ValueError{Msg: "message cannot be empty"}
+	}
+	keyring, err := vault.transitKeyring(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return keyring.Verify(message, signature)
+}
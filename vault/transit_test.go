@@ -0,0 +1,117 @@
+package vault
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransit(t *testing.T) {
+	ctx := context.Background()
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+	t.Run("can encrypt, decrypt and rewrap with a transit key", func(t *testing.T) {
+		vault, _, _ := initVault(t)
+		_, err := vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "payments"})
+		assert.NoError(t, err)
+
+		before, err := vault.TransitEncrypt(ctx, rootPrincipal, "payments", "aGVsbG8=")
+		assert.NoError(t, err)
+		status, err := vault.RotateTransitKey(ctx, rootPrincipal, "payments")
+		assert.NoError(t, err)
+		assert.Equal(t, "payments", status.Name)
+		assert.Equal(t, 2, status.ActiveVersion)
+
+		rewrapped, err := vault.TransitRewrap(ctx, rootPrincipal, "payments", before)
+		assert.NoError(t, err)
+		assert.NotEqual(t, before, rewrapped)
+		for _, ciphertext := range []string{before, rewrapped} {
+			decrypted, err := vault.TransitDecrypt(ctx, rootPrincipal, "payments", ciphertext)
+			assert.NoError(t, err)
+			assert.Equal(t, "aGVsbG8=", decrypted)
+		}
+	})
+
+	t.Run("transit keys are cached until rotated", func(t *testing.T) {
+		vault, _, _ := initVault(t)
+		vault.Keyrings = NewKeyringCache(time.Minute)
+		_, _ = vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "payments"})
+
+		keyring, err := vault.transitKeyring(ctx, "payments")
+		assert.NoError(t, err)
+		cached, err := vault.transitKeyring(ctx, "payments")
+		assert.NoError(t, err)
+		assert.Same(t, keyring, cached)
+
+		_, err = vault.RotateTransitKey(ctx, rootPrincipal, "payments")
+		assert.NoError(t, err)
+		reloaded, err := vault.transitKeyring(ctx, "payments")
+		assert.NoError(t, err)
+		assert.NotSame(t, keyring, reloaded)
+		assert.Equal(t, 2, reloaded.Status().ActiveVersion)
+	})
+
+	t.Run("can sign and verify with a transit key", func(t *testing.T) {
+		vault, _, _ := initVault(t)
+		_, _ = vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "webhooks"})
+
+		signature, err := vault.TransitSign(ctx, rootPrincipal, "webhooks", "payload")
+		assert.NoError(t, err)
+		valid, err := vault.TransitVerify(ctx, rootPrincipal, "webhooks", "payload", signature)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+		valid, err = vault.TransitVerify(ctx, rootPrincipal, "webhooks", "tampered", signature)
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("transit keys don't decrypt each other's ciphertexts", func(t *testing.T) {
+		vault, _, _ := initVault(t)
+		_, _ = vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "first"})
+		_, _ = vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "second"})
+
+		encrypted, _ := vault.TransitEncrypt(ctx, rootPrincipal, "first", "secret")
+		_, err := vault.TransitDecrypt(ctx, rootPrincipal, "second", encrypted)
+		var integrityErr *IntegrityError
+		assert.ErrorAs(t, err, &integrityErr)
+	})
+
+	t.Run("cannot create a transit key twice or use one that doesn't exist", func(t *testing.T) {
+		vault, _, _ := initVault(t)
+		_, _ = vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "payments"})
+
+		_, err := vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "payments"})
+		var conflictErr *ConflictError
+		assert.ErrorAs(t, err, &conflictErr)
+
+		_, err = vault.TransitEncrypt(ctx, rootPrincipal, "missing", "secret")
+		var notFoundErr *NotFoundError
+		assert.ErrorAs(t, err, &notFoundErr)
+	})
+
+	t.Run("transit operations are authorised per key and operation", func(t *testing.T) {
+		vault, db, _ := initVault(t)
+		_ = db.CreatePolicy(ctx, &Policy{
+			Id:        "encrypt-payments",
+			Name:      "encrypt-payments",
+			Effect:    EffectAllow,
+			Actions:   []PolicyAction{PolicyActionWrite},
+			Resources: []string{"/transit/keys/payments/encrypt"},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		service := Principal{Username: "service", Policies: []string{"encrypt-payments"}}
+		_, _ = vault.CreateTransitKey(ctx, rootPrincipal, &TransitKey{Name: "payments"})
+
+		encrypted, err := vault.TransitEncrypt(ctx, service, "payments", "secret")
+		assert.NoError(t, err)
+
+		var forbiddenErr *ForbiddenError
+		_, err = vault.TransitDecrypt(ctx, service, "payments", encrypted)
+		assert.ErrorAs(t, err, &forbiddenErr)
+		_, err = vault.RotateTransitKey(ctx, service, "payments")
+		assert.ErrorAs(t, err, &forbiddenErr)
+	})
+}
@@ -2,6 +2,9 @@ package vault
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -10,31 +13,252 @@ import (
 )
 
 type Field struct {
-	Type      string `json:"type" validate:"required"`
-	IsIndexed bool   `json:"is_indexed" validate:"boolean"`
+	Type      string    `json:"type" validate:"required"` // One of the registered ptypes, listed by GET /ptypes
+	IsIndexed bool      `json:"is_indexed" validate:"boolean"`
+	Mode      FieldMode `json:"mode" validate:"omitempty,oneof=randomized deterministic hashed"`
+	Pattern   string    `json:"pattern,omitempty"` // Values of regex fields must match it
+	Mask      string    `json:"mask,omitempty"`    // Template of the masked format of regex fields
+	// Formats of date and integer fields that reduce values to a range, by format name
+	Generalizations map[string]Generalization `json:"generalizations,omitempty"`
+	Required        *bool                     `json:"required,omitempty"` // Records must set the field unless it has a default, true if unset
+	Nullable        bool                      `json:"nullable,omitempty"` // The field can be set to null
+	Default         *string                   `json:"default,omitempty"`  // Value of the field in records that don't set it
+	Constraints     *FieldConstraints         `json:"constraints,omitempty"`
+	Paths           map[string]JSONPath       `json:"paths,omitempty"`         // Sensitive paths of json fields
+	MaxSize         int64                     `json:"max_size,omitempty"`      // Size limit of file fields in bytes, DefaultMaxFileSize if unset
+	ContentTypes    []string                  `json:"content_types,omitempty"` // Media types file fields accept, e.g. application/pdf or image/*, any if unset
+}
+
+// IsRequired reports whether records must set the field. Fields are required unless declared
+// otherwise.
+func (f Field) IsRequired() bool {
+	return f.Required == nil || *f.Required
+}
+
+// validateDefault checks that records not setting a field have a value for it.
+func (f Field) validateDefault(fieldName string) error {
+	if f.Default != nil {
+		_, failures, err := validateFieldValue(fieldName, f, *f.Default)
+		if err != nil {
+			return &ValueError{Msg: fmt.Sprintf("invalid default: %s", err)}
+		}
+		if len(failures) > 0 {
+			return &ValueError{Msg: fmt.Sprintf("invalid default: %s", ValidationErrors{failures})}
+		}
+		return nil
+	}
+	if !f.IsRequired() && !f.Nullable {
+		return &ValueError{Msg: "optional fields must be nullable or have a default"}
+	}
+	return nil
+}
+
+// FieldMode is how the values of a field are protected.
+type FieldMode string
+
+const (
+	// Randomized values are encrypted and can only be read back.
+	FieldModeRandomized FieldMode = "randomized"
+	// Deterministic values are encrypted and blind indexed, so they can also be searched.
+	FieldModeDeterministic FieldMode = "deterministic"
+	// Hashed values are hashed one way and can only be compared with VerifyField.
+	FieldModeHashed FieldMode = "hashed"
+)
+
+// validatePattern checks the pattern and mask template of a regex field.
+func (f Field) validatePattern() error {
+	if PTypeName(f.Type) != RegexType {
+		if f.Pattern != "" || f.Mask != "" {
+			return &ValueError{Msg: fmt.Sprintf("pattern and mask are only supported by the %s type", RegexType)}
+		}
+		return nil
+	}
+	if f.Pattern == "" {
+		return &ValueError{Msg: fmt.Sprintf("fields of the %s type require a pattern", RegexType)}
+	}
+	pattern, err := compilePattern(f.Pattern)
+	if err != nil {
+		return err
+	}
+	return validateMask(pattern, f.Mask)
+}
+
+// validateGeneralizations checks the generalization formats of a field, whose names must not
+// clash with the formats of its ptype.
+func (f Field) validateGeneralizations() error {
+	formats := lookupPType(PTypeName(f.Type)).Formats
+	for name, generalization := range f.Generalizations {
+		if _, ok := formats[name]; ok || !pTypeNamePattern.MatchString(name) || name == PLAIN_FORMAT || name == MASKED_FORMAT || isFPEFormat(name) {
+			return &ValueError{Msg: fmt.Sprintf("invalid generalization name %s", name)}
+		}
+		if err := generalization.validate(PTypeName(f.Type)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFieldValue checks that a value written to a field is valid for its ptype and
+// constraints, returning the normalized value to store, or how the value fails them.
+func validateFieldValue(fieldName string, field Field, value string) (string, []*ValidationError, error) {
+	// Values that aren't valid for the ptype fail the field's type, whatever the error of the
+	// ptype, as registered ptypes fail with errors of their own
+	invalid := func(err error) (string, []*ValidationError, error) {
+		return "", []*ValidationError{{FailedField: fieldName, Tag: field.Type, Value: err.Error()}}, nil
+	}
+
+	if PTypeName(field.Type) == FileType {
+		// Null until a file is uploaded
+		if value != Null {
+			return invalid(&ValueError{Msg: fmt.Sprintf("files are uploaded to %s/<field> of their record", FILES_PPATH)})
+		}
+		return value, nil, nil
+	}
+	if value == Null {
+		if !field.Nullable {
+			return "", []*ValidationError{{FailedField: fieldName, Tag: "nullable"}}, nil
+		}
+		return value, nil, nil
+	}
+	if document, ok := ParseJSONValue(value); ok {
+		switch PTypeName(field.Type) {
+		case JSONType:
+		case AddressType:
+			// Structured addresses can be written as objects or as JSON text
+			value = document
+		default:
+			return invalid(&ValueError{Msg: fmt.Sprintf("must be a string, only %s and %s fields hold objects", JSONType, AddressType)})
+		}
+	}
+	pType, err := GetFieldPType(field, value)
+	if err != nil {
+		return invalid(err)
+	}
+	if writeValidator, ok := pType.(WriteValidator); ok {
+		if err := writeValidator.ValidateWrite(); err != nil {
+			return invalid(err)
+		}
+	}
+	if field.Constraints == nil {
+		return pType.GetPlain(), nil, nil
+	}
+	return pType.GetPlain(), field.Constraints.check(fieldName, pType), nil
+}
+
+// normalizeFieldValue returns a value of a field as it's stored, so values searched for or
+// verified match however they were written.
+func normalizeFieldValue(field Field, value string) (string, error) {
+	pType, err := GetFieldPType(field, value)
+	if err != nil {
+		return "", err
+	}
+	return pType.GetPlain(), nil
+}
+
+// EncryptionMode returns the mode of a field. Fields of collections created before modes were
+// introduced are deterministic if they are indexed and randomized otherwise.
+func (f Field) EncryptionMode() FieldMode {
+	if f.Mode != "" {
+		return f.Mode
+	}
+	if f.IsIndexed {
+		return FieldModeDeterministic
+	}
+	return FieldModeRandomized
 }
 
 type CollectionType string
 
 type Collection struct {
-	Id          string           `json:"id"`
-	Name        string           `json:"name" validate:"required,min=3,max=32"`
-	Description string           `json:"description"`
-	Parent      string           `json:"parent" validate:"omitempty,min=3,max=32"`
-	Fields      map[string]Field `json:"fields" validate:"dive,required"`
-	CreatedAt   time.Time        `json:"created_at"`
-	UpdatedAt   time.Time        `json:"updated_at"`
+	Id           string           `json:"id"`
+	Name         string           `json:"name" validate:"required,min=3,max=32"`
+	Description  string           `json:"description"`
+	Parent       string           `json:"parent" validate:"omitempty,min=3,max=32"`
+	Fields       map[string]Field `json:"fields" validate:"dive,required"`
+	DataKey      string           `json:"-"` // The collection's data encryption key, wrapped by the vault privatiser
+	WriteOnly    bool             `json:"write_only"`
+	PublicKey    string           `json:"public_key,omitempty"`
+	PrivateKey   string           `json:"-"` // Wrapped by the private keyring, see generateKeyPair
+	Strict       bool             `json:"-"` // Set once every record is bound to its field and signed, see boundPrivatiser
+	IndexVersion int              `json:"-"` // Version of the blind indexes of its records, see blindIndex
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
 }
 
 type Record map[string]string // field name -> value
 
+// Null is the value of fields that are null. Records encode it as JSON null, and it's stored
+// as an empty column rather than encrypted.
+const Null = "\x00"
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	values := make(map[string]interface{}, len(r))
+	for fieldName, value := range r {
+		if value == Null {
+			values[fieldName] = nil
+			continue
+		}
+		if document, ok := ParseJSONValue(value); ok {
+			values[fieldName] = json.RawMessage(document)
+			continue
+		}
+		values[fieldName] = value
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON reads a record whose values are strings, null, or objects and arrays, which
+// are kept marked with JSONValue.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*r = make(Record, len(values))
+	for fieldName, value := range values {
+		switch value[0] {
+		case 'n':
+			(*r)[fieldName] = Null
+		case '{', '[':
+			document, err := compactJSON(value)
+			if err != nil {
+				return err
+			}
+			(*r)[fieldName] = JSONValue(document)
+		default:
+			var stringValue string
+			if err := json.Unmarshal(value, &stringValue); err != nil {
+				return err
+			}
+			(*r)[fieldName] = stringValue
+		}
+	}
+	return nil
+}
+
 const subject_id_field = "subject_id"
 
+// Indexed fields are searched through a keyed blind index stored in a sibling column,
+// since their ciphertext is randomised and can't be compared directly.
+const blind_index_suffix = "_bidx"
+
+func blindIndexColumn(fieldName string) string {
+	return fieldName + blind_index_suffix
+}
+
 type Privatiser interface {
 	Encrypt(string) (string, error)
 	Decrypt(string) (string, error)
 }
 
+// AssociatedDataPrivatiser is implemented by privatisers that can bind a ciphertext to the
+// context it is stored in, so that it fails to decrypt anywhere else.
+type AssociatedDataPrivatiser interface {
+	Privatiser
+	EncryptWithAD(text string, associatedData []byte) (string, error)
+	DecryptWithAD(encodedText string, associatedData []byte) (string, error)
+}
+
 type Signer interface {
 	Sign(message string) (string, error)
 	Verify(message, signature string) (bool, error)
@@ -50,8 +274,9 @@ type Logger interface {
 type PolicyAction string
 
 const (
-	PolicyActionRead  PolicyAction = "read"
-	PolicyActionWrite PolicyAction = "write"
+	PolicyActionRead    PolicyAction = "read"
+	PolicyActionWrite   PolicyAction = "write"
+	PolicyActionDecrypt PolicyAction = "decrypt" // Reading the records of write-only collections
 	// TODO: Add more
 )
 
@@ -67,7 +292,7 @@ type Policy struct {
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
 	Effect      PolicyEffect   `json:"effect" validate:"required,oneof=allow deny"`
-	Actions     []PolicyAction `json:"actions" validate:"dive,required,oneof=read write"`
+	Actions     []PolicyAction `json:"actions" validate:"dive,required,oneof=read write decrypt"`
 	Resources   []string       `json:"resources" validate:"required"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
@@ -95,6 +320,13 @@ type Vault struct {
 	Logger    Logger
 	Signer    Signer
 	Validator *validator.Validate
+	Hasher    PasswordHasher // Defaults to argon2id when nil
+	Logins    *LoginCache    // Remembers verified credentials, every login is verified when nil
+	Blobs     BlobStore      // Keeps the contents of file fields, files can't be uploaded when nil
+	Keyrings  *KeyringCache  // Keeps transit keys unwrapped, they are loaded on every call when nil
+	// Keeps subject keys out of the database when set, otherwise they are stored in it and
+	// remain in its backups after their subject is shredded.
+	SubjectKeys SubjectKeyStore
 }
 
 const (
@@ -102,6 +334,11 @@ const (
 	PRINCIPALS_PPATH  = "/principals"
 	RECORDS_PPATH     = "/records"
 	POLICIES_PPATH    = "/policies"
+	KEYRING_PPATH     = "/sys/keyring"
+	SEAL_PPATH        = "/sys/seal"
+	FPE_PPATH         = "/fpe"
+	TRANSIT_PPATH     = "/transit/keys"
+	FILES_PPATH       = "/files"
 )
 
 type VaultDB interface {
@@ -111,20 +348,39 @@ type VaultDB interface {
 	DeleteCollection(ctx context.Context, name string) error
 	CreateRecord(ctx context.Context, collectionName string, record Record) error
 	GetRecords(ctx context.Context, collectionName string) ([]string, error)
+	GetUnindexedRecords(ctx context.Context, collectionName string) ([]string, error)
 	GetRecord(ctx context.Context, collectionName string, recordId string) (Record, error)
 	SearchRecords(ctx context.Context, collectionName string, filters map[string]string) ([]string, error)
 	UpdateRecord(ctx context.Context, collectionName string, recordID string, record Record) error
 	DeleteRecord(ctx context.Context, collectionName string, recordID string) error
 	GetPrincipal(ctx context.Context, username string) (*Principal, error)
 	CreatePrincipal(ctx context.Context, principal *Principal) error
+	UpdatePrincipalPassword(ctx context.Context, username string, current string, password string) error
 	DeletePrincipal(ctx context.Context, username string) error
 	GetPolicy(ctx context.Context, policyId string) (*Policy, error)
 	GetPolicies(ctx context.Context, policyIds []string) ([]*Policy, error)
 	CreatePolicy(ctx context.Context, p *Policy) error
+	AddPolicyAction(ctx context.Context, policyId string, action PolicyAction) error
 	DeletePolicy(ctx context.Context, policyId string) error
 	CreateToken(ctx context.Context, tokenId string, value string) error
 	DeleteToken(ctx context.Context, tokenId string) error
 	GetTokenValue(ctx context.Context, tokenId string) (string, error)
+	GetKeyringKeys(ctx context.Context, keyring string) ([]*KeyringKey, error)
+	CreateKeyringKey(ctx context.Context, key *KeyringKey) error
+	GetKeyrings(ctx context.Context) ([]string, error)
+	UpdateKeyringKey(ctx context.Context, key *KeyringKey, current string) error
+	UpdateRecordIfUnchanged(ctx context.Context, collectionName string, recordID string, current Record, record Record) error
+	UpdateCollectionDataKey(ctx context.Context, name string, current string, dataKey string) error
+	SetCollectionStrict(ctx context.Context, name string) error
+	SetCollectionIndexVersion(ctx context.Context, name string, version int) error
+	UpdateCollectionPrivateKey(ctx context.Context, name string, current string, privateKey string) error
+	GetSealConfig(ctx context.Context) (*SealConfig, error)
+	CreateSealConfig(ctx context.Context, config *SealConfig) error
+	GetSubjectKey(ctx context.Context, subjectId string) (*SubjectKey, error)
+	CreateSubjectKey(ctx context.Context, key *SubjectKey) error
+	ShredSubjectKey(ctx context.Context, subjectId string, shreddedAt time.Time) error
+	GetSubjectKeys(ctx context.Context, collectionName string) ([]*SubjectKey, error)
+	ClearSubjectKey(ctx context.Context, subjectId string, current string) error
 	Flush(ctx context.Context) error
 }
 
@@ -172,11 +428,61 @@ func (vault Vault) CreateCollection(
 		return err
 	}
 
+	for fieldName, field := range col.Fields {
+		if !IsPTypeRegistered(PTypeName(field.Type)) {
+			return &ValueError{Msg: fmt.Sprintf("field %s: unknown type %s", fieldName, field.Type)}
+		}
+		if err := field.validatePattern(); err != nil {
+			return &ValueError{Msg: fmt.Sprintf("field %s: %s", fieldName, err)}
+		}
+		if err := field.validateGeneralizations(); err != nil {
+			return &ValueError{Msg: fmt.Sprintf("field %s: %s", fieldName, err)}
+		}
+		if err := field.validatePaths(); err != nil {
+			return &ValueError{Msg: fmt.Sprintf("field %s: %s", fieldName, err)}
+		}
+		if err := field.validateFile(); err != nil {
+			return &ValueError{Msg: fmt.Sprintf("field %s: %s", fieldName, err)}
+		}
+		if field.Constraints != nil {
+			if err := field.Constraints.validate(field); err != nil {
+				return &ValueError{Msg: fmt.Sprintf("field %s: %s", fieldName, err)}
+			}
+		}
+		if err := field.validateDefault(fieldName); err != nil {
+			return &ValueError{Msg: fmt.Sprintf("field %s: %s", fieldName, err)}
+		}
+		switch {
+		case field.Mode == "":
+			field.Mode = field.EncryptionMode()
+		case field.Mode == FieldModeDeterministic:
+			field.IsIndexed = true
+		case field.IsIndexed:
+			return &ValueError{Msg: fmt.Sprintf("field %s can't be indexed, only deterministic fields are searchable", fieldName)}
+		}
+		col.Fields[fieldName] = field
+	}
+
 	col.Id = GenerateId("col")
 	if col.Parent != "" {
-		col.Fields["subject_id"] = Field{Type: "string", IsIndexed: true}
+		col.Fields["subject_id"] = Field{Type: "string", IsIndexed: true, Mode: FieldModeDeterministic}
 	}
-	err := vault.Db.CreateCollection(ctx, col)
+	dataKey, err := vault.generateDataKey(col.Name)
+	if err != nil {
+		return err
+	}
+	col.DataKey = dataKey
+	col.PublicKey = ""
+	// New collections have no records written before values were bound
+	col.Strict = true
+	col.IndexVersion = blindIndexVersion
+	if col.WriteOnly {
+		if err := vault.generateKeyPair(ctx, col); err != nil {
+			return err
+		}
+	}
+
+	err = vault.Db.CreateCollection(ctx, col)
 	if err != nil {
 		return err
 	}
@@ -192,6 +498,19 @@ func (vault Vault) DeleteCollection(
 		return err
 	}
 
+	if vault.SubjectKeys != nil {
+		// The collection's subject keys are deleted from the database along with it
+		subjectKeys, err := vault.Db.GetSubjectKeys(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, subjectKey := range subjectKeys {
+			if err := vault.SubjectKeys.Delete(ctx, subjectKey.SubjectId); err != nil {
+				return err
+			}
+		}
+	}
+
 	return vault.Db.DeleteCollection(ctx, name)
 }
 
@@ -210,12 +529,30 @@ func (vault Vault) CreateRecord(
 		return "", err
 	}
 
-	// Ensure all fields are present
-	for fieldName := range collection.Fields {
-		if _, ok := record[fieldName]; !ok {
-			return "", &ValueError{Msg: fmt.Sprintf("Field %s is missing from the record", fieldName)}
+	// Every field is validated before anything is stored, the fields missing from the record
+	// and the ptypes and constraints failed by every field are returned together
+	validationFailures := []*ValidationError{}
+
+	// Fields the record doesn't set take their default, or null if they are optional or files,
+	// which are uploaded once the record exists
+	values := make(Record, len(record))
+	for fieldName, value := range record {
+		values[fieldName] = value
+	}
+	for fieldName, field := range collection.Fields {
+		if _, ok := values[fieldName]; ok {
+			continue
+		}
+		switch {
+		case field.Default != nil:
+			values[fieldName] = *field.Default
+		case !field.IsRequired() || PTypeName(field.Type) == FileType:
+			values[fieldName] = Null
+		default:
+			validationFailures = append(validationFailures, &ValidationError{FailedField: fieldName, Tag: "required"})
 		}
 	}
+	record = values
 
 	// Validate parent relationship
 	if collection.Parent != "" {
@@ -228,44 +565,70 @@ func (vault Vault) CreateRecord(
 		}
 	}
 
-	encryptedRecord := make(Record)
 	for fieldName, fieldValue := range record {
 		// Ensure field name is allowed
-		if fieldName == "" || fieldName == "id" || fieldName == "created_at" || fieldName == "updated_at" {
+		if fieldName == "" || fieldName == "id" || fieldName == "created_at" || fieldName == "updated_at" || fieldName == record_mac_field {
 			return "", &ValueError{Msg: fmt.Sprintf("reserved field name is not allowed to be set: %s", fieldName)}
 		}
 
 		// Ensure passed in field exists on collection
 		if _, ok := collection.Fields[fieldName]; !ok {
 			if collection.Parent != "" && fieldName == subject_id_field {
-				encryptedRecord[subject_id_field] = fieldValue
 				continue
 			}
 			return "", &ValueError{fmt.Sprintf("field %s does not exist on collection %s", fieldName, collectionName)}
 		}
 
-		// Validate field PType
-		if _, err := GetPType(PTypeName(collection.Fields[fieldName].Type), fieldValue); err != nil {
+		// Validate field PType, values are stored normalized
+		normalized, failures, err := validateFieldValue(fieldName, collection.Fields[fieldName], fieldValue)
+		if err != nil {
 			return "", err
 		}
+		record[fieldName] = normalized
+		validationFailures = append(validationFailures, failures...)
+	}
+	if len(validationFailures) > 0 {
+		return "", &ValidationErrors{validationFailures}
+	}
+
+	colPriv, err := vault.collectionPrivatiser(collection)
+	if err != nil {
+		return "", err
+	}
+	recordId := GenerateId("rec")
+	if collection.Parent == "" {
+		// Every record of a collection without a parent is a subject with its own key
+		if err := vault.createSubjectKey(ctx, collection, colPriv, recordId); err != nil {
+			return "", err
+		}
+	}
+	priv, err := vault.recordPrivatiser(ctx, collection, colPriv, Record{"id": recordId, subject_id_field: record[subject_id_field]})
+	if err != nil {
+		return "", err
+	}
 
+	encryptedRecord := make(Record)
+	for fieldName, fieldValue := range record {
 		if fieldName == subject_id_field {
 			encryptedRecord[subject_id_field] = fieldValue
 			continue
 		}
 
-		// Encrypt field value
-		encryptedValue, err := vault.Priv.Encrypt(fieldValue)
-
+		fieldValue, err := vault.hashField(collection, fieldName, fieldValue)
 		if err != nil {
 			return "", err
 		}
-		encryptedRecord[fieldName] = encryptedValue
+		if err := vault.encryptField(priv, collection, recordId, fieldName, fieldValue, encryptedRecord); err != nil {
+			return "", err
+		}
 	}
 
-	encryptedRecord["id"] = GenerateId("rec")
+	encryptedRecord["id"] = recordId
 	encryptedRecord["created_at"] = time.Now().Format(time.RFC3339)
 	encryptedRecord["updated_at"] = time.Now().Format(time.RFC3339)
+	if encryptedRecord[record_mac_field], err = vault.recordMAC(collection, encryptedRecord); err != nil {
+		return "", err
+	}
 
 	if err := vault.Db.CreateRecord(ctx, collectionName, encryptedRecord); err != nil {
 		return "", err
@@ -306,7 +669,13 @@ func (vault Vault) GetRecord(
 	if err != nil {
 		return nil, err
 	}
-	for field := range returnFormats {
+	if err := vault.validateDecrypt(ctx, principal, col, recordID); err != nil {
+		return nil, err
+	}
+	for requestedField := range returnFormats {
+		// Paths can be requested into json fields, e.g. history[*].postcode
+		field, path := splitFieldPath(requestedField)
+
 		// Ensure requested fields exist on collection
 		if _, ok := col.Fields[field]; !ok {
 			return nil, &NotFoundError{resourceName: fmt.Sprintf("Field %s not found on collection %s", field, collectionName)}
@@ -316,27 +685,72 @@ func (vault Vault) GetRecord(
 		if field == "id" || field == "created_at" || field == "updated_at" || field == subject_id_field {
 			return nil, &ValueError{Msg: fmt.Sprintf("reserved field name is not allowed to be returned as a ptype: %s", field)}
 		}
+
+		if col.Fields[field].EncryptionMode() == FieldModeHashed {
+			return nil, &ValueError{Msg: fmt.Sprintf("Field %s is hashed, it can only be verified", field)}
+		}
+
+		if _, err := parseJSONPath(path); path != "" && (PTypeName(col.Fields[field].Type) != JSONType || err != nil) {
+			return nil, &ValueError{Msg: fmt.Sprintf("Invalid path %s, paths can only be requested into valid %s fields", requestedField, JSONType)}
+		}
 	}
 
 	encryptedRecord, err := vault.Db.GetRecord(ctx, collectionName, recordID)
 	if err != nil {
 		return nil, err
 	}
+	if err := vault.verifyRecord(col, encryptedRecord); err != nil {
+		return nil, err
+	}
+
+	colPriv, err := vault.collectionPrivatiser(col)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := vault.recordPrivatiser(ctx, col, colPriv, encryptedRecord)
+	if err != nil {
+		return nil, err
+	}
+	var subjectKey []byte
+	for _, format := range returnFormats {
+		if isFPEFormat(format) {
+			if subjectKey, err = vault.subjectKey(ctx, col, colPriv, encryptedRecord); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
 
 	decryptedRecord := make(Record)
-	for field, format := range returnFormats {
+	for requestedField, format := range returnFormats {
+		field, path := splitFieldPath(requestedField)
 
-		decryptedValue, err := vault.Priv.Decrypt(encryptedRecord[field])
+		decryptedValue, err := vault.decryptField(priv, col, recordID, field, encryptedRecord[field])
 		if err != nil {
 			return nil, err
 		}
+		if decryptedValue == Null {
+			// Null in every format
+			decryptedRecord[requestedField] = Null
+			continue
+		}
 
-		privValue, err := GetPType(PTypeName(col.Fields[field].Type), decryptedValue)
+		privValue, err := GetFieldPType(col.Fields[field], decryptedValue)
 		if err != nil {
 			return nil, err
 		}
 
-		decryptedRecord[field], err = privValue.Get(format)
+		if document, ok := privValue.(JSONDocument); ok && !isFPEFormat(format) {
+			plainPaths := map[string]bool{}
+			if format == PLAIN_FORMAT {
+				if plainPaths, err = vault.plainJSONPaths(ctx, principal, col, recordID, field); err != nil {
+					return nil, err
+				}
+			}
+			decryptedRecord[requestedField], err = document.format(path, format, plainPaths)
+		} else {
+			decryptedRecord[requestedField], err = vault.formatField(col, recordID, subjectKey, field, privValue, format)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -352,6 +766,64 @@ func (vault Vault) GetRecord(
 	return decryptedRecord, nil
 }
 
+// VerifyField reports whether value matches the stored value of a hashed field.
+func (vault Vault) VerifyField(
+	ctx context.Context,
+	principal Principal,
+	collectionName string,
+	recordID string,
+	fieldName string,
+	value string,
+) (bool, error) {
+	_request := Request{principal, PolicyActionRead, fmt.Sprintf("%s/%s%s/%s/%s.verify", COLLECTIONS_PPATH, collectionName, RECORDS_PPATH, recordID, fieldName)}
+	if err := vault.ValidateAction(ctx, _request); err != nil {
+		return false, err
+	}
+
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return false, err
+	}
+	field, ok := col.Fields[fieldName]
+	if !ok {
+		return false, &NotFoundError{resourceName: fmt.Sprintf("Field %s not found on collection %s", fieldName, collectionName)}
+	}
+	if field.EncryptionMode() != FieldModeHashed {
+		return false, &ValueError{Msg: fmt.Sprintf("Field %s is not hashed", fieldName)}
+	}
+	if err := vault.validateDecrypt(ctx, principal, col, recordID); err != nil {
+		return false, err
+	}
+
+	encryptedRecord, err := vault.Db.GetRecord(ctx, collectionName, recordID)
+	if err != nil {
+		return false, err
+	}
+	if err := vault.verifyRecord(col, encryptedRecord); err != nil {
+		return false, err
+	}
+	colPriv, err := vault.collectionPrivatiser(col)
+	if err != nil {
+		return false, err
+	}
+	priv, err := vault.recordPrivatiser(ctx, col, colPriv, encryptedRecord)
+	if err != nil {
+		return false, err
+	}
+	hash, err := vault.decryptField(priv, col, recordID, fieldName, encryptedRecord[fieldName])
+	if err != nil {
+		return false, err
+	}
+	if hash == Null {
+		return false, nil
+	}
+	// Values that aren't valid for the field can't match
+	if value, err = normalizeFieldValue(field, value); err != nil {
+		return false, nil
+	}
+	return vault.passwordHasher().Verify(value, hash)
+}
+
 func (vault Vault) SearchRecords(
 	ctx context.Context,
 	principal Principal,
@@ -363,27 +835,49 @@ func (vault Vault) SearchRecords(
 		return nil, &ValueError{Msg: "filters must not be empty"}
 	}
 
-	encryptedFilters := make(map[string]string)
-	for field, value := range filters {
+	for field := range filters {
 		// To search records we need to have read access to all records and the field we are searching on in plain format as this leak information about the record.
 		request := Request{principal, PolicyActionRead, fmt.Sprintf("%s/%s%s/%s/%s.%s", COLLECTIONS_PPATH, collectionName, RECORDS_PPATH, "*", field, "plain")}
 		if err := vault.ValidateAction(ctx, request); err != nil {
 			return nil, err
 		}
+	}
+
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
 
+	indexFilters := make(map[string]string)
+	for field, value := range filters {
 		if field == subject_id_field {
-			encryptedFilters[field] = value
+			indexFilters[field] = value
 			continue
 		}
 
-		val, err := vault.Priv.Encrypt(value)
+		fieldSchema, ok := col.Fields[field]
+		if !ok {
+			return nil, &ValueError{Msg: fmt.Sprintf("Field %s is not existent in the schema", field)}
+		}
+		if !fieldSchema.IsIndexed {
+			return nil, &ValueError{Msg: fmt.Sprintf("Field %s is not indexed and can't be searched", field)}
+		}
+		if value == Null {
+			return nil, &ValueError{Msg: fmt.Sprintf("Field %s can't be searched for null", field)}
+		}
+		value, err := normalizeFieldValue(fieldSchema, value)
 		if err != nil {
 			return nil, err
 		}
-		encryptedFilters[field] = val
+
+		index, err := vault.blindIndex(collectionName, field, value)
+		if err != nil {
+			return nil, err
+		}
+		indexFilters[field] = index
 	}
 
-	recordIds, err := vault.Db.SearchRecords(ctx, collectionName, encryptedFilters)
+	recordIds, err := vault.Db.SearchRecords(ctx, collectionName, indexFilters)
 	if err != nil {
 		return nil, err
 	}
@@ -402,16 +896,108 @@ func (vault Vault) UpdateRecord(
 		return err
 	}
 
-	encryptedRecord := make(Record)
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+
+	values := make(Record)
+	validationFailures := []*ValidationError{}
 	for recordFieldName, recordFieldValue := range record {
-		encryptedValue, err := vault.Priv.Encrypt(recordFieldValue)
+		if recordFieldName == subject_id_field {
+			continue
+		}
+
+		field, ok := col.Fields[recordFieldName]
+		if !ok {
+			return &ValueError{fmt.Sprintf("field %s does not exist on collection %s", recordFieldName, collectionName)}
+		}
+		if PTypeName(field.Type) == FileType {
+			// Replacing the value would leave its file behind
+			return &ValueError{Msg: fmt.Sprintf("field %s is a file, files are uploaded to and deleted from %s/<field> of their record", recordFieldName, FILES_PPATH)}
+		}
+
+		// Validate field PType, the ptypes and constraints failed by every field are returned
+		// together
+		normalized, failures, err := validateFieldValue(recordFieldName, field, recordFieldValue)
 		if err != nil {
 			return err
 		}
-		encryptedRecord[recordFieldName] = encryptedValue
+		validationFailures = append(validationFailures, failures...)
+
+		// Hashed once, only the encryption depends on the record being updated
+		if values[recordFieldName], err = vault.hashField(col, recordFieldName, normalized); err != nil {
+			return err
+		}
+	}
+
+	if len(validationFailures) > 0 {
+		return &ValidationErrors{validationFailures}
 	}
 
-	return vault.Db.UpdateRecord(ctx, collectionName, recordID, encryptedRecord)
+	return vault.writeRecord(ctx, col, recordID, func(current Record, priv Privatiser) (Record, error) {
+		// Values are encrypted with the key of the record's subject, which can't change
+		if subjectId, ok := record[subject_id_field]; ok && subjectId != current[subject_id_field] {
+			return nil, &ValueError{Msg: fmt.Sprintf("%s of a record can't be changed", subject_id_field)}
+		}
+		encryptedRecord := make(Record)
+		for fieldName, value := range values {
+			if err := vault.encryptField(priv, col, recordID, fieldName, value, encryptedRecord); err != nil {
+				return nil, err
+			}
+		}
+		return encryptedRecord, nil
+	})
+}
+
+// maxRecordWrites is how many times writeRecord tries to update a record modified concurrently.
+const maxRecordWrites = 5
+
+// writeRecord updates a record with the encrypted values update returns for it, along with its
+// MAC, which covers the values that aren't updated as well. The record is only written if it
+// wasn't modified since it was read, otherwise it's read and updated again, so concurrent
+// updates of different fields don't overwrite each other.
+func (vault Vault) writeRecord(ctx context.Context, col *Collection, recordID string, update func(current Record, priv Privatiser) (Record, error)) error {
+	colPriv, err := vault.collectionPrivatiser(col)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		current, err := vault.Db.GetRecord(ctx, col.Name, recordID)
+		if err != nil {
+			return err
+		}
+		if err := vault.verifyRecord(col, current); err != nil {
+			return err
+		}
+		priv, err := vault.recordPrivatiser(ctx, col, colPriv, current)
+		if err != nil {
+			return err
+		}
+		encryptedRecord, err := update(current, priv)
+		if err != nil {
+			return err
+		}
+
+		signedRecord := make(Record)
+		for fieldName, value := range current {
+			signedRecord[fieldName] = value
+		}
+		for fieldName, value := range encryptedRecord {
+			signedRecord[fieldName] = value
+		}
+		if encryptedRecord[record_mac_field], err = vault.recordMAC(col, signedRecord); err != nil {
+			return err
+		}
+
+		// The MAC covers every value, it changes whenever the record does
+		err = vault.Db.UpdateRecordIfUnchanged(ctx, col.Name, recordID, current, encryptedRecord)
+		var ce *ConflictError
+		if errors.As(err, &ce) && attempt < maxRecordWrites {
+			continue
+		}
+		return err
+	}
 }
 
 func (vault Vault) DeleteRecord(
@@ -423,7 +1009,26 @@ func (vault Vault) DeleteRecord(
 	if err := vault.ValidateAction(ctx, Request{principal, PolicyActionWrite, fmt.Sprintf("%s/%s%s", COLLECTIONS_PPATH, collectionName, RECORDS_PPATH)}); err != nil {
 		return err
 	}
-	return vault.Db.DeleteRecord(ctx, collectionName, recordID)
+
+	// Files are deleted along with their record and the records referencing it. Those of
+	// shredded subjects can't be found anymore, they are unreadable without the subject key
+	// anyway.
+	col, err := vault.Db.GetCollection(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+	files, err := vault.subjectFiles(ctx, col, recordID)
+	if err != nil {
+		vault.Logger.Warn(fmt.Sprintf("Error finding the files of record %s: %s", recordID, err))
+	}
+
+	if err := vault.Db.DeleteRecord(ctx, collectionName, recordID); err != nil {
+		return err
+	}
+	for _, file := range files {
+		vault.deleteBlob(ctx, file)
+	}
+	return nil
 }
 
 func (vault Vault) GetPrincipal(
@@ -446,9 +1051,11 @@ func (vault Vault) CreatePrincipal(
 		return err
 	}
 
-	// hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(principal.Password), bcrypt.DefaultCost)
-	hashedPassword, _ := vault.Priv.Encrypt(principal.Password)
-	principal.Password = string(hashedPassword)
+	hashedPassword, err := vault.passwordHasher().Hash(principal.Password)
+	if err != nil {
+		return err
+	}
+	principal.Password = hashedPassword
 	principal.Id = GenerateId("prin")
 	principal.CreatedAt = time.Now()
 	principal.UpdatedAt = time.Now()
@@ -480,27 +1087,74 @@ func (vault Vault) Login(
 		return nil, &ValueError{Msg: "username and password must not be empty"}
 	}
 
+	hasher := vault.passwordHasher()
 	dbPrincipal, err := vault.Db.GetPrincipal(ctx, username)
-	if err != nil {
-		vault.Logger.Error("Error getting principal")
+	if err != nil || dbPrincipal.Username == "" || dbPrincipal.Password == "" {
+		// Unknown usernames take as long to reject as wrong passwords
+		_, _ = hasher.Hash(password)
+		var nf *NotFoundError
+		if err != nil && !errors.As(err, &nf) {
+			vault.Logger.Error("Error getting principal")
+		}
 		return nil, &ForbiddenError{}
 	}
-	if dbPrincipal.Username == "" || dbPrincipal.Password == "" {
-		return nil, &ForbiddenError{}
+	if vault.Logins != nil && vault.Logins.contains(username, dbPrincipal.Password, password) {
+		return dbPrincipal, nil
 	}
 
-	decryptedPassword, err := vault.Priv.Decrypt(dbPrincipal.Password)
-	if err != nil {
-		vault.Logger.Error(fmt.Sprintf("Error decrypting password: %s", err.Error()))
-		return nil, &ForbiddenError{}
+	var rehash bool
+	if isPasswordHash(dbPrincipal.Password) {
+		ok, err := hasher.Verify(password, dbPrincipal.Password)
+		if err != nil {
+			vault.Logger.Error(fmt.Sprintf("Error verifying password: %s", err.Error()))
+			return nil, &ForbiddenError{}
+		}
+		if !ok {
+			return nil, &ForbiddenError{}
+		}
+		rehash = hasher.NeedsRehash(dbPrincipal.Password)
+	} else {
+		// Passwords of principals created before passwords were hashed are encrypted.
+		decryptedPassword, err := vault.Priv.Decrypt(dbPrincipal.Password)
+		if err != nil {
+			var se *SealedError
+			if errors.As(err, &se) {
+				return nil, err
+			}
+			vault.Logger.Error(fmt.Sprintf("Error decrypting password: %s", err.Error()))
+			return nil, &ForbiddenError{}
+		}
+		if subtle.ConstantTimeCompare([]byte(decryptedPassword), []byte(password)) != 1 {
+			return nil, &ForbiddenError{}
+		}
+		rehash = true
 	}
-	if decryptedPassword != password {
-		return nil, &ForbiddenError{}
+
+	if rehash {
+		// Failing to upgrade the stored password shouldn't prevent logging in.
+		if err := vault.rehashPassword(ctx, dbPrincipal, password); err != nil {
+			vault.Logger.Error(fmt.Sprintf("Error rehashing password of %s: %s", username, err.Error()))
+		}
+	}
+	if vault.Logins != nil {
+		vault.Logins.add(username, dbPrincipal.Password, password)
 	}
 
 	return dbPrincipal, nil
 }
 
+func (vault Vault) rehashPassword(ctx context.Context, principal *Principal, password string) error {
+	hashedPassword, err := vault.passwordHasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := vault.Db.UpdatePrincipalPassword(ctx, principal.Username, principal.Password, hashedPassword); err != nil {
+		return err
+	}
+	principal.Password = hashedPassword
+	return nil
+}
+
 func (vault Vault) CreatePolicy(
 	ctx context.Context,
 	principal Principal,
@@ -560,6 +1214,88 @@ func (vault Vault) GetPrincipalPolicies(
 	return policies, nil
 }
 
+// fieldAssociatedData identifies where a value is stored. Values are bound to it when they are
+// encrypted, so a ciphertext copied to another record or field fails to decrypt.
+func fieldAssociatedData(collectionName, recordId, fieldName string) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%s", collectionName, recordId, fieldName))
+}
+
+// encryptWithAD encrypts text bound to associatedData if priv supports it.
+func encryptWithAD(priv Privatiser, text string, associatedData []byte) (string, error) {
+	if adPriv, ok := priv.(AssociatedDataPrivatiser); ok {
+		return adPriv.EncryptWithAD(text, associatedData)
+	}
+	return priv.Encrypt(text)
+}
+
+func decryptWithAD(priv Privatiser, encodedText string, associatedData []byte) (string, error) {
+	if adPriv, ok := priv.(AssociatedDataPrivatiser); ok {
+		return adPriv.DecryptWithAD(encodedText, associatedData)
+	}
+	return priv.Decrypt(encodedText)
+}
+
+// encryptField adds the value of a field encrypted with priv to encryptedRecord, along with
+// its blind index if the field is indexed.
+func (vault Vault) encryptField(priv Privatiser, col *Collection, recordId string, fieldName string, value string, encryptedRecord Record) error {
+	if value == Null {
+		// Stored empty, without a ciphertext or blind index
+		encryptedRecord[fieldName] = ""
+		if col.Fields[fieldName].IsIndexed {
+			encryptedRecord[blindIndexColumn(fieldName)] = ""
+		}
+		return nil
+	}
+
+	encryptedValue, err := encryptWithAD(priv, value, fieldAssociatedData(col.Name, recordId, fieldName))
+	if err != nil {
+		return err
+	}
+	encryptedRecord[fieldName] = encryptedValue
+
+	if col.Fields[fieldName].IsIndexed {
+		index, err := vault.blindIndex(col.Name, fieldName, value)
+		if err != nil {
+			return err
+		}
+		encryptedRecord[blindIndexColumn(fieldName)] = index
+	}
+	return nil
+}
+
+// hashField returns the value to store for a field: a one-way hash for hashed fields, the value
+// itself otherwise. Hashes are still encrypted like other values so they are bound to their
+// record and field, and shredded with their subject. JSON documents are stored unmarked.
+func (vault Vault) hashField(col *Collection, fieldName string, value string) (string, error) {
+	if document, ok := ParseJSONValue(value); ok {
+		value = document
+	}
+	if col.Fields[fieldName].EncryptionMode() != FieldModeHashed || value == Null {
+		return value, nil
+	}
+	return vault.passwordHasher().Hash(value)
+}
+
+// decryptField decrypts the stored value of a field of a record, which is empty if it's null.
+func (vault Vault) decryptField(priv Privatiser, col *Collection, recordId string, fieldName string, value string) (string, error) {
+	if value == "" {
+		return Null, nil
+	}
+	return decryptWithAD(priv, value, fieldAssociatedData(col.Name, recordId, fieldName))
+}
+
+// Version of the blind indexes computed by blindIndex. Indexes of collections with an older
+// version are recomputed by BackfillBlindIndexes.
+const blindIndexVersion = 1
+
+// blindIndex computes the value stored alongside an indexed field to make it searchable.
+// The collection and field name are part of the signed message so equal values in
+// different fields don't share an index, and its prefix tells it apart from the other
+// messages signed by the vault.
+func (vault Vault) blindIndex(collectionName, fieldName, value string) (string, error) {
+	return vault.Signer.Sign(fmt.Sprintf("bidx:%s/%s/%s", collectionName, fieldName, value))
+}
+
 func (vault Vault) ValidateAction(
 	ctx context.Context,
 	request Request,
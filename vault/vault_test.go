@@ -1,9 +1,14 @@
 package vault
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -20,14 +25,14 @@ func initVault(t *testing.T) (Vault, VaultDB, Privatiser) {
 		panic(err)
 	}
 	db.Flush(ctx)
-	priv, _ := NewAESPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
+	priv, _ := NewAESGCMPrivatiser("abc&1*~#^2^#s0^=)^^7%b34")
 	signer, _ := NewHMACSigner([]byte("testkey"))
 	_ = db.CreatePolicy(ctx, &Policy{
 		Id:          "root",
 		Name:        "root",
 		Description: "",
 		Effect:      EffectAllow,
-		Actions:     []PolicyAction{PolicyActionRead, PolicyActionWrite},
+		Actions:     []PolicyAction{PolicyActionRead, PolicyActionWrite, PolicyActionDecrypt},
 		Resources:   []string{"*"},
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
@@ -199,6 +204,77 @@ func TestVault(t *testing.T) {
 		}
 	})
 
+	t.Run("can search records on indexed fields", func(t *testing.T) {
+		vault, db, _ := initVault(t)
+		col := Collection{Name: "customers", Fields: map[string]Field{
+			"email": {
+				Type:      "email",
+				IsIndexed: true,
+			},
+			"first_name": {
+				Type:      "string",
+				IsIndexed: false,
+			},
+		}}
+		_ = vault.CreateCollection(ctx, testPrincipal, &col)
+
+		firstId, err := vault.CreateRecord(ctx, testPrincipal, col.Name, Record{"email": "john@crawford.com", "first_name": "John"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		secondId, err := vault.CreateRecord(ctx, testPrincipal, col.Name, Record{"email": "john@crawford.com", "first_name": "John"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Identical values are stored as different ciphertexts
+		first, _ := db.GetRecord(ctx, col.Name, firstId)
+		second, _ := db.GetRecord(ctx, col.Name, secondId)
+		assert.NotEqual(t, first["email"], second["email"])
+
+		recordIds, err := vault.SearchRecords(ctx, testPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{firstId, secondId}, recordIds)
+
+		_, err = vault.SearchRecords(ctx, testPrincipal, col.Name, map[string]string{"first_name": "John"})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+
+		// Records written before blind indexes were introduced are indexed by the backfill
+		dbCol, err := db.GetCollection(ctx, col.Name)
+		assert.NoError(t, err)
+		first[blindIndexColumn("email")] = ""
+		mac, err := vault.recordMAC(dbCol, first)
+		assert.NoError(t, err)
+		assert.NoError(t, db.UpdateRecord(ctx, col.Name, firstId, Record{blindIndexColumn("email"): "", record_mac_field: mac}))
+		recordIds, err = vault.SearchRecords(ctx, testPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{secondId}, recordIds)
+
+		assert.NoError(t, vault.BackfillBlindIndexes(ctx))
+		recordIds, err = vault.SearchRecords(ctx, testPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{firstId, secondId}, recordIds)
+		_, err = vault.GetRecord(ctx, testPrincipal, col.Name, firstId, map[string]string{"email": "plain"})
+		assert.NoError(t, err)
+
+		// Indexes of an older version are recomputed
+		first, _ = db.GetRecord(ctx, col.Name, firstId)
+		first[blindIndexColumn("email")], _ = vault.Signer.Sign(col.Name + "/email/john@crawford.com")
+		mac, _ = vault.recordMAC(dbCol, first)
+		assert.NoError(t, db.UpdateRecord(ctx, col.Name, firstId, Record{blindIndexColumn("email"): first[blindIndexColumn("email")], record_mac_field: mac}))
+		db.(*SqlStore).db.Exec("UPDATE collections_metadata SET index_version = 0 WHERE name = ?", col.Name)
+		recordIds, _ = vault.SearchRecords(ctx, testPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.Equal(t, []string{secondId}, recordIds)
+
+		assert.NoError(t, vault.BackfillBlindIndexes(ctx))
+		recordIds, err = vault.SearchRecords(ctx, testPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{firstId, secondId}, recordIds)
+		dbCol, _ = db.GetCollection(ctx, col.Name)
+		assert.Equal(t, blindIndexVersion, dbCol.IndexVersion)
+	})
+
 	t.Run("cant store records with invalid fields", func(t *testing.T) {
 		vault, _, _ := initVault(t)
 		col := Collection{Name: "test_collection", Fields: map[string]Field{
@@ -441,6 +517,42 @@ func TestVaultLogin(t *testing.T) {
 			t.Fatal("Expected an error, got nil")
 		}
 	})
+
+	t.Run("passwords are stored hashed", func(t *testing.T) {
+		dbPrincipal, _ := vault.Db.GetPrincipal(ctx, testPrincipal.Username)
+		assert.True(t, isPasswordHash(dbPrincipal.Password))
+	})
+
+	t.Run("encrypted passwords are rehashed on login", func(t *testing.T) {
+		dbPrincipal, _ := vault.Db.GetPrincipal(ctx, testPrincipal.Username)
+		encryptedPassword, _ := vault.Priv.Encrypt(testPrincipal.Password)
+		err := vault.Db.UpdatePrincipalPassword(ctx, testPrincipal.Username, dbPrincipal.Password, encryptedPassword)
+		assert.NoError(t, err)
+
+		_, err = vault.Login(ctx, testPrincipal.Username, "invalid_password")
+		assert.Error(t, err)
+		_, err = vault.Login(ctx, testPrincipal.Username, testPrincipal.Password)
+		assert.NoError(t, err)
+
+		dbPrincipal, _ = vault.Db.GetPrincipal(ctx, testPrincipal.Username)
+		assert.True(t, isPasswordHash(dbPrincipal.Password))
+		_, err = vault.Login(ctx, testPrincipal.Username, testPrincipal.Password)
+		assert.NoError(t, err)
+	})
+
+	t.Run("verified credentials are cached", func(t *testing.T) {
+		cachingVault := vault
+		cachingVault.Logins, _ = NewLoginCache(time.Minute)
+		_, err := cachingVault.Login(ctx, testPrincipal.Username, testPrincipal.Password)
+		assert.NoError(t, err)
+		dbPrincipal, _ := vault.Db.GetPrincipal(ctx, testPrincipal.Username)
+		assert.True(t, cachingVault.Logins.contains(testPrincipal.Username, dbPrincipal.Password, testPrincipal.Password))
+
+		_, err = cachingVault.Login(ctx, testPrincipal.Username, "invalid_password")
+		assert.Error(t, err)
+		_, err = cachingVault.Login(ctx, "unknown_user", testPrincipal.Password)
+		assert.Error(t, err)
+	})
 }
 
 func TestTokens(t *testing.T) {
@@ -469,12 +581,12 @@ func TestTokens(t *testing.T) {
 	// create collections
 	err = vault.CreateCollection(ctx, rootPrincipal, &Collection{
 		Name:   "customers",
-		Fields: map[string]Field{"name": {"string", false}, "foo": {"string", false}},
+		Fields: map[string]Field{"name": {Type: "string", IsIndexed: false}, "foo": {Type: "string", IsIndexed: false}},
 	})
 	assert.NoError(t, err, "failed to create customer collection")
 	err = vault.CreateCollection(ctx, rootPrincipal, &Collection{
 		Name:   "employees",
-		Fields: map[string]Field{"name": {"string", false}, "foo": {"string", false}},
+		Fields: map[string]Field{"name": {Type: "string", IsIndexed: false}, "foo": {Type: "string", IsIndexed: false}},
 	})
 	assert.NoError(t, err, "failed to create employees collection")
 
@@ -524,3 +636,703 @@ func TestTokens(t *testing.T) {
 		assert.ErrorAs(t, err, &fe)
 	})
 }
+
+func TestFieldModes(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	vault.Hasher, _ = NewArgon2Hasher(1, 64, 1)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+	t.Run("fields default to a mode matching their index", func(t *testing.T) {
+		assert.Equal(t, FieldModeDeterministic, Field{Type: "name", IsIndexed: true}.EncryptionMode())
+		assert.Equal(t, FieldModeRandomized, Field{Type: "name"}.EncryptionMode())
+		assert.Equal(t, FieldModeHashed, Field{Type: "string", Mode: FieldModeHashed}.EncryptionMode())
+	})
+
+	t.Run("only deterministic fields can be indexed", func(t *testing.T) {
+		for _, mode := range []FieldMode{FieldModeRandomized, FieldModeHashed} {
+			col := Collection{Name: "invalid", Fields: map[string]Field{
+				"name": {Type: "name", IsIndexed: true, Mode: mode},
+			}}
+			err := vault.CreateCollection(ctx, rootPrincipal, &col)
+			var ve *ValueError
+			assert.ErrorAs(t, err, &ve)
+		}
+
+		col := Collection{Name: "searchable", Fields: map[string]Field{
+			"name": {Type: "name", Mode: FieldModeDeterministic},
+		}}
+		assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+		dbCol, _ := db.GetCollection(ctx, col.Name)
+		assert.True(t, dbCol.Fields["name"].IsIndexed)
+	})
+
+	col := Collection{Name: "accounts", Fields: map[string]Field{
+		"email":           {Type: "email", Mode: FieldModeDeterministic},
+		"name":            {Type: "name", Mode: FieldModeRandomized},
+		"security_answer": {Type: "string", Mode: FieldModeHashed},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+	recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{
+		"email":           "john@crawford.com",
+		"name":            "John Crawford",
+		"security_answer": "Rex",
+	})
+	assert.NoError(t, err)
+
+	t.Run("only deterministic fields can be searched", func(t *testing.T) {
+		recordIds, err := vault.SearchRecords(ctx, rootPrincipal, col.Name, map[string]string{"email": "john@crawford.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{recordId}, recordIds)
+
+		_, err = vault.SearchRecords(ctx, rootPrincipal, col.Name, map[string]string{"name": "John Crawford"})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("hashed fields can be verified but not read", func(t *testing.T) {
+		_, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"security_answer": "plain"})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+
+		valid, err := vault.VerifyField(ctx, rootPrincipal, col.Name, recordId, "security_answer", "Rex")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		valid, err = vault.VerifyField(ctx, rootPrincipal, col.Name, recordId, "security_answer", "Fido")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("only hashed fields can be verified", func(t *testing.T) {
+		_, err := vault.VerifyField(ctx, rootPrincipal, col.Name, recordId, "name", "John Crawford")
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+}
+
+func TestNormalizedFields(t *testing.T) {
+	ctx := context.Background()
+	vault, _, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+	col := Collection{Name: "payees", Fields: map[string]Field{
+		"iban": {Type: "iban", IsIndexed: true},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+	recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"iban": "gb82 west 1234 5698 7654 32"})
+	assert.NoError(t, err)
+
+	t.Run("values are stored normalized", func(t *testing.T) {
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"iban": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "GB82WEST12345698765432", record["iban"])
+	})
+
+	t.Run("values are searched normalized", func(t *testing.T) {
+		for _, value := range []string{"GB82WEST12345698765432", "GB82 WEST 1234 5698 7654 32"} {
+			recordIds, err := vault.SearchRecords(ctx, rootPrincipal, col.Name, map[string]string{"iban": value})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{recordId}, recordIds)
+		}
+	})
+
+	t.Run("updated values are stored normalized", func(t *testing.T) {
+		assert.NoError(t, vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"iban": "DE89 3704 0044 0532 0130 00"}))
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"iban": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "DE89370400440532013000", record["iban"])
+	})
+}
+
+func TestRegexFields(t *testing.T) {
+	ctx := context.Background()
+	vault, _, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+
+	t.Run("cannot create a regex field with an invalid pattern", func(t *testing.T) {
+		col := Collection{Name: "invalid", Fields: map[string]Field{
+			"account_number": {Type: "regex", Pattern: `([A-Z]{2}`},
+		}}
+		err := vault.CreateCollection(ctx, rootPrincipal, &col)
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("values must match the pattern and are masked by capture group", func(t *testing.T) {
+		col := Collection{Name: "accounts", Fields: map[string]Field{
+			"account_number": {Type: "regex", Pattern: `([A-Z]{2})-(\d{6})`, Mask: "**-$2"},
+		}}
+		assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+
+		_, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"account_number": "AB-12"})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"account_number": "AB-123456"})
+		assert.NoError(t, err)
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"account_number": "masked"})
+		assert.NoError(t, err)
+		assert.Equal(t, "**-123456", record["account_number"])
+
+		err = vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"account_number": "invalid"})
+		assert.ErrorAs(t, err, &ve)
+	})
+}
+
+func TestAddressFields(t *testing.T) {
+	ctx := context.Background()
+	vault, _, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	col := Collection{Name: "residents", Fields: map[string]Field{
+		"address": {Type: "address"},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+
+	t.Run("structured addresses are stored normalized", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{
+			"address": `{"lines": ["10  Downing Street"], "city": "London", "postcode": "sw1a 2aa", "country": "gb"}`,
+		})
+		assert.NoError(t, err)
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"address": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"lines":["10 Downing Street"],"city":"London","postcode":"SW1A 2AA","country":"GB"}`, record["address"])
+		record, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"address": "postcode_prefix"})
+		assert.NoError(t, err)
+		assert.Equal(t, "SW1A", record["address"])
+	})
+
+	t.Run("structured addresses can be written as objects", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{
+			"address": JSONValue(`{"lines": ["1 Market St"], "city": "San Francisco", "region": "CA", "postcode": "94105", "country": "US"}`),
+		})
+		assert.NoError(t, err)
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"address": "city"})
+		assert.NoError(t, err)
+		assert.Equal(t, "San Francisco", record["address"])
+	})
+
+	t.Run("free text addresses can be stored and read back", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"address": "1 Market St\nSan Francisco, CA 94105"})
+		assert.NoError(t, err)
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"address": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "1 Market St\nSan Francisco, CA 94105", record["address"])
+		record, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"address": "country"})
+		assert.NoError(t, err)
+		assert.Equal(t, "", record["address"])
+	})
+}
+
+func TestRegisteredPTypeFields(t *testing.T) {
+	ctx := context.Background()
+	vault, _, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	_ = RegisterPType(PTypeDefinition{
+		Name: "medical_record_number",
+		Validate: func(value string) error {
+			if len(value) != 10 || !allDigits(value) {
+				return &ValueError{Msg: "Invalid medical record number"}
+			}
+			return nil
+		},
+		Formats: map[string]PTypeFormat{
+			LAST4_FORMAT: last4Format,
+		},
+	})
+
+	t.Run("cannot create a field of an unknown type", func(t *testing.T) {
+		col := Collection{Name: "invalid", Fields: map[string]Field{
+			"mrn": {Type: "unknown_number"},
+		}}
+		err := vault.CreateCollection(ctx, rootPrincipal, &col)
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("values of registered ptypes are validated and formatted", func(t *testing.T) {
+		col := Collection{Name: "patients", Fields: map[string]Field{
+			"mrn": {Type: "medical_record_number"},
+		}}
+		assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+
+		_, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"mrn": "12345"})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"mrn": "1234567890"})
+		assert.NoError(t, err)
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"mrn": "last4"})
+		assert.NoError(t, err)
+		assert.Equal(t, "7890", record["mrn"])
+	})
+}
+
+func TestGeneralizedFields(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	col := Collection{Name: "members", Fields: map[string]Field{
+		"dob": {Type: "date", Generalizations: map[string]Generalization{
+			"ageband": {Bands: []int{18, 25, 35, 50, 65}},
+			"decade":  {Truncate: DateTruncationDecade},
+		}},
+		"income": {Type: "integer", Generalizations: map[string]Generalization{
+			"bucket": {Width: 10000},
+		}},
+	}}
+	_ = vault.CreateCollection(ctx, rootPrincipal, &col)
+	recordId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"dob": "1950-01-01", "income": "43500"})
+
+	t.Run("cannot declare invalid generalizations", func(t *testing.T) {
+		err := vault.CreateCollection(ctx, rootPrincipal, &Collection{Name: "invalid", Fields: map[string]Field{
+			"name": {Type: "name", Generalizations: map[string]Generalization{"bucket": {Width: 10}}},
+		}})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+
+		err = vault.CreateCollection(ctx, rootPrincipal, &Collection{Name: "invalid", Fields: map[string]Field{
+			"dob": {Type: "date", Generalizations: map[string]Generalization{"year": {Width: 10}}},
+		}})
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("can read generalized values", func(t *testing.T) {
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"dob": "ageband", "income": "bucket"})
+		assert.NoError(t, err)
+		assert.Equal(t, "65+", record["dob"])
+		assert.Equal(t, "40000-49999", record["income"])
+
+		record, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"dob": "decade"})
+		assert.NoError(t, err)
+		assert.Equal(t, "1950s", record["dob"])
+	})
+
+	t.Run("generalized values are authorised per format", func(t *testing.T) {
+		_ = db.CreatePolicy(ctx, &Policy{
+			Id:        "read-member-agebands",
+			Name:      "read-member-agebands",
+			Effect:    EffectAllow,
+			Actions:   []PolicyAction{PolicyActionRead},
+			Resources: []string{"/collections/members/records/*/dob.ageband"},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		analyst := Principal{Username: "analyst", Policies: []string{"read-member-agebands"}}
+
+		record, err := vault.GetRecord(ctx, analyst, col.Name, recordId, map[string]string{"dob": "ageband"})
+		assert.NoError(t, err)
+		assert.Equal(t, "65+", record["dob"])
+
+		_, err = vault.GetRecord(ctx, analyst, col.Name, recordId, map[string]string{"dob": "plain"})
+		var fe *ForbiddenError
+		assert.ErrorAs(t, err, &fe)
+	})
+}
+
+func TestOptionalFields(t *testing.T) {
+	ctx := context.Background()
+	vault, _, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	optional := false
+	country := "GB"
+	col := Collection{Name: "customers", Fields: map[string]Field{
+		"name":    {Type: "name"},
+		"phone":   {Type: "phone_number", Required: &optional, Nullable: true},
+		"email":   {Type: "email", IsIndexed: true, Nullable: true},
+		"country": {Type: "string", Required: &optional, Default: &country},
+	}}
+	_ = vault.CreateCollection(ctx, rootPrincipal, &col)
+
+	t.Run("optional fields must be nullable or have a valid default", func(t *testing.T) {
+		invalidDefault := "not an email"
+		for _, field := range []Field{
+			{Type: "string", Required: &optional},
+			{Type: "email", Default: &invalidDefault},
+		} {
+			err := vault.CreateCollection(ctx, rootPrincipal, &Collection{Name: "invalid", Fields: map[string]Field{"field": field}})
+			var ve *ValueError
+			assert.ErrorAs(t, err, &ve)
+		}
+	})
+
+	t.Run("fields not set take their default or null", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": Null})
+		assert.NoError(t, err)
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"name": "masked", "phone": "masked", "email": "plain", "country": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "J*** C*******", record["name"])
+		assert.Equal(t, Null, record["phone"])
+		assert.Equal(t, Null, record["email"])
+		assert.Equal(t, "GB", record["country"])
+	})
+
+	t.Run("required fields must be set and only nullable fields can be null", func(t *testing.T) {
+		_, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford"})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+		assert.Equal(t, []*ValidationError{{FailedField: "email", Tag: "required"}}, ve.Errors)
+
+		_, err = vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": Null, "email": Null})
+		assert.ErrorAs(t, err, &ve)
+		assert.Equal(t, []*ValidationError{{FailedField: "name", Tag: "nullable"}}, ve.Errors)
+	})
+
+	t.Run("every invalid and missing field is returned", func(t *testing.T) {
+		_, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": Null, "phone": "12345"})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+		failed := map[string]string{}
+		for _, failure := range ve.Errors {
+			failed[failure.FailedField] = failure.Tag
+		}
+		assert.Equal(t, map[string]string{"name": "nullable", "phone": "phone_number", "email": "required"}, failed)
+	})
+
+	t.Run("nulls are stored without encryption", func(t *testing.T) {
+		recordId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": Null})
+		stored, err := vault.Db.GetRecord(ctx, col.Name, recordId)
+		assert.NoError(t, err)
+		assert.Equal(t, "", stored["email"])
+		assert.Equal(t, "", stored[blindIndexColumn("email")])
+		assert.Equal(t, "", stored["phone"])
+	})
+
+	t.Run("can update fields to and from null", func(t *testing.T) {
+		recordId, _ := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "email": Null})
+
+		err := vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"email": "john@crawford.com", "phone": "+447911123456"})
+		assert.NoError(t, err)
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"name": "plain", "email": "plain", "phone": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, "John Crawford", record["name"])
+		assert.Equal(t, "john@crawford.com", record["email"])
+		assert.Equal(t, "+447911123456", record["phone"])
+
+		err = vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"phone": Null})
+		assert.NoError(t, err)
+		record, err = vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"phone": "masked"})
+		assert.NoError(t, err)
+		assert.Equal(t, Null, record["phone"])
+	})
+}
+
+func TestRecordJSON(t *testing.T) {
+	var record Record
+	err := json.Unmarshal([]byte(`{"name": "John Crawford", "phone": null}`), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, Record{"name": "John Crawford", "phone": Null}, record)
+
+	encoded, err := json.Marshal(record)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "John Crawford", "phone": null}`, string(encoded))
+
+	err = json.Unmarshal([]byte(`{"age": 42}`), &record)
+	assert.Error(t, err)
+
+	err = json.Unmarshal([]byte(`{"history": [{"postcode": "SW1A 2AA"}], "prefs": {"theme": "dark"}}`), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, Record{"history": JSONValue(`[{"postcode":"SW1A 2AA"}]`), "prefs": JSONValue(`{"theme":"dark"}`)}, record)
+
+	encoded, err = json.Marshal(record)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"history": [{"postcode": "SW1A 2AA"}], "prefs": {"theme": "dark"}}`, string(encoded))
+}
+
+func TestConstrainedFields(t *testing.T) {
+	ctx := context.Background()
+	vault, _, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	minAge, maxAge := 0, 150
+	col := Collection{Name: "patients", Fields: map[string]Field{
+		"status": {Type: "string", Constraints: &FieldConstraints{Enum: []string{"admitted", "discharged"}}},
+		"age":    {Type: "integer", Constraints: &FieldConstraints{Min: &minAge, Max: &maxAge}},
+		"dob":    {Type: "date", Constraints: &FieldConstraints{MinDate: "1900-01-01"}},
+	}}
+	_ = vault.CreateCollection(ctx, rootPrincipal, &col)
+
+	t.Run("cannot create a field with invalid constraints", func(t *testing.T) {
+		err := vault.CreateCollection(ctx, rootPrincipal, &Collection{Name: "invalid", Fields: map[string]Field{
+			"status": {Type: "string", Constraints: &FieldConstraints{Min: &minAge}},
+		}})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("every failing field is returned", func(t *testing.T) {
+		_, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"status": "unknown", "age": "200", "dob": "1850-01-01"})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+		failed := map[string]string{}
+		for _, failure := range ve.Errors {
+			failed[failure.FailedField] = failure.Tag
+		}
+		assert.Equal(t, map[string]string{"status": "enum", "age": "max", "dob": "min_date"}, failed)
+	})
+
+	t.Run("constraints are checked on updates", func(t *testing.T) {
+		recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"status": "admitted", "age": "42", "dob": "1981-01-01"})
+		assert.NoError(t, err)
+
+		err = vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"age": "-1"})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+		assert.Len(t, ve.Errors, 1)
+
+		assert.NoError(t, vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"status": "discharged"}))
+	})
+}
+
+func TestJSONFields(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	col := Collection{Name: "members", Fields: map[string]Field{
+		"name": {Type: "name"},
+		"history": {Type: "json", Paths: map[string]JSONPath{
+			"[*].postcode": {Type: "string"},
+			"[*].moved_in": {Type: "date"},
+		}},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+
+	t.Run("cannot create a json field with invalid paths", func(t *testing.T) {
+		err := vault.CreateCollection(ctx, rootPrincipal, &Collection{Name: "invalid", Fields: map[string]Field{
+			"history": {Type: "json", Paths: map[string]JSONPath{"[*].postcode": {Type: "unknown"}}},
+		}})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	history := JSONValue(`[{"moved_in":"2010-05-01","postcode":"SW1A 2AA"},{"moved_in":"2015-09-30","postcode":"LS1 4AP"}]`)
+	recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford", "history": history})
+	assert.NoError(t, err)
+
+	t.Run("can get a json field and paths into it", func(t *testing.T) {
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{
+			"history":               "plain",
+			"history[*].postcode":   "masked",
+			"history[1].moved_in":   "year",
+			"history[0].other_path": "plain",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, history, record["history"])
+		assert.Equal(t, JSONValue(`["*","*"]`), record["history[*].postcode"])
+		assert.Equal(t, "2015", record["history[1].moved_in"])
+		assert.Equal(t, Null, record["history[0].other_path"])
+	})
+
+	t.Run("paths can only be requested into json fields", func(t *testing.T) {
+		_, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"name.first": "plain"})
+		var ve *ValueError
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("sensitive paths are validated on write", func(t *testing.T) {
+		err := vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"history": JSONValue(`[{"moved_in":"yesterday"}]`)})
+		var ve *ValidationErrors
+		assert.ErrorAs(t, err, &ve)
+
+		_, err = vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": JSONValue(`{"first":"John"}`), "history": "[]"})
+		assert.ErrorAs(t, err, &ve)
+	})
+
+	t.Run("paths are protected by policies", func(t *testing.T) {
+		_ = db.CreatePolicy(ctx, &Policy{
+			Id:        "read-member-postcodes",
+			Name:      "read-member-postcodes",
+			Effect:    EffectAllow,
+			Actions:   []PolicyAction{PolicyActionRead},
+			Resources: []string{"/collections/members/records/*/history[*].postcode.masked"},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		principal := Principal{Username: "analyst", Policies: []string{"read-member-postcodes"}}
+
+		_, err := vault.GetRecord(ctx, principal, col.Name, recordId, map[string]string{"history[*].postcode": "masked"})
+		assert.NoError(t, err)
+		_, err = vault.GetRecord(ctx, principal, col.Name, recordId, map[string]string{"history": "plain"})
+		var forbiddenErr *ForbiddenError
+		assert.ErrorAs(t, err, &forbiddenErr)
+	})
+
+	t.Run("sensitive paths are masked in documents read plain unless they can be read plain", func(t *testing.T) {
+		_ = db.CreatePolicy(ctx, &Policy{
+			Id:        "read-member-history",
+			Name:      "read-member-history",
+			Effect:    EffectAllow,
+			Actions:   []PolicyAction{PolicyActionRead},
+			Resources: []string{"/collections/members/records/*/history*"},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		_ = db.CreatePolicy(ctx, &Policy{
+			Id:        "deny-member-postcodes",
+			Name:      "deny-member-postcodes",
+			Effect:    EffectDeny,
+			Actions:   []PolicyAction{PolicyActionRead},
+			Resources: []string{"/collections/members/records/*/history[*].postcode.plain"},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		principal := Principal{Username: "historian", Policies: []string{"read-member-history", "deny-member-postcodes"}}
+
+		record, err := vault.GetRecord(ctx, principal, col.Name, recordId, map[string]string{"history": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, JSONValue(`[{"moved_in":"2010-05-01","postcode":"*"},{"moved_in":"2015-09-30","postcode":"*"}]`), record["history"])
+	})
+}
+
+func TestFileFields(t *testing.T) {
+	ctx := context.Background()
+	vault, db, _ := initVault(t)
+	blobDir := t.TempDir()
+	vault.Blobs, _ = NewLocalBlobStore(blobDir)
+	rootPrincipal := Principal{Username: "root", Policies: []string{"root"}}
+	col := Collection{Name: "applicants", Fields: map[string]Field{
+		"name":     {Type: "name"},
+		"passport": {Type: "file", MaxSize: 1024, ContentTypes: []string{"application/pdf"}},
+	}}
+	assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &col))
+
+	recordId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "John Crawford"})
+	assert.NoError(t, err)
+	passport := []byte("%PDF-1.4\n% passport of John Crawford\n")
+
+	t.Run("records are created without files", func(t *testing.T) {
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"passport": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, Null, record["passport"])
+
+		_, _, err = vault.GetFile(ctx, rootPrincipal, col.Name, recordId, "passport")
+		var notFoundErr *NotFoundError
+		assert.ErrorAs(t, err, &notFoundErr)
+	})
+
+	t.Run("can upload and download a file", func(t *testing.T) {
+		info, err := vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "../passport.pdf", bytes.NewReader(passport))
+		assert.NoError(t, err)
+		assert.Equal(t, "passport.pdf", info.Filename)
+		assert.Equal(t, "application/pdf", info.ContentType)
+		assert.Equal(t, int64(len(passport)), info.Size)
+
+		info, contents, err := vault.GetFile(ctx, rootPrincipal, col.Name, recordId, "passport")
+		assert.NoError(t, err)
+		downloaded, err := io.ReadAll(contents)
+		assert.NoError(t, err)
+		assert.NoError(t, contents.Close())
+		assert.Equal(t, passport, downloaded)
+		assert.Equal(t, "passport.pdf", info.Filename)
+
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"passport": "masked"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, fmt.Sprintf(`{"content_type": "application/pdf", "size": %d}`, len(passport)), record["passport"])
+	})
+
+	t.Run("replacing a file deletes the previous one", func(t *testing.T) {
+		_, err := vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "passport-2.pdf", bytes.NewReader(passport))
+		assert.NoError(t, err)
+		blobs, _ := os.ReadDir(blobDir)
+		assert.Len(t, blobs, 1)
+	})
+
+	t.Run("concurrent uploads replace each other's file", func(t *testing.T) {
+		// Another file is uploaded between the read and the write of the record
+		interleaved := vault
+		interleaved.Db = &interleavedDB{VaultDB: db, interleave: func() {
+			_, err := vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "passport-3.pdf", bytes.NewReader(passport))
+			assert.NoError(t, err)
+		}}
+		_, err := interleaved.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "passport-4.pdf", bytes.NewReader(passport))
+		assert.NoError(t, err)
+
+		blobs, _ := os.ReadDir(blobDir)
+		assert.Len(t, blobs, 1)
+		info, contents, err := vault.GetFile(ctx, rootPrincipal, col.Name, recordId, "passport")
+		assert.NoError(t, err)
+		_ = contents.Close()
+		assert.Equal(t, "passport-4.pdf", info.Filename)
+	})
+
+	t.Run("uploads are checked against the field", func(t *testing.T) {
+		var ve *ValueError
+		_, err := vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "passport.html", strings.NewReader("<html><body>passport</body></html>"))
+		assert.ErrorAs(t, err, &ve)
+		_, err = vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "passport.pdf", bytes.NewReader(append(passport, make([]byte, 1024)...)))
+		assert.ErrorAs(t, err, &ve)
+		_, err = vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "name", "passport.pdf", bytes.NewReader(passport))
+		assert.ErrorAs(t, err, &ve)
+		err = vault.UpdateRecord(ctx, rootPrincipal, col.Name, recordId, Record{"passport": "{}"})
+		assert.ErrorAs(t, err, &ve)
+
+		blobs, _ := os.ReadDir(blobDir)
+		assert.Len(t, blobs, 1)
+		_, contents, err := vault.GetFile(ctx, rootPrincipal, col.Name, recordId, "passport")
+		assert.NoError(t, err)
+		downloaded, _ := io.ReadAll(contents)
+		_ = contents.Close()
+		assert.Equal(t, passport, downloaded)
+	})
+
+	t.Run("files are protected by policies", func(t *testing.T) {
+		_ = db.CreatePolicy(ctx, &Policy{
+			Id:        "read-applicant-fields",
+			Name:      "read-applicant-fields",
+			Effect:    EffectAllow,
+			Actions:   []PolicyAction{PolicyActionRead},
+			Resources: []string{"/collections/applicants/records/*/passport.masked"},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		reviewer := Principal{Username: "reviewer", Policies: []string{"read-applicant-fields"}}
+
+		_, err := vault.GetRecord(ctx, reviewer, col.Name, recordId, map[string]string{"passport": "masked"})
+		assert.NoError(t, err)
+		_, _, err = vault.GetFile(ctx, reviewer, col.Name, recordId, "passport")
+		var forbiddenErr *ForbiddenError
+		assert.ErrorAs(t, err, &forbiddenErr)
+	})
+
+	t.Run("can delete a file", func(t *testing.T) {
+		assert.NoError(t, vault.DeleteFile(ctx, rootPrincipal, col.Name, recordId, "passport"))
+		record, err := vault.GetRecord(ctx, rootPrincipal, col.Name, recordId, map[string]string{"passport": "plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, Null, record["passport"])
+		blobs, _ := os.ReadDir(blobDir)
+		assert.Empty(t, blobs)
+	})
+
+	t.Run("deleting a record deletes its files", func(t *testing.T) {
+		_, err := vault.PutFile(ctx, rootPrincipal, col.Name, recordId, "passport", "passport.pdf", bytes.NewReader(passport))
+		assert.NoError(t, err)
+		assert.NoError(t, vault.DeleteRecord(ctx, rootPrincipal, col.Name, recordId))
+		blobs, _ := os.ReadDir(blobDir)
+		assert.Empty(t, blobs)
+	})
+
+	t.Run("shredding a subject deletes its files and those of its children", func(t *testing.T) {
+		documents := Collection{Name: "applicant_documents", Parent: col.Name, Fields: map[string]Field{
+			"scan": {Type: "file"},
+		}}
+		assert.NoError(t, vault.CreateCollection(ctx, rootPrincipal, &documents))
+		subjectId, err := vault.CreateRecord(ctx, rootPrincipal, col.Name, Record{"name": "Jane Crawford"})
+		assert.NoError(t, err)
+		documentId, err := vault.CreateRecord(ctx, rootPrincipal, documents.Name, Record{"subject_id": subjectId})
+		assert.NoError(t, err)
+		_, err = vault.PutFile(ctx, rootPrincipal, col.Name, subjectId, "passport", "passport.pdf", bytes.NewReader(passport))
+		assert.NoError(t, err)
+		_, err = vault.PutFile(ctx, rootPrincipal, documents.Name, documentId, "scan", "scan.pdf", bytes.NewReader(passport))
+		assert.NoError(t, err)
+		blobs, _ := os.ReadDir(blobDir)
+		assert.Len(t, blobs, 2)
+
+		_, err = vault.ShredSubject(ctx, rootPrincipal, col.Name, subjectId)
+		assert.NoError(t, err)
+		blobs, _ = os.ReadDir(blobDir)
+		assert.Empty(t, blobs)
+	})
+}